@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"github.com/shamaton/msgpack/v2"
+
+	"github.com/pixie-sh/core-go/pkg/models/serializer"
+)
+
+// Codec encodes the values of a TypedCache
+type Codec[T any] interface {
+	Encode(value T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec json codec through the serializer
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(value T) ([]byte, error) {
+	// pointer so string and []byte values are encoded as json too
+	return serializer.Serialize(&value)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	return value, serializer.Deserialize(data, &value, false)
+}
+
+// MsgpackCodec msgpack codec, more compact and faster than json
+type MsgpackCodec[T any] struct{}
+
+func (MsgpackCodec[T]) Encode(value T) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (MsgpackCodec[T]) Decode(data []byte) (T, error) {
+	var value T
+	return value, msgpack.Unmarshal(data, &value)
+}
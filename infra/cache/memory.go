@@ -0,0 +1,429 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+	"github.com/redis/go-redis/v9"
+)
+
+const defaultMemoryCacheMaxEntries = 10000
+
+type MemoryCacheConfiguration struct {
+	MaxEntries int `json:"max_entries"` // least recently used entries are evicted past it; 10000 if not set
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	members   map[string]struct{} // set entries
+	expiresAt time.Time           // zero for no expiration
+}
+
+// MemoryCache in process LRU cache with TTLs, implementing SetCache with the redis semantics:
+// misses are IsEmptyError, TTL is -2 for missing keys and -1 for keys without expiration
+type MemoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	lru        *list.List
+	now        func() time.Time
+}
+
+func NewMemoryCache(_ context.Context, config MemoryCacheConfiguration) *MemoryCache {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = defaultMemoryCacheMaxEntries
+	}
+
+	return &MemoryCache{
+		maxEntries: config.MaxEntries,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+func (m *MemoryCache) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, err := m.valueUnlocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, redis.Nil
+	}
+
+	return append([]byte(nil), entry.value...), nil
+}
+
+func (m *MemoryCache) Peek(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.getUnlocked(key) == nil {
+		return errors.New("memory cache: key not found")
+	}
+	return nil
+}
+
+func (m *MemoryCache) Increment(ctx context.Context, key string) (int64, error) {
+	return m.incrementBy(key, 1)
+}
+
+func (m *MemoryCache) Decrement(ctx context.Context, key string, by ...int64) (int64, error) {
+	decrement := int64(1)
+	if len(by) > 0 {
+		decrement = by[0]
+	}
+	return m.incrementBy(key, -decrement)
+}
+
+func (m *MemoryCache) incrementBy(key string, by int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, err := m.valueUnlocked(key)
+	if err != nil {
+		return -2, err
+	}
+
+	var current int64
+	var expiresAt time.Time
+	if entry != nil {
+		current, err = strconv.ParseInt(string(entry.value), 10, 64)
+		if err != nil {
+			return -2, errors.New("memory cache: value of %s is not an integer", key)
+		}
+		expiresAt = entry.expiresAt
+	}
+
+	current += by
+	m.setUnlocked(&memoryEntry{key: key, value: []byte(strconv.FormatInt(current, 10)), expiresAt: expiresAt})
+	return current, nil
+}
+
+func (m *MemoryCache) SetEX(_ context.Context, key string, value []byte, expiration ...time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.setUnlocked(&memoryEntry{key: key, value: append([]byte(nil), value...), expiresAt: m.expiresAt(expiration...)})
+	return nil
+}
+
+func (m *MemoryCache) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteUnlocked(key)
+	return nil
+}
+
+// Scan redis glob pattern scan; the cursor is the offset in the sorted keys, 0 once all are returned
+func (m *MemoryCache) Scan(_ context.Context, pattern string, rows int64, fromCursor ...uint64) ([]string, uint64, error) {
+	matcher, err := globToRegexp(pattern)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	m.mu.Lock()
+	var keys []string
+	for key := range m.entries {
+		if m.getUnlocked(key) != nil && matcher.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	m.mu.Unlock()
+	sort.Strings(keys)
+
+	var cursor uint64
+	if len(fromCursor) > 0 {
+		cursor = fromCursor[0]
+	}
+	if rows <= 0 {
+		rows = 10
+	}
+	if cursor >= uint64(len(keys)) {
+		return []string{}, 0, nil
+	}
+
+	end := cursor + uint64(rows)
+	if end >= uint64(len(keys)) {
+		return keys[cursor:], 0, nil
+	}
+	return keys[cursor:end], end, nil
+}
+
+func (m *MemoryCache) TTL(_ context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.getUnlocked(key)
+	switch {
+	case entry == nil:
+		return -2, nil
+	case entry.expiresAt.IsZero():
+		return -1, nil
+	default:
+		return entry.expiresAt.Sub(m.now()), nil
+	}
+}
+
+func (m *MemoryCache) BatchSetEX(ctx context.Context, keyPairs []BatchSetExModel) error {
+	for _, pair := range keyPairs {
+		var expiration []time.Duration
+		if pair.Duration != nil {
+			expiration = append(expiration, *pair.Duration)
+		}
+
+		_ = m.SetEX(ctx, pair.Key, pair.Value, expiration...)
+	}
+	return nil
+}
+
+func (m *MemoryCache) BatchDelete(ctx context.Context, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		m.deleteUnlocked(key)
+	}
+	return nil
+}
+
+func (m *MemoryCache) BatchOperations(ctx context.Context, operations []BatchOperation) error {
+	for _, op := range operations {
+		var expiration []time.Duration
+		if op.Duration != nil {
+			expiration = append(expiration, *op.Duration)
+		}
+
+		switch op.Type {
+		case SETEX:
+			_ = m.SetEX(ctx, op.Key, op.Value, expiration...)
+		case SADD:
+			_, err := m.SetAdd(ctx, op.Key, op.Members...)
+			if err != nil {
+				return err
+			}
+			if op.Duration != nil {
+				m.expire(op.Key, *op.Duration)
+			}
+		default:
+			return errors.New("unsupported batch operation type: %s", op.Type)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryCache) SetAdd(_ context.Context, key string, members ...interface{}) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, err := m.setEntryUnlocked(key)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		entry = &memoryEntry{key: key, members: make(map[string]struct{})}
+		m.setUnlocked(entry)
+	}
+
+	var added int64
+	for _, member := range members {
+		value := fmt.Sprint(member)
+		if _, exists := entry.members[value]; !exists {
+			entry.members[value] = struct{}{}
+			added++
+		}
+	}
+	return added, nil
+}
+
+func (m *MemoryCache) SetMembers(_ context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, err := m.setEntryUnlocked(key)
+	if err != nil || entry == nil {
+		return []string{}, err
+	}
+
+	members := make([]string, 0, len(entry.members))
+	for member := range entry.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (m *MemoryCache) SetRem(_ context.Context, key string, members ...interface{}) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, err := m.setEntryUnlocked(key)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+
+	var removed int64
+	for _, member := range members {
+		value := fmt.Sprint(member)
+		if _, exists := entry.members[value]; exists {
+			delete(entry.members, value)
+			removed++
+		}
+	}
+	if len(entry.members) == 0 {
+		m.deleteUnlocked(key)
+	}
+	return removed, nil
+}
+
+func (m *MemoryCache) SetCard(_ context.Context, key string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, err := m.setEntryUnlocked(key)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+	return int64(len(entry.members)), nil
+}
+
+func (m *MemoryCache) SetIsMember(_ context.Context, key string, member interface{}) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, err := m.setEntryUnlocked(key)
+	if err != nil || entry == nil {
+		return false, err
+	}
+	_, exists := entry.members[fmt.Sprint(member)]
+	return exists, nil
+}
+
+// Len number of entries, expired entries not yet evicted included
+func (m *MemoryCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+func (m *MemoryCache) expire(key string, expiration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if entry := m.getUnlocked(key); entry != nil {
+		entry.expiresAt = m.expiresAt(expiration)
+	}
+}
+
+func (m *MemoryCache) expiresAt(expiration ...time.Duration) time.Time {
+	if len(expiration) == 0 || expiration[0] <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(expiration[0])
+}
+
+// getUnlocked live entry of key, marked as recently used; expired entries are removed
+func (m *MemoryCache) getUnlocked(key string) *memoryEntry {
+	element, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if !entry.expiresAt.IsZero() && !m.now().Before(entry.expiresAt) {
+		m.deleteUnlocked(key)
+		return nil
+	}
+
+	m.lru.MoveToFront(element)
+	return entry
+}
+
+func (m *MemoryCache) valueUnlocked(key string) (*memoryEntry, error) {
+	entry := m.getUnlocked(key)
+	if entry != nil && entry.members != nil {
+		return nil, errors.New("WRONGTYPE memory cache: %s holds a set", key)
+	}
+	return entry, nil
+}
+
+func (m *MemoryCache) setEntryUnlocked(key string) (*memoryEntry, error) {
+	entry := m.getUnlocked(key)
+	if entry != nil && entry.members == nil {
+		return nil, errors.New("WRONGTYPE memory cache: %s doesn't hold a set", key)
+	}
+	return entry, nil
+}
+
+func (m *MemoryCache) setUnlocked(entry *memoryEntry) {
+	if element, ok := m.entries[entry.key]; ok {
+		element.Value = entry
+		m.lru.MoveToFront(element)
+		return
+	}
+
+	m.entries[entry.key] = m.lru.PushFront(entry)
+	for m.lru.Len() > m.maxEntries {
+		oldest := m.lru.Back()
+		m.deleteUnlocked(oldest.Value.(*memoryEntry).key)
+	}
+}
+
+func (m *MemoryCache) deleteUnlocked(key string) {
+	if element, ok := m.entries[key]; ok {
+		m.lru.Remove(element)
+		delete(m.entries, key)
+	}
+}
+
+// globToRegexp redis glob pattern: * any, ? one char, [...] classes and \ escapes
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString("(?s:.*)")
+		case '?':
+			b.WriteString("(?s:.)")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				b.WriteString(regexp.QuoteMeta(pattern[i:]))
+				i = len(pattern)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + strings.ReplaceAll(class[1:], `\`, `\\`)
+			} else {
+				class = strings.ReplaceAll(class, `\`, `\\`)
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
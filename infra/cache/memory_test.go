@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMemoryCache(maxEntries int) (*MemoryCache, *time.Time) {
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	c := NewMemoryCache(context.Background(), MemoryCacheConfiguration{MaxEntries: maxEntries})
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestMemoryCache_GetSetExpire(t *testing.T) {
+	ctx := context.Background()
+	c, now := newTestMemoryCache(0)
+
+	_, err := c.Get(ctx, "missing")
+	assert.True(t, IsEmptyError(err))
+
+	require.NoError(t, c.SetEX(ctx, "key", []byte("value"), time.Minute))
+	require.NoError(t, c.SetEX(ctx, "persistent", []byte("value")))
+
+	value, err := c.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	ttl, _ := c.TTL(ctx, "key")
+	assert.Equal(t, time.Minute, ttl)
+	ttl, _ = c.TTL(ctx, "persistent")
+	assert.Equal(t, time.Duration(-1), ttl)
+	ttl, _ = c.TTL(ctx, "missing")
+	assert.Equal(t, time.Duration(-2), ttl)
+
+	*now = now.Add(time.Minute)
+	_, err = c.Get(ctx, "key")
+	assert.True(t, IsEmptyError(err))
+	assert.Error(t, c.Peek(ctx, "key"))
+	assert.NoError(t, c.Peek(ctx, "persistent"))
+}
+
+func TestMemoryCache_LRUEviction(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestMemoryCache(2)
+
+	require.NoError(t, c.SetEX(ctx, "a", []byte("1")))
+	require.NoError(t, c.SetEX(ctx, "b", []byte("2")))
+	_, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, c.SetEX(ctx, "c", []byte("3")))
+
+	assert.Equal(t, 2, c.Len())
+	assert.NoError(t, c.Peek(ctx, "a"))
+	assert.Error(t, c.Peek(ctx, "b"))
+	assert.NoError(t, c.Peek(ctx, "c"))
+}
+
+func TestMemoryCache_Counters(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestMemoryCache(0)
+
+	count, err := c.Increment(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	count, err = c.Decrement(ctx, "counter", 5)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-4), count)
+
+	require.NoError(t, c.SetEX(ctx, "text", []byte("text")))
+	_, err = c.Increment(ctx, "text")
+	assert.Error(t, err)
+}
+
+func TestMemoryCache_SetsAndBatches(t *testing.T) {
+	ctx := context.Background()
+	c, now := newTestMemoryCache(0)
+	expiration := time.Minute
+
+	require.NoError(t, c.BatchOperations(ctx, []BatchOperation{
+		{Type: SETEX, Key: "user:1", Value: []byte("one")},
+		{Type: SADD, Key: "users", Members: []interface{}{"1", 2}, Duration: &expiration},
+	}))
+
+	members, err := c.SetMembers(ctx, "users")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, members)
+
+	isMember, err := c.SetIsMember(ctx, "users", 2)
+	require.NoError(t, err)
+	assert.True(t, isMember)
+
+	removed, err := c.SetRem(ctx, "users", "1", "3")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+
+	card, err := c.SetCard(ctx, "users")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), card)
+
+	_, err = c.Get(ctx, "users")
+	assert.Error(t, err)
+
+	*now = now.Add(time.Minute)
+	card, err = c.SetCard(ctx, "users")
+	require.NoError(t, err)
+	assert.Zero(t, card)
+
+	require.NoError(t, c.BatchDelete(ctx, []string{"user:1"}))
+	assert.Error(t, c.Peek(ctx, "user:1"))
+}
+
+func TestMemoryCache_Scan(t *testing.T) {
+	ctx := context.Background()
+	c, _ := newTestMemoryCache(0)
+	for _, key := range []string{"user:1", "user:2", "user:3", "order:1", "user:10"} {
+		require.NoError(t, c.SetEX(ctx, key, []byte("v")))
+	}
+
+	keys, cursor, err := c.Scan(ctx, "user:?", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:1", "user:2"}, keys)
+
+	keys, cursor, err = c.Scan(ctx, "user:?", 2, cursor)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user:3"}, keys)
+	assert.Zero(t, cursor)
+
+	all, err := GetCollection(ctx, c, "user:*", 2, true)
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+
+	keys, _, err = c.Scan(ctx, "[ou]*:1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"order:1", "user:1"}, keys)
+}
@@ -5,10 +5,10 @@ import (
 	goErrors "errors"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/pixie-sh/di-go"
 	"github.com/pixie-sh/errors-go"
 	"github.com/pixie-sh/logger-go/logger"
+	"github.com/redis/go-redis/v9"
 
 	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
 	"github.com/pixie-sh/core-go/pkg/types"
@@ -49,23 +49,110 @@ type SetCache interface {
 	SetIsMember(ctx context.Context, key string, member interface{}) (bool, error)
 }
 
+// ScriptCache caches running Lua scripts atomically on their keys
+type ScriptCache interface {
+	Cache
+
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// PubSubCache caches broadcasting messages to their subscribers; Subscribe returns once subscribed,
+// handler runs for each message until unsubscribe is called
+type PubSubCache interface {
+	SetCache
+
+	Publish(ctx context.Context, channel string, message []byte) error
+	Subscribe(ctx context.Context, channel string, handler func(ctx context.Context, message []byte)) (unsubscribe func() error, err error)
+}
+
+// HashCache caches field value hashes
+type HashCache interface {
+	Cache
+
+	HashSet(ctx context.Context, key string, values map[string]interface{}) (int64, error)
+	HashGet(ctx context.Context, key string, field string) ([]byte, error)
+	HashGetAll(ctx context.Context, key string) (map[string]string, error)
+	HashIncrementBy(ctx context.Context, key string, field string, by int64) (int64, error)
+	HashDelete(ctx context.Context, key string, fields ...string) (int64, error)
+}
+
+type SortedSetMember struct {
+	Member string
+	Score  float64
+}
+
+// SortedSetCache caches sets of members ordered by score; min and max scores accept -inf, +inf and ( exclusive bounds
+type SortedSetCache interface {
+	Cache
+
+	SortedSetAdd(ctx context.Context, key string, members ...SortedSetMember) (int64, error)
+	SortedSetIncrementBy(ctx context.Context, key string, member string, by float64) (float64, error)
+	SortedSetScore(ctx context.Context, key string, member string) (float64, error)
+	SortedSetRem(ctx context.Context, key string, members ...string) (int64, error)
+	SortedSetRangeByScore(ctx context.Context, key string, min string, max string, offset int64, count int64) ([]SortedSetMember, error)
+	SortedSetRevRange(ctx context.Context, key string, start int64, stop int64) ([]SortedSetMember, error)
+}
+
+type StreamMessage struct {
+	ID     string
+	Values map[string]interface{}
+}
+
+// StreamCache caches append only logs read by consumer groups
+type StreamCache interface {
+	Cache
+
+	StreamAdd(ctx context.Context, stream string, values map[string]interface{}, maxLen ...int64) (string, error)
+	StreamCreateGroup(ctx context.Context, stream string, group string, start string) error
+	StreamReadGroup(ctx context.Context, stream string, group string, consumer string, count int64, block time.Duration) ([]StreamMessage, error)
+	StreamAck(ctx context.Context, stream string, group string, ids ...string) (int64, error)
+}
+
+// ScriptingCache caches running registered Lua scripts by their digest
+type ScriptingCache interface {
+	ScriptCache
+
+	RegisterScript(ctx context.Context, script *Script) error
+	RunScript(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error)
+}
+
 type BatchOperationType string
 
 const SETEX = BatchOperationType("SETEX")
 const SADD = BatchOperationType("SADD")
+const HSET = BatchOperationType("HSET")
+const HINCRBY = BatchOperationType("HINCRBY")
+const ZADD = BatchOperationType("ZADD")
+const ZINCRBY = BatchOperationType("ZINCRBY")
+const XADD = BatchOperationType("XADD")
 
 type BatchOperation struct {
-	Type     BatchOperationType
-	Key      string
-	Value    []byte         // For SETEX operations
-	Duration *time.Duration // Optional For SETEX expiration
-	Members  []interface{}  // For SADD operations
+	Type          BatchOperationType
+	Key           string
+	Value         []byte                 // For SETEX operations
+	Duration      *time.Duration         // Optional For SETEX expiration, the key expiration for the other types
+	Members       []interface{}          // For SADD operations
+	Fields        map[string]interface{} // For HSET and XADD operations
+	Field         string                 // For HINCRBY operations
+	IncrementBy   int64                  // For HINCRBY operations
+	ScoredMembers []SortedSetMember      // For ZADD operations, and ZINCRBY with scores as increments
+	MaxLen        int64                  // Optional For XADD, the stream is trimmed to about it
 }
 
+// RedisCacheConfiguration standalone, sentinel or cluster deployment; Mode is inferred when not set:
+// sentinel with MasterName, cluster with several Addresses, standalone otherwise
 type RedisCacheConfiguration struct {
-	Address  string `json:"address"`
-	Password string `json:"password"`
-	DB       int    `json:"db"`
+	Mode             RedisMode              `json:"mode"`
+	Address          string                 `json:"address"`
+	Addresses        []string               `json:"addresses"` // cluster seed nodes or sentinels; Address if not set
+	Username         string                 `json:"username"`  // ACL user
+	Password         string                 `json:"password"`
+	DB               int                    `json:"db"`
+	MasterName       string                 `json:"master_name"`
+	SentinelUsername string                 `json:"sentinel_username"`
+	SentinelPassword string                 `json:"sentinel_password"`
+	PoolSize         int                    `json:"pool_size"` // go-redis default if not set
+	TLS              *RedisTLSConfiguration `json:"tls,omitempty"`
 }
 
 func (r RedisCacheConfiguration) LookupNode(lookupPath string) (any, error) {
@@ -73,24 +160,32 @@ func (r RedisCacheConfiguration) LookupNode(lookupPath string) (any, error) {
 }
 
 type RedisCache struct {
-	client *redis.Client
+	client  redis.UniversalClient
+	cluster *redis.ClusterClient // set in cluster mode
 }
 
 func NewRedisCache(ctx context.Context, configuration RedisCacheConfiguration) (*RedisCache, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     configuration.Address,
-		Password: configuration.Password,
-		DB:       configuration.DB,
-	})
+	rdb, err := newRedisClient(configuration)
+	if err != nil {
+		return nil, err
+	}
 
-	_, err := rdb.Ping(ctx).Result()
+	_, err = rdb.Ping(ctx).Result()
 	if err != nil {
+		_ = rdb.Close()
 		return nil, err
 	}
 
-	return &RedisCache{
+	cache := &RedisCache{
 		client: rdb,
-	}, nil
+	}
+	cache.cluster, _ = rdb.(*redis.ClusterClient)
+	return cache, nil
+}
+
+// Client underlying client, e.g. to share the connections with NewRedisLock
+func (r *RedisCache) Client() redis.UniversalClient {
+	return r.client
 }
 
 func (r *RedisCache) SetEX(ctx context.Context, key string, value []byte, duration ...time.Duration) error {
@@ -101,36 +196,50 @@ func (r *RedisCache) Keys(ctx context.Context) ([]string, error) {
 	return GetCollection(ctx, r, "*", 1000, true)
 }
 
-// BatchOperations executes multiple SETEX and SADD operations in a single pipeline
+// BatchOperations executes multiple operations in a single transaction, one per hash slot in cluster mode
 func (r *RedisCache) BatchOperations(ctx context.Context, operations []BatchOperation) error {
 	if len(operations) == 0 {
 		return nil
 	}
 
-	pipeline := r.client.TxPipeline()
+	keys := make([]string, len(operations))
+	for i, op := range operations {
+		keys[i] = op.Key
+	}
 
-	for _, op := range operations {
+	err := r.txPipelined(ctx, keys, func(pipeline redis.Pipeliner, i int) {
+		op := operations[i]
 		switch op.Type {
 		case SETEX:
 			if op.Duration != nil {
-				pipeline.SetEX(ctx, op.Key, op.Value, *op.Duration)
+				pipeline.SetEx(ctx, op.Key, op.Value, *op.Duration)
 			} else {
 				pipeline.Set(ctx, op.Key, op.Value, 0)
 			}
 		case SADD:
 			pipeline.SAdd(ctx, op.Key, op.Members...)
-
-			// If duration is specified, set expiration on the set
-			if op.Duration != nil {
-				pipeline.Expire(ctx, op.Key, *op.Duration)
+		case HSET:
+			pipeline.HSet(ctx, op.Key, op.Fields)
+		case HINCRBY:
+			pipeline.HIncrBy(ctx, op.Key, op.Field, op.IncrementBy)
+		case ZADD:
+			pipeline.ZAdd(ctx, op.Key, toZ(op.ScoredMembers)...)
+		case ZINCRBY:
+			for _, member := range op.ScoredMembers {
+				pipeline.ZIncrBy(ctx, op.Key, member.Score, member.Member)
 			}
+		case XADD:
+			pipeline.XAdd(ctx, streamAddArgs(op.Key, op.Fields, op.MaxLen))
 		default:
 			pixiecontext.GetCtxLogger(ctx).Error("unsupported batch operation type: %s", op.Type)
-			continue
+			return
 		}
-	}
 
-	_, err := pipeline.Exec(ctx)
+		// If duration is specified, set expiration on the key
+		if op.Type != SETEX && op.Duration != nil {
+			pipeline.Expire(ctx, op.Key, *op.Duration)
+		}
+	})
 	if err != nil {
 		pixiecontext.GetCtxLogger(ctx).Error("failed to execute batch operations: %v", err)
 	}
@@ -139,17 +248,18 @@ func (r *RedisCache) BatchOperations(ctx context.Context, operations []BatchOper
 }
 
 func (r *RedisCache) BatchSetEX(ctx context.Context, keyPairs []BatchSetExModel) error {
-	var pipeline = r.client.TxPipeline()
-	for _, i := range keyPairs {
-		if i.Duration != nil {
-			r.setEX(ctx, pipeline, i.Key, i.Value, *i.Duration)
-		} else {
-			r.setEX(ctx, pipeline, i.Key, i.Value)
-		}
+	keys := make([]string, len(keyPairs))
+	for i, pair := range keyPairs {
+		keys[i] = pair.Key
 	}
 
-	_, err := pipeline.Exec(ctx)
-	return err
+	return r.txPipelined(ctx, keys, func(pipeline redis.Pipeliner, i int) {
+		if keyPairs[i].Duration != nil {
+			r.setEX(ctx, pipeline, keyPairs[i].Key, keyPairs[i].Value, *keyPairs[i].Duration)
+		} else {
+			r.setEX(ctx, pipeline, keyPairs[i].Key, keyPairs[i].Value)
+		}
+	})
 }
 
 func (r *RedisCache) setEX(ctx context.Context, txClient redis.Cmdable, key string, value []byte, duration ...time.Duration) *redis.StatusCmd {
@@ -214,6 +324,7 @@ func (r *RedisCache) TTL(ctx context.Context, key string) (time.Duration, error)
 	return r.client.TTL(ctx, key).Result()
 }
 
+// Scan in cluster mode scans every master, see clusterScan
 func (r *RedisCache) Scan(ctx context.Context, pattern string, rows int64, fromCursor ...uint64) ([]string, uint64, error) {
 	var (
 		cursor uint64
@@ -225,7 +336,11 @@ func (r *RedisCache) Scan(ctx context.Context, pattern string, rows int64, fromC
 		cursor = fromCursor[0]
 	}
 
-	keys, cursor, err = r.client.Scan(ctx, cursor, pattern, rows).Result()
+	if r.cluster != nil {
+		keys, cursor, err = r.clusterScan(ctx, cursor, pattern, rows)
+	} else {
+		keys, cursor, err = r.client.Scan(ctx, cursor, pattern, rows).Result()
+	}
 	if err != nil {
 		logger.Logger.Error("failed to scan keys for pattern %s", pattern)
 		return nil, 0, err
@@ -234,18 +349,47 @@ func (r *RedisCache) Scan(ctx context.Context, pattern string, rows int64, fromC
 	return keys, cursor, nil
 }
 
+// Eval runs the Lua script; redis.Nil replies are returned as nil results
+func (r *RedisCache) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	result, err := r.client.Eval(ctx, script, keys, args...).Result()
+	if err != nil && !IsEmptyError(err) {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (r *RedisCache) Publish(ctx context.Context, channel string, message []byte) error {
+	return r.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe messages are lost while the connection is reestablished
+func (r *RedisCache) Subscribe(ctx context.Context, channel string, handler func(ctx context.Context, message []byte)) (func() error, error) {
+	subscription := r.client.Subscribe(ctx, channel)
+	_, err := subscription.Receive(ctx)
+	if err != nil {
+		_ = subscription.Close()
+		return nil, err
+	}
+
+	messages := subscription.Channel()
+	go func() {
+		for message := range messages {
+			handler(ctx, types.UnsafeBytes(message.Payload))
+		}
+	}()
+
+	return subscription.Close, nil
+}
+
 func (r *RedisCache) Delete(ctx context.Context, key string) error {
 	return r.client.Del(ctx, key).Err()
 }
 
 func (r *RedisCache) BatchDelete(ctx context.Context, key []string) error {
-	pipeline := r.client.TxPipeline()
-	for _, i2 := range key {
-		pipeline.Del(ctx, i2)
-	}
-
-	_, err := pipeline.Exec(ctx)
-	return err
+	return r.txPipelined(ctx, key, func(pipeline redis.Pipeliner, i int) {
+		pipeline.Del(ctx, key[i])
+	})
 }
 
 // SAdd adds one or more members to a set
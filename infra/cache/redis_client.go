@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+
+	"github.com/pixie-sh/errors-go"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/pixie-sh/core-go/pkg/base64"
+)
+
+type RedisMode string
+
+const (
+	RedisStandalone = RedisMode("standalone")
+	RedisSentinel   = RedisMode("sentinel")
+	RedisCluster    = RedisMode("cluster")
+)
+
+type RedisTLSConfiguration struct {
+	Enabled            bool   `json:"enabled"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	ServerName         string `json:"server_name,omitempty"`
+	CertBase64         string `json:"cert_base64,omitempty"`
+	CertFile           string `json:"cert_file,omitempty"`
+	KeyBase64          string `json:"key_base64,omitempty"`
+	KeyFile            string `json:"key_file,omitempty"`
+	CABase64           string `json:"ca_base64,omitempty"`
+	CAFile             string `json:"ca_file,omitempty"`
+}
+
+// newRedisClient client of the configured deployment; *redis.Client for standalone and sentinel,
+// *redis.ClusterClient for cluster
+func newRedisClient(configuration RedisCacheConfiguration) (redis.UniversalClient, error) {
+	addresses := configuration.Addresses
+	if len(addresses) == 0 && len(configuration.Address) > 0 {
+		addresses = []string{configuration.Address}
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("redis address is empty")
+	}
+
+	tlsConfig, err := configuration.TLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	options := &redis.UniversalOptions{
+		Addrs:            addresses,
+		Username:         configuration.Username,
+		Password:         configuration.Password,
+		DB:               configuration.DB,
+		MasterName:       configuration.MasterName,
+		SentinelUsername: configuration.SentinelUsername,
+		SentinelPassword: configuration.SentinelPassword,
+		PoolSize:         configuration.PoolSize,
+		TLSConfig:        tlsConfig,
+	}
+
+	switch configuration.mode() {
+	case RedisStandalone:
+		return redis.NewClient(options.Simple()), nil
+	case RedisSentinel:
+		if len(configuration.MasterName) == 0 {
+			return nil, errors.New("redis sentinel master name is empty")
+		}
+		return redis.NewFailoverClient(options.Failover()), nil
+	case RedisCluster:
+		if configuration.DB != 0 {
+			return nil, errors.New("redis cluster only supports db 0")
+		}
+		return redis.NewClusterClient(options.Cluster()), nil
+	default:
+		return nil, errors.New("unknown redis mode %s", configuration.Mode)
+	}
+}
+
+func (c RedisCacheConfiguration) mode() RedisMode {
+	switch {
+	case len(c.Mode) > 0:
+		return c.Mode
+	case len(c.MasterName) > 0:
+		return RedisSentinel
+	case len(c.Addresses) > 1:
+		return RedisCluster
+	default:
+		return RedisStandalone
+	}
+}
+
+// tlsConfig base64 certificates take precedence over files; nil if not enabled
+func (c *RedisTLSConfiguration) tlsConfig() (*tls.Config, error) {
+	if c == nil || !c.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	certPEM, err := pemOf(c.CertBase64, c.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := pemOf(c.KeyBase64, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(certPEM) > 0 || len(keyPEM) > 0 {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, errors.NewWithError(err, "invalid redis client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	caPEM, err := pemOf(c.CABase64, c.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(caPEM) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("invalid redis CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+func pemOf(encoded string, file string) ([]byte, error) {
+	switch {
+	case len(encoded) > 0:
+		decoded, err := base64.Decode(encoded)
+		if err != nil {
+			return nil, errors.NewWithError(err, "failed to decode base64 certificate")
+		}
+		return []byte(decoded), nil
+	case len(file) > 0:
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.NewWithError(err, "failed to read certificate %s", file)
+		}
+		return pem, nil
+	default:
+		return nil, nil
+	}
+}
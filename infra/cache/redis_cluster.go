@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pixie-sh/errors-go"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	redisClusterSlots = 16384
+
+	// cluster scan cursors hold the master index in the upper bits and the master scan cursor in the lower bits
+	clusterScanNodeShift  = 48
+	clusterScanCursorMask = uint64(1)<<clusterScanNodeShift - 1
+)
+
+// keySlot cluster hash slot of key; only the {hash tag} is hashed when present
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	// crc16 xmodem
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return int(crc) % redisClusterSlots
+}
+
+// txPipelined queues the commands of each key with queue and runs them in transactions.
+// In cluster mode there's one transaction per hash slot, keys sharing a hash tag are updated atomically
+func (r *RedisCache) txPipelined(ctx context.Context, keys []string, queue func(pipeline redis.Pipeliner, index int)) error {
+	if r.cluster == nil {
+		pipeline := r.client.TxPipeline()
+		for i := range keys {
+			queue(pipeline, i)
+		}
+
+		_, err := pipeline.Exec(ctx)
+		return err
+	}
+
+	slots := make(map[int][]int)
+	for i, key := range keys {
+		slot := keySlot(key)
+		slots[slot] = append(slots[slot], i)
+	}
+
+	var group errgroup.Group
+	for _, indexes := range slots {
+		group.Go(func() error {
+			pipeline := r.cluster.TxPipeline()
+			for _, i := range indexes {
+				queue(pipeline, i)
+			}
+
+			_, err := pipeline.Exec(ctx)
+			return err
+		})
+	}
+	return group.Wait()
+}
+
+// clusterScan scans the masters one after the other; keys moved across masters during the scan may be missed
+// or returned twice
+func (r *RedisCache) clusterScan(ctx context.Context, cursor uint64, pattern string, rows int64) ([]string, uint64, error) {
+	masters, err := r.clusterMasters(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	node := int(cursor >> clusterScanNodeShift)
+	if node >= len(masters) {
+		return []string{}, 0, nil
+	}
+
+	keys, next, err := masters[node].Scan(ctx, cursor&clusterScanCursorMask, pattern, rows).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	if next > clusterScanCursorMask {
+		return nil, 0, errors.New("redis scan cursor %d out of range", next)
+	}
+
+	if next == 0 {
+		node++
+		if node >= len(masters) {
+			return keys, 0, nil
+		}
+	}
+
+	return keys, uint64(node)<<clusterScanNodeShift | next, nil
+}
+
+// clusterMasters master clients sorted by address, so cursors stay valid across calls
+func (r *RedisCache) clusterMasters(ctx context.Context) ([]*redis.Client, error) {
+	var mu sync.Mutex
+	var masters []*redis.Client
+	err := r.cluster.ForEachMaster(ctx, func(_ context.Context, master *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		masters = append(masters, master)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(masters, func(i, j int) bool {
+		return masters[i].Options().Addr < masters[j].Options().Addr
+	})
+	return masters, nil
+}
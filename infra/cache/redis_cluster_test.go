@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySlot(t *testing.T) {
+	// values from CLUSTER KEYSLOT
+	assert.Equal(t, 12739, keySlot("123456789"))
+	assert.Equal(t, 12182, keySlot("foo"))
+	assert.Equal(t, 11058, keySlot("somekey"))
+	assert.Equal(t, 2515, keySlot("foo{hash_tag}"))
+
+	assert.Equal(t, keySlot("{user1000}.following"), keySlot("{user1000}.followers"))
+	assert.Equal(t, keySlot("user1000"), keySlot("{user1000}.following"))
+	assert.Equal(t, keySlot("bar"), keySlot("foo{bar}{zap}"))
+	assert.Equal(t, keySlot("{bar"), keySlot("foo{{bar}}zap"))
+	// empty hash tags hash the whole key
+	assert.NotEqual(t, keySlot("bar"), keySlot("foo{}{bar}"))
+}
+
+func TestRedisCacheConfiguration_Mode(t *testing.T) {
+	assert.Equal(t, RedisStandalone, RedisCacheConfiguration{Address: "localhost:6379"}.mode())
+	assert.Equal(t, RedisSentinel, RedisCacheConfiguration{Addresses: []string{"a:26379", "b:26379"}, MasterName: "main"}.mode())
+	assert.Equal(t, RedisCluster, RedisCacheConfiguration{Addresses: []string{"a:6379", "b:6379"}}.mode())
+	assert.Equal(t, RedisCluster, RedisCacheConfiguration{Mode: RedisCluster, Address: "a:6379"}.mode())
+
+	_, err := newRedisClient(RedisCacheConfiguration{})
+	assert.Error(t, err)
+	_, err = newRedisClient(RedisCacheConfiguration{Mode: RedisSentinel, Address: "a:26379"})
+	assert.Error(t, err)
+	_, err = newRedisClient(RedisCacheConfiguration{Mode: RedisCluster, Address: "a:6379", DB: 1})
+	assert.Error(t, err)
+	_, err = newRedisClient(RedisCacheConfiguration{Address: "a:6379", TLS: &RedisTLSConfiguration{Enabled: true, CAFile: "missing.pem"}})
+	assert.Error(t, err)
+
+	client, err := newRedisClient(RedisCacheConfiguration{Address: "a:6379", Username: "user", TLS: &RedisTLSConfiguration{Enabled: true, ServerName: "redis"}})
+	require.NoError(t, err)
+	defer client.Close()
+	options := client.(*redis.Client).Options()
+	assert.Equal(t, "user", options.Username)
+	assert.Equal(t, "redis", options.TLSConfig.ServerName)
+}
+
+func TestRedisCache_Cluster(t *testing.T) {
+	ctx := context.Background()
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	redisCache, err := NewRedisCache(ctx, RedisCacheConfiguration{Mode: RedisCluster, Address: mr.Addr()})
+	require.NoError(t, err)
+	require.NotNil(t, redisCache.cluster)
+
+	expiration := time.Minute
+	var operations []BatchOperation
+	for i := 0; i < 20; i++ {
+		operations = append(operations, BatchOperation{Type: SETEX, Key: fmt.Sprintf("{user%d}.name", i), Value: []byte("name"), Duration: &expiration})
+		operations = append(operations, BatchOperation{Type: SADD, Key: fmt.Sprintf("{user%d}.roles", i), Members: []interface{}{"admin"}})
+	}
+	require.NoError(t, redisCache.BatchOperations(ctx, operations))
+
+	keys, err := GetCollection(ctx, redisCache, "{user*}.name", 7, true)
+	require.NoError(t, err)
+	assert.Len(t, keys, 20)
+
+	keys, cursor, err := redisCache.Scan(ctx, "*", 1000)
+	require.NoError(t, err)
+	assert.Len(t, keys, 40)
+	assert.Zero(t, cursor)
+
+	deleted := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		deleted = append(deleted, fmt.Sprintf("{user%d}.name", i))
+	}
+	require.NoError(t, redisCache.BatchDelete(ctx, deleted))
+	assert.Len(t, mr.Keys(), 20)
+}
@@ -3,14 +3,35 @@ package cache
 import (
 	"context"
 	goErrors "errors"
+	"sync"
 	"time"
 
 	"github.com/go-redsync/redsync/v4"
 	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
 	"github.com/pixie-sh/errors-go"
 	"github.com/redis/go-redis/v9"
+
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	"github.com/pixie-sh/core-go/pkg/uid"
+)
+
+const (
+	fencingTokenKeySuffix = ":fencing_token"
+
+	// lockDriftFactor share of the lock expiration accounted for clock drift, as redsync
+	lockDriftFactor = 0.01
 )
 
+// fencedLockScript KEYS lock and its fencing token, ARGV lock value and expiration in ms; takes the lock and
+// issues the next token atomically, replies 0 when the lock is held
+const fencedLockScript = `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+    return redis.call('INCR', KEYS[2])
+end
+return 0
+`
+
 // SharedLocker defines the interface for a distributed lock
 type SharedLocker interface {
 	Lock(ctx context.Context, key string, lockDuration ...time.Duration) (SharedLock, error)
@@ -29,20 +50,37 @@ type RedisLockConfiguration struct {
 	MaxRetries        int           `json:"max_retries"`
 }
 
+// LockOptions options of RedisLocker TryLock and WithLock
+type LockOptions struct {
+	Duration time.Duration // lock expiration; DefaultExpiration if not set
+	Owner    string        // locks of a key taken by the same owner through the locker are reentrant, released by the last Unlock
+	Fencing  bool          // issue a fencing token with the lock, see RedisLock.Token
+}
+
 // RedisLocker implements the SharedLocker interface using redsync.Redsync
 type RedisLocker struct {
 	rs     *redsync.Redsync
+	client redis.UniversalClient
 	config RedisLockConfiguration
+
+	mu   sync.Mutex
+	held map[string]*RedisLock // reentrant locks by key
 }
 
 // RedisLock implements the SharedLock interface using redsync.Mutex
 type RedisLock struct {
-	mutex *redsync.Mutex
-	ctx   context.Context
+	mutex  *redsync.Mutex
+	ctx    context.Context
+	locker *RedisLocker
+	key    string
+	owner  string
+	holds  int
+	token  int64
+	until  time.Time
 }
 
 // NewRedisLock creates a new RedisLocker instance
-func NewRedisLock(_ context.Context, client *redis.Client, config RedisLockConfiguration) (*RedisLocker, error) {
+func NewRedisLock(_ context.Context, client redis.UniversalClient, config RedisLockConfiguration) (*RedisLocker, error) {
 	if client == nil {
 		return nil, errors.New("redis client is nil")
 	}
@@ -52,37 +90,248 @@ func NewRedisLock(_ context.Context, client *redis.Client, config RedisLockConfi
 
 	return &RedisLocker{
 		rs:     rs,
+		client: client,
 		config: config,
+		held:   make(map[string]*RedisLock),
 	}, nil
 }
 
 // Lock implements the SharedLocker.Lock method
 func (rl *RedisLocker) Lock(_ context.Context, key string, lockDuration ...time.Duration) (SharedLock, error) {
-	duration := rl.config.DefaultExpiration
+	var options LockOptions
 	if len(lockDuration) > 0 {
-		duration = lockDuration[0]
+		options.Duration = lockDuration[0]
+	}
+
+	lock, _, err := rl.acquire(context.Background(), key, options, rl.config.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+// TryLock waits up to wait for the lock, a single attempt if not set; false when not acquired in time
+func (rl *RedisLocker) TryLock(ctx context.Context, key string, wait time.Duration, withOptions ...LockOptions) (*RedisLock, bool, error) {
+	var options LockOptions
+	if len(withOptions) > 0 {
+		options = withOptions[0]
+	}
+
+	tries := 1
+	if wait > 0 {
+		// attempts are bound by the wait deadline
+		tries = int(wait/max(rl.config.DefaultRetryDelay, time.Millisecond)) + 1
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wait)
+		defer cancel()
+	}
+
+	lock, _, err := rl.acquire(ctx, key, options, tries)
+	if err != nil {
+		if _, failed := errors.Has(err, errors.FailedToAcquireLockErrorCode); failed {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	return lock, true, nil
+}
+
+// WithLock runs fn holding the lock of key, extended while fn runs; fn gets the lock fencing token, 0 without Fencing.
+// When the lock can't be extended before expiring fn's context is canceled and a LockLostErrorCode
+// error is returned. Reentrant calls run fn right away, the outer call keeps extending the lock
+func (rl *RedisLocker) WithLock(ctx context.Context, key string, fn func(ctx context.Context, token int64) error, withOptions ...LockOptions) error {
+	var options LockOptions
+	if len(withOptions) > 0 {
+		options = withOptions[0]
+	}
+
+	lock, reentered, err := rl.acquire(ctx, key, options, rl.config.MaxRetries)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := lock.Unlock(); unlockErr != nil {
+			pixiecontext.GetCtxLogger(ctx).With("error", unlockErr).With("key", key).Warn("error unlocking")
+		}
+	}()
+
+	if reentered {
+		return fn(ctx, lock.token)
+	}
+
+	interval := rl.config.DefaultExpiration
+	if until := time.Until(lock.Until()); until > 0 {
+		interval = until
 	}
 
-	mutex := rl.rs.NewMutex(key,
+	return KeepAlive(ctx, key, interval/3, func(context.Context) error {
+		return lock.Extend()
+	}, func(ctx context.Context) error {
+		return fn(ctx, lock.token)
+	})
+}
+
+// KeepAlive runs fn while extending the lease of key, e.g. a lock, every interval. When extend fails with
+// LockLostErrorCode fn's context is canceled and that error returned; other extend errors are retried
+func KeepAlive(ctx context.Context, key string, interval time.Duration, extend func(ctx context.Context) error, fn func(ctx context.Context) error) error {
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	stop := make(chan struct{})
+	var extending sync.WaitGroup
+	extending.Add(1)
+	go func() {
+		defer extending.Done()
+
+		ticker := time.NewTicker(max(interval, time.Millisecond))
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			err := extend(context.WithoutCancel(leaseCtx))
+			if err == nil {
+				continue
+			}
+
+			if _, lost := errors.Has(err, pixieErrors.LockLostErrorCode); lost {
+				cancel(err)
+				return
+			}
+
+			pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", key).Warn("error extending lease, retrying")
+		}
+	}()
+
+	err := fn(leaseCtx)
+	close(stop)
+	extending.Wait()
+
+	if cause := context.Cause(leaseCtx); cause != nil {
+		if _, lost := errors.Has(cause, pixieErrors.LockLostErrorCode); lost {
+			return cause
+		}
+	}
+	return err
+}
+
+// acquire lock of key, with a fencing token if options.Fencing, or the held lock of the same owner, reentered
+func (rl *RedisLocker) acquire(ctx context.Context, key string, options LockOptions, tries int) (*RedisLock, bool, error) {
+	if len(options.Owner) > 0 {
+		rl.mu.Lock()
+		held, ok := rl.held[key]
+		if ok && held.owner == options.Owner {
+			held.holds++
+			rl.mu.Unlock()
+			return held, true, nil
+		}
+		rl.mu.Unlock()
+	}
+
+	duration := rl.config.DefaultExpiration
+	if options.Duration > 0 {
+		duration = options.Duration
+	}
+
+	mutexOptions := []redsync.Option{
 		redsync.WithExpiry(duration),
-		redsync.WithTries(rl.config.MaxRetries),
+		redsync.WithTries(tries),
 		redsync.WithRetryDelay(rl.config.DefaultRetryDelay),
+	}
+
+	var (
+		token int64
+		until time.Time
+		err   error
 	)
+	if options.Fencing {
+		value := uid.NewUUID()
+		token, until, err = rl.acquireFenced(ctx, key, value, duration, tries)
+		mutexOptions = append(mutexOptions, redsync.WithValue(value))
+	}
 
-	lockCtx := context.Background()
-	err := mutex.LockContext(lockCtx)
+	mutex := rl.rs.NewMutex(key, mutexOptions...)
+	if !options.Fencing {
+		err = mutex.LockContext(ctx)
+		until = mutex.Until()
+	}
 	if err != nil {
+		if _, failed := errors.Has(err, errors.FailedToAcquireLockErrorCode); failed {
+			return nil, false, err
+		}
 		if goErrors.Is(err, redsync.ErrFailed) {
-			return nil, errors.New("lock failed to acquire").WithErrorCode(errors.FailedToAcquireLockErrorCode)
+			return nil, false, errors.New("lock failed to acquire").WithErrorCode(errors.FailedToAcquireLockErrorCode)
 		}
 
-		return nil, errors.Wrap(err, "%s", err.Error()).WithErrorCode(errors.FailedToAcquireLockErrorCode)
+		return nil, false, errors.Wrap(err, "%s", err.Error()).WithErrorCode(errors.FailedToAcquireLockErrorCode)
 	}
 
-	return &RedisLock{
-		mutex: mutex,
-		ctx:   lockCtx,
-	}, nil
+	lock := &RedisLock{
+		mutex:  mutex,
+		ctx:    context.Background(),
+		locker: rl,
+		key:    key,
+		owner:  options.Owner,
+		holds:  1,
+		token:  token,
+		until:  until,
+	}
+
+	if len(options.Owner) > 0 {
+		rl.mu.Lock()
+		rl.held[key] = lock
+		rl.mu.Unlock()
+	}
+
+	return lock, false, nil
+}
+
+// acquireFenced takes the lock with value issuing its fencing token in a single script, so no other holder
+// can take the lock between both; up to tries attempts. The token key outlives the lock so tokens keep
+// increasing across holders. In cluster mode key must have a {hash tag}, both keys are in its slot
+func (rl *RedisLocker) acquireFenced(ctx context.Context, key string, value string, duration time.Duration, tries int) (int64, time.Time, error) {
+	for try := 0; try < max(tries, 1); try++ {
+		if try > 0 {
+			select {
+			case <-ctx.Done():
+				return 0, time.Time{}, errors.NewWithError(ctx.Err(), "lock failed to acquire").WithErrorCode(errors.FailedToAcquireLockErrorCode)
+			case <-time.After(rl.config.DefaultRetryDelay):
+			}
+		}
+
+		start := time.Now()
+		token, err := rl.client.Eval(ctx, fencedLockScript, []string{key, key + fencingTokenKeySuffix}, value, duration.Milliseconds()).Int64()
+		if err != nil {
+			return 0, time.Time{}, err
+		}
+		if token > 0 {
+			return token, start.Add(duration - time.Duration(float64(duration)*lockDriftFactor)), nil
+		}
+	}
+
+	return 0, time.Time{}, errors.New("lock failed to acquire").WithErrorCode(errors.FailedToAcquireLockErrorCode)
+}
+
+// Token fencing token issued when the lock was acquired with Fencing, greater than the tokens of previous
+// holders, 0 otherwise; downstream writes reject tokens lower than the last seen to fence off holders that
+// lost the lock
+func (rl *RedisLock) Token() int64 {
+	return rl.token
+}
+
+// Until time the lock is valid until unless extended, clock drift accounted for
+func (rl *RedisLock) Until() time.Time {
+	if rl.mutex != nil && rl.mutex.Until().After(rl.until) {
+		return rl.mutex.Until()
+	}
+	return rl.until
 }
 
 // Unlock implements the SharedLocker.Unlock method
@@ -91,6 +340,19 @@ func (rl *RedisLock) Unlock() error {
 		return errors.New("lock not held by this instance")
 	}
 
+	if len(rl.owner) > 0 {
+		rl.locker.mu.Lock()
+		rl.holds--
+		if rl.holds > 0 {
+			rl.locker.mu.Unlock()
+			return nil
+		}
+		if rl.locker.held[rl.key] == rl {
+			delete(rl.locker.held, rl.key)
+		}
+		rl.locker.mu.Unlock()
+	}
+
 	ok, err := rl.mutex.UnlockContext(rl.ctx)
 	if err != nil {
 		return err
@@ -116,3 +378,25 @@ func (rl *RedisLock) Refresh() (bool, error) {
 
 	return ok, nil
 }
+
+// Extend extends the lock expiration; LockLostErrorCode when the lock was taken or expired,
+// other errors are transient and the lock is held until it expires
+func (rl *RedisLock) Extend() error {
+	if rl.mutex == nil {
+		return errors.New("lock not held by this instance")
+	}
+
+	ok, err := rl.mutex.ExtendContext(rl.ctx)
+	if ok {
+		return nil
+	}
+
+	var taken *redsync.ErrNodeTaken
+	if goErrors.As(err, &taken) || !time.Now().Before(rl.Until()) {
+		return errors.New("lock %s lost", rl.key).WithErrorCode(pixieErrors.LockLostErrorCode)
+	}
+	if err == nil {
+		err = redsync.ErrExtendFailed
+	}
+	return err
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
 )
 
 func setupTestRedisLocker(t *testing.T) (*RedisLocker, *miniredis.Miniredis) {
@@ -239,3 +241,133 @@ func TestRedisLocker_Concurrency(t *testing.T) {
 	wg.Wait()
 	assert.Equal(t, routines, len(lockedCount))
 }
+
+func TestRedisLock_Token(t *testing.T) {
+	locker, mr := setupTestRedisLocker(t)
+	defer mr.Close()
+	ctx := context.Background()
+
+	var tokens []int64
+	for i := 0; i < 3; i++ {
+		lock, ok, err := locker.TryLock(ctx, "token_key", 0, LockOptions{Fencing: true})
+		require.NoError(t, err)
+		require.True(t, ok)
+		tokens = append(tokens, lock.Token())
+		assert.True(t, lock.Until().After(time.Now()))
+
+		_, ok, err = locker.TryLock(ctx, "token_key", 0, LockOptions{Fencing: true})
+		require.NoError(t, err)
+		assert.False(t, ok)
+		require.NoError(t, lock.Unlock())
+	}
+
+	assert.Equal(t, []int64{1, 2, 3}, tokens)
+
+	// without fencing no token is issued nor kept
+	lock, err := locker.Lock(ctx, "plain_key")
+	require.NoError(t, err)
+	assert.Zero(t, lock.(*RedisLock).Token())
+	require.NoError(t, lock.Unlock())
+	assert.False(t, mr.Exists("plain_key"+fencingTokenKeySuffix))
+}
+
+func TestRedisLocker_TryLock(t *testing.T) {
+	locker, mr := setupTestRedisLocker(t)
+	defer mr.Close()
+	ctx := context.Background()
+
+	lock, ok, err := locker.TryLock(ctx, "try_key", 0)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	start := time.Now()
+	_, ok, err = locker.TryLock(ctx, "try_key", 250*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		_ = lock.Unlock()
+	}()
+
+	other, ok, err := locker.TryLock(ctx, "try_key", time.Second, LockOptions{Fencing: true})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Greater(t, other.Token(), lock.Token())
+	require.NoError(t, other.Unlock())
+}
+
+func TestRedisLocker_Reentrant(t *testing.T) {
+	locker, mr := setupTestRedisLocker(t)
+	defer mr.Close()
+	ctx := context.Background()
+
+	outer, ok, err := locker.TryLock(ctx, "reentrant_key", 0, LockOptions{Owner: "job-1"})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	inner, ok, err := locker.TryLock(ctx, "reentrant_key", 0, LockOptions{Owner: "job-1"})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, outer.Token(), inner.Token())
+
+	_, ok, err = locker.TryLock(ctx, "reentrant_key", 0, LockOptions{Owner: "job-2"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// held until the last unlock
+	require.NoError(t, inner.Unlock())
+	assert.True(t, mr.Exists("reentrant_key"))
+	require.NoError(t, outer.Unlock())
+	assert.False(t, mr.Exists("reentrant_key"))
+
+	err = locker.WithLock(ctx, "reentrant_key", func(ctx context.Context, token int64) error {
+		return locker.WithLock(ctx, "reentrant_key", func(ctx context.Context, innerToken int64) error {
+			assert.Equal(t, token, innerToken)
+			return nil
+		}, LockOptions{Owner: "job-2"})
+	}, LockOptions{Owner: "job-2"})
+	require.NoError(t, err)
+	assert.False(t, mr.Exists("reentrant_key"))
+}
+
+func TestRedisLocker_WithLockExtends(t *testing.T) {
+	locker, mr := setupTestRedisLocker(t)
+	defer mr.Close()
+	ctx := context.Background()
+
+	err := locker.WithLock(ctx, "extended_key", func(ctx context.Context, token int64) error {
+		assert.Equal(t, int64(1), token)
+
+		// close to expiring until extended
+		mr.FastForward(250 * time.Millisecond)
+		time.Sleep(200 * time.Millisecond)
+
+		assert.Greater(t, mr.TTL("extended_key"), 200*time.Millisecond)
+		return ctx.Err()
+	}, LockOptions{Duration: 300 * time.Millisecond, Fencing: true})
+	require.NoError(t, err)
+	assert.False(t, mr.Exists("extended_key"))
+}
+
+func TestRedisLocker_WithLockLost(t *testing.T) {
+	locker, mr := setupTestRedisLocker(t)
+	defer mr.Close()
+	ctx := context.Background()
+
+	err := locker.WithLock(ctx, "lost_key", func(ctx context.Context, token int64) error {
+		// expired and taken by another holder
+		require.NoError(t, mr.Set("lost_key", "other"))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	}, LockOptions{Duration: 300 * time.Millisecond})
+
+	_, lost := errors.Has(err, pixieErrors.LockLostErrorCode)
+	assert.True(t, lost)
+}
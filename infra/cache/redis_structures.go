@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/pixie-sh/core-go/pkg/types"
+)
+
+// Script Lua script identified by the SHA1 digest of its source
+type Script struct {
+	source string
+	hash   string
+}
+
+func NewScript(source string) *Script {
+	digest := sha1.Sum([]byte(source))
+	return &Script{
+		source: source,
+		hash:   hex.EncodeToString(digest[:]),
+	}
+}
+
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// HashGet value of field; IsEmptyError when missing
+func (r *RedisCache) HashGet(ctx context.Context, key string, field string) ([]byte, error) {
+	val, err := r.client.HGet(ctx, key, field).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return types.UnsafeBytes(val), nil
+}
+
+// HashSet sets the fields of values, returns the number of added fields
+func (r *RedisCache) HashSet(ctx context.Context, key string, values map[string]interface{}) (int64, error) {
+	return r.client.HSet(ctx, key, values).Result()
+}
+
+func (r *RedisCache) HashGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return r.client.HGetAll(ctx, key).Result()
+}
+
+func (r *RedisCache) HashIncrementBy(ctx context.Context, key string, field string, by int64) (int64, error) {
+	return r.client.HIncrBy(ctx, key, field, by).Result()
+}
+
+func (r *RedisCache) HashDelete(ctx context.Context, key string, fields ...string) (int64, error) {
+	return r.client.HDel(ctx, key, fields...).Result()
+}
+
+// SortedSetAdd adds or updates the scores of members, returns the number of added members
+func (r *RedisCache) SortedSetAdd(ctx context.Context, key string, members ...SortedSetMember) (int64, error) {
+	return r.client.ZAdd(ctx, key, toZ(members)...).Result()
+}
+
+func (r *RedisCache) SortedSetIncrementBy(ctx context.Context, key string, member string, by float64) (float64, error) {
+	return r.client.ZIncrBy(ctx, key, by, member).Result()
+}
+
+// SortedSetScore score of member; IsEmptyError when missing
+func (r *RedisCache) SortedSetScore(ctx context.Context, key string, member string) (float64, error) {
+	return r.client.ZScore(ctx, key, member).Result()
+}
+
+func (r *RedisCache) SortedSetRem(ctx context.Context, key string, members ...string) (int64, error) {
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+
+	return r.client.ZRem(ctx, key, values...).Result()
+}
+
+// SortedSetRangeByScore members with scores between min and max, lowest first; count <= 0 for all
+func (r *RedisCache) SortedSetRangeByScore(ctx context.Context, key string, min string, max string, offset int64, count int64) ([]SortedSetMember, error) {
+	by := &redis.ZRangeBy{Min: min, Max: max}
+	if count > 0 {
+		by.Offset = offset
+		by.Count = count
+	}
+
+	result, err := r.client.ZRangeByScoreWithScores(ctx, key, by).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return fromZ(result), nil
+}
+
+// SortedSetRevRange members ranked between start and stop, highest score first, e.g. 0 and 9 for the top 10
+func (r *RedisCache) SortedSetRevRange(ctx context.Context, key string, start int64, stop int64) ([]SortedSetMember, error) {
+	result, err := r.client.ZRevRangeWithScores(ctx, key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return fromZ(result), nil
+}
+
+// StreamAdd appends values to stream, trimmed to about maxLen if provided; returns the message id
+func (r *RedisCache) StreamAdd(ctx context.Context, stream string, values map[string]interface{}, maxLen ...int64) (string, error) {
+	var trim int64
+	if len(maxLen) > 0 {
+		trim = maxLen[0]
+	}
+
+	return r.client.XAdd(ctx, streamAddArgs(stream, values, trim)).Result()
+}
+
+// StreamCreateGroup creates group reading stream from start, "$" for new messages or "0" for all; the stream
+// is created if missing and existing groups are left untouched
+func (r *RedisCache) StreamCreateGroup(ctx context.Context, stream string, group string, start string) error {
+	err := r.client.XGroupCreateMkStream(ctx, stream, group, start).Err()
+	if err != nil && !redis.HasErrorPrefix(err, "BUSYGROUP") {
+		return err
+	}
+
+	return nil
+}
+
+// StreamReadGroup reads up to count messages never delivered to group, waiting up to block for them;
+// block <= 0 doesn't wait. Messages are pending until acknowledged with StreamAck
+func (r *RedisCache) StreamReadGroup(ctx context.Context, stream string, group string, consumer string, count int64, block time.Duration) ([]StreamMessage, error) {
+	if block <= 0 {
+		block = -1
+	}
+
+	result, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err != nil {
+		if IsEmptyError(err) {
+			return []StreamMessage{}, nil
+		}
+		return nil, err
+	}
+
+	var messages []StreamMessage
+	for _, s := range result {
+		for _, message := range s.Messages {
+			messages = append(messages, StreamMessage{ID: message.ID, Values: message.Values})
+		}
+	}
+	return messages, nil
+}
+
+func (r *RedisCache) StreamAck(ctx context.Context, stream string, group string, ids ...string) (int64, error) {
+	return r.client.XAck(ctx, stream, group, ids...).Result()
+}
+
+// RegisterScript loads script so it runs by digest; in cluster mode it's loaded on every master
+func (r *RedisCache) RegisterScript(ctx context.Context, script *Script) error {
+	return r.client.ScriptLoad(ctx, script.source).Err()
+}
+
+// RunScript runs script by digest, loading it when the server doesn't know it, e.g. after a restart or failover;
+// redis.Nil replies are returned as nil results
+func (r *RedisCache) RunScript(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error) {
+	result, err := r.client.EvalSha(ctx, script.hash, keys, args...).Result()
+	if redis.HasErrorPrefix(err, "NOSCRIPT") {
+		result, err = r.client.Eval(ctx, script.source, keys, args...).Result()
+	}
+	if err != nil && !IsEmptyError(err) {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func streamAddArgs(stream string, values map[string]interface{}, maxLen int64) *redis.XAddArgs {
+	return &redis.XAddArgs{
+		Stream: stream,
+		Values: values,
+		MaxLen: maxLen,
+		Approx: maxLen > 0,
+	}
+}
+
+func toZ(members []SortedSetMember) []redis.Z {
+	z := make([]redis.Z, len(members))
+	for i, member := range members {
+		z[i] = redis.Z{Score: member.Score, Member: member.Member}
+	}
+	return z
+}
+
+func fromZ(z []redis.Z) []SortedSetMember {
+	members := make([]SortedSetMember, len(z))
+	for i, item := range z {
+		members[i] = SortedSetMember{Member: fmt.Sprint(item.Member), Score: item.Score}
+	}
+	return members
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisCache(t *testing.T) (*RedisCache, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	redisCache, err := NewRedisCache(context.Background(), RedisCacheConfiguration{Address: mr.Addr()})
+	require.NoError(t, err)
+	return redisCache, mr
+}
+
+func TestRedisCache_Hash(t *testing.T) {
+	ctx := context.Background()
+	redisCache, _ := newTestRedisCache(t)
+
+	added, err := redisCache.HashSet(ctx, "counters", map[string]interface{}{"views": 1, "likes": 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), added)
+
+	views, err := redisCache.HashIncrementBy(ctx, "counters", "views", 4)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), views)
+
+	likes, err := redisCache.HashGet(ctx, "counters", "likes")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), likes)
+
+	_, err = redisCache.HashGet(ctx, "counters", "missing")
+	assert.True(t, IsEmptyError(err))
+
+	deleted, err := redisCache.HashDelete(ctx, "counters", "likes", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	all, err := redisCache.HashGetAll(ctx, "counters")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"views": "5"}, all)
+}
+
+func TestRedisCache_SortedSet(t *testing.T) {
+	ctx := context.Background()
+	redisCache, _ := newTestRedisCache(t)
+
+	added, err := redisCache.SortedSetAdd(ctx, "leaderboard",
+		SortedSetMember{Member: "ana", Score: 10},
+		SortedSetMember{Member: "bob", Score: 20},
+		SortedSetMember{Member: "eve", Score: 30},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), added)
+
+	score, err := redisCache.SortedSetIncrementBy(ctx, "leaderboard", "ana", 25)
+	require.NoError(t, err)
+	assert.Equal(t, float64(35), score)
+
+	top, err := redisCache.SortedSetRevRange(ctx, "leaderboard", 0, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []SortedSetMember{{Member: "ana", Score: 35}, {Member: "eve", Score: 30}}, top)
+
+	between, err := redisCache.SortedSetRangeByScore(ctx, "leaderboard", "(20", "+inf", 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []SortedSetMember{{Member: "eve", Score: 30}, {Member: "ana", Score: 35}}, between)
+
+	page, err := redisCache.SortedSetRangeByScore(ctx, "leaderboard", "-inf", "+inf", 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []SortedSetMember{{Member: "eve", Score: 30}}, page)
+
+	removed, err := redisCache.SortedSetRem(ctx, "leaderboard", "bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), removed)
+
+	_, err = redisCache.SortedSetScore(ctx, "leaderboard", "bob")
+	assert.True(t, IsEmptyError(err))
+}
+
+func TestRedisCache_Stream(t *testing.T) {
+	ctx := context.Background()
+	redisCache, _ := newTestRedisCache(t)
+
+	require.NoError(t, redisCache.StreamCreateGroup(ctx, "jobs", "workers", "$"))
+	require.NoError(t, redisCache.StreamCreateGroup(ctx, "jobs", "workers", "$"))
+
+	id, err := redisCache.StreamAdd(ctx, "jobs", map[string]interface{}{"job": "resize"})
+	require.NoError(t, err)
+	_, err = redisCache.StreamAdd(ctx, "jobs", map[string]interface{}{"job": "notify"}, 100)
+	require.NoError(t, err)
+
+	messages, err := redisCache.StreamReadGroup(ctx, "jobs", "workers", "worker-1", 1, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, id, messages[0].ID)
+	assert.Equal(t, map[string]interface{}{"job": "resize"}, messages[0].Values)
+
+	acked, err := redisCache.StreamAck(ctx, "jobs", "workers", messages[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), acked)
+
+	messages, err = redisCache.StreamReadGroup(ctx, "jobs", "workers", "worker-2", 10, 0)
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "notify", messages[0].Values["job"])
+
+	messages, err = redisCache.StreamReadGroup(ctx, "jobs", "workers", "worker-2", 10, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestRedisCache_Scripts(t *testing.T) {
+	ctx := context.Background()
+	redisCache, mr := newTestRedisCache(t)
+
+	script := NewScript(`return redis.call("INCRBY", KEYS[1], ARGV[1])`)
+	assert.Len(t, script.Hash(), 40)
+
+	// unknown scripts are loaded on the first run
+	result, err := redisCache.RunScript(ctx, script, []string{"counter"}, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result)
+
+	mr.FlushAll()
+	require.NoError(t, redisCache.RegisterScript(ctx, script))
+	result, err = redisCache.RunScript(ctx, script, []string{"counter"}, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), result)
+
+	result, err = redisCache.RunScript(ctx, NewScript(`return redis.call("GET", KEYS[1])`), []string{"missing"})
+	require.NoError(t, err)
+	assert.Nil(t, result)
+}
+
+func TestRedisCache_BatchOperationsStructures(t *testing.T) {
+	ctx := context.Background()
+	redisCache, mr := newTestRedisCache(t)
+
+	expiration := time.Minute
+	require.NoError(t, redisCache.BatchOperations(ctx, []BatchOperation{
+		{Type: HSET, Key: "user:1", Fields: map[string]interface{}{"name": "ana"}, Duration: &expiration},
+		{Type: HINCRBY, Key: "user:1", Field: "logins", IncrementBy: 2},
+		{Type: ZADD, Key: "leaderboard", ScoredMembers: []SortedSetMember{{Member: "ana", Score: 1}}},
+		{Type: ZINCRBY, Key: "leaderboard", ScoredMembers: []SortedSetMember{{Member: "ana", Score: 2}, {Member: "bob", Score: 1}}},
+		{Type: XADD, Key: "events", Fields: map[string]interface{}{"type": "login"}, MaxLen: 10},
+	}))
+
+	all, err := redisCache.HashGetAll(ctx, "user:1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"name": "ana", "logins": "2"}, all)
+	assert.Equal(t, expiration, mr.TTL("user:1"))
+
+	top, err := redisCache.SortedSetRevRange(ctx, "leaderboard", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []SortedSetMember{{Member: "ana", Score: 3}, {Member: "bob", Score: 1}}, top)
+
+	entries, err := mr.Stream("events")
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
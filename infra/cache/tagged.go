@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+const (
+	defaultTagPrefix       = "tag:"
+	defaultNamespacePrefix = "namespace:"
+)
+
+// taggedSetScript KEYS key and its tag sets, ARGV value and ttl in ms, 0 without expiration.
+// Tag sets are sorted sets of their keys scored by expiration in ms, +inf without; expired keys are pruned
+// on every set and tag sets expire with their last key
+var taggedSetScript = NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local ttl = tonumber(ARGV[2])
+local expiration = '+inf'
+if ttl > 0 then
+    redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+    expiration = now + ttl
+else
+    redis.call('SET', KEYS[1], ARGV[1])
+end
+
+for i = 2, #KEYS do
+    redis.call('ZREMRANGEBYSCORE', KEYS[i], '-inf', now)
+    redis.call('ZADD', KEYS[i], expiration, KEYS[1])
+    local last = redis.call('ZRANGE', KEYS[i], -1, -1, 'WITHSCORES')
+    if last[2] == 'inf' then
+        redis.call('PERSIST', KEYS[i])
+    else
+        redis.call('PEXPIREAT', KEYS[i], tonumber(last[2]))
+    end
+end
+return 1
+`)
+
+// invalidateTagsScript KEYS tag sets; deletes their keys and themselves, replies the keys deleted
+var invalidateTagsScript = NewScript(`
+local deleted = 0
+for i = 1, #KEYS do
+    local members = redis.call('ZRANGE', KEYS[i], 0, -1)
+    for j = 1, #members, 1000 do
+        deleted = deleted + redis.call('DEL', unpack(members, j, math.min(j + 999, #members)))
+    end
+    redis.call('DEL', KEYS[i])
+end
+return deleted
+`)
+
+type TaggedCacheConfiguration struct {
+	TagPrefix       string `json:"tag_prefix"`       // tag set keys prefix; tag: if not set
+	NamespacePrefix string `json:"namespace_prefix"` // namespace version keys prefix; namespace: if not set
+}
+
+// TaggedCache invalidates groups of keys without scanning the keyspace, either by the tags they were set
+// with, tracked in one Redis sorted set per tag, or by bumping the version of their namespace.
+// In cluster mode a key and its tags must share a {hash tag}, scripts run on a single slot
+type TaggedCache struct {
+	config TaggedCacheConfiguration
+	cache  ScriptingCache
+}
+
+func NewTaggedCache(_ context.Context, config TaggedCacheConfiguration, cache ScriptingCache) *TaggedCache {
+	if len(config.TagPrefix) == 0 {
+		config.TagPrefix = defaultTagPrefix
+	}
+	if len(config.NamespacePrefix) == 0 {
+		config.NamespacePrefix = defaultNamespacePrefix
+	}
+
+	return &TaggedCache{
+		config: config,
+		cache:  cache,
+	}
+}
+
+func (c *TaggedCache) Get(ctx context.Context, key string) ([]byte, error) {
+	return c.cache.Get(ctx, key)
+}
+
+// SetEX caches value for expiration, without expiration if 0, adding key to the sets of tags atomically
+func (c *TaggedCache) SetEX(ctx context.Context, key string, value []byte, expiration time.Duration, tags ...string) error {
+	keys := make([]string, 0, len(tags)+1)
+	keys = append(keys, key)
+	for _, tag := range tags {
+		keys = append(keys, c.tagKey(tag))
+	}
+
+	_, err := c.cache.RunScript(ctx, taggedSetScript, keys, value, expiration.Milliseconds())
+	if err != nil {
+		return errors.NewWithError(err, "error caching tagged key %s", key)
+	}
+	return nil
+}
+
+// InvalidateTags deletes every key set with any of tags, and the tags, atomically; returns the keys deleted
+func (c *TaggedCache) InvalidateTags(ctx context.Context, tags ...string) (int64, error) {
+	if len(tags) == 0 {
+		return 0, nil
+	}
+
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = c.tagKey(tag)
+	}
+
+	reply, err := c.cache.RunScript(ctx, invalidateTagsScript, keys)
+	if err != nil {
+		return 0, errors.NewWithError(err, "error invalidating tags %v", tags)
+	}
+
+	deleted, ok := reply.(int64)
+	if !ok {
+		return 0, errors.New("unexpected invalidate tags reply %v", reply)
+	}
+	return deleted, nil
+}
+
+// NamespacedKey key within the current version of namespace. Keys of previous versions are no longer
+// read after InvalidateNamespace and are left to expire, so namespaced keys should be set with expiration
+func (c *TaggedCache) NamespacedKey(ctx context.Context, namespace string, key string) (string, error) {
+	version, err := c.namespaceVersion(ctx, namespace)
+	if err != nil {
+		return "", err
+	}
+	return namespace + ":v" + strconv.FormatInt(version, 10) + ":" + key, nil
+}
+
+// InvalidateNamespace invalidates every key of namespace in O(1) by bumping its version
+func (c *TaggedCache) InvalidateNamespace(ctx context.Context, namespace string) error {
+	_, err := c.cache.Increment(ctx, c.config.NamespacePrefix+namespace)
+	if err != nil {
+		return errors.NewWithError(err, "error invalidating namespace %s", namespace)
+	}
+	return nil
+}
+
+func (c *TaggedCache) namespaceVersion(ctx context.Context, namespace string) (int64, error) {
+	value, err := c.cache.Get(ctx, c.config.NamespacePrefix+namespace)
+	if IsEmptyError(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.NewWithError(err, "error reading version of namespace %s", namespace)
+	}
+
+	version, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, errors.NewWithError(err, "invalid version of namespace %s", namespace).WithErrorCode(errors.InvalidFormDataCode)
+	}
+	return version, nil
+}
+
+func (c *TaggedCache) tagKey(tag string) string {
+	return c.config.TagPrefix + tag
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaggedCache_InvalidateTags(t *testing.T) {
+	ctx := context.Background()
+	redisCache, mr := newTestRedisCache(t)
+	tagged := NewTaggedCache(ctx, TaggedCacheConfiguration{}, redisCache)
+	mr.SetTime(time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC))
+
+	require.NoError(t, tagged.SetEX(ctx, "user:1", []byte("ana"), time.Minute, "user:1"))
+	require.NoError(t, tagged.SetEX(ctx, "users:list", []byte("[1,2]"), 10*time.Minute, "user:1", "user:2"))
+	require.NoError(t, tagged.SetEX(ctx, "user:2", []byte("bob"), 0, "user:2"))
+	require.NoError(t, redisCache.SetEX(ctx, "untagged", []byte("kept")))
+
+	members, err := mr.ZMembers("tag:user:1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"user:1", "users:list"}, members)
+
+	// tag sets outlive their keys
+	assert.Equal(t, time.Minute, mr.TTL("user:1"))
+	assert.Equal(t, 10*time.Minute, mr.TTL("tag:user:1"))
+	assert.Zero(t, mr.TTL("tag:user:2"))
+
+	value, err := tagged.Get(ctx, "users:list")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("[1,2]"), value)
+
+	deleted, err := tagged.InvalidateTags(ctx, "user:1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	assert.False(t, mr.Exists("user:1"))
+	assert.False(t, mr.Exists("users:list"))
+	assert.False(t, mr.Exists("tag:user:1"))
+	assert.True(t, mr.Exists("user:2"))
+	assert.True(t, mr.Exists("untagged"))
+
+	// keys already deleted aren't counted
+	deleted, err = tagged.InvalidateTags(ctx, "user:2", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	assert.False(t, mr.Exists("user:2"))
+
+	deleted, err = tagged.InvalidateTags(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, deleted)
+}
+
+func TestTaggedCache_PrunesExpiredKeys(t *testing.T) {
+	ctx := context.Background()
+	redisCache, mr := newTestRedisCache(t)
+	tagged := NewTaggedCache(ctx, TaggedCacheConfiguration{}, redisCache)
+
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	require.NoError(t, tagged.SetEX(ctx, "product:1", []byte("shoes"), 10*time.Minute, "catalog"))
+	require.NoError(t, tagged.SetEX(ctx, "product:2", []byte("socks"), time.Minute, "catalog"))
+	assert.Equal(t, 10*time.Minute, mr.TTL("tag:catalog"))
+
+	mr.SetTime(now.Add(11 * time.Minute))
+	mr.FastForward(11 * time.Minute)
+	require.NoError(t, tagged.SetEX(ctx, "product:3", []byte("hats"), time.Minute, "catalog"))
+
+	// expired keys are dropped and the tag set expires with its last key
+	members, err := mr.ZMembers("tag:catalog")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"product:3"}, members)
+	assert.Equal(t, time.Minute, mr.TTL("tag:catalog"))
+
+	// re-set without expiration
+	require.NoError(t, tagged.SetEX(ctx, "product:3", []byte("hats"), 0, "catalog"))
+	assert.Zero(t, mr.TTL("tag:catalog"))
+}
+
+func TestTaggedCache_Namespaces(t *testing.T) {
+	ctx := context.Background()
+	redisCache, _ := newTestRedisCache(t)
+	tagged := NewTaggedCache(ctx, TaggedCacheConfiguration{NamespacePrefix: "ns:"}, redisCache)
+
+	key, err := tagged.NamespacedKey(ctx, "catalog", "product:1")
+	require.NoError(t, err)
+	assert.Equal(t, "catalog:v0:product:1", key)
+	require.NoError(t, redisCache.SetEX(ctx, key, []byte("shoes"), time.Minute))
+
+	require.NoError(t, tagged.InvalidateNamespace(ctx, "catalog"))
+
+	key, err = tagged.NamespacedKey(ctx, "catalog", "product:1")
+	require.NoError(t, err)
+	assert.Equal(t, "catalog:v1:product:1", key)
+
+	_, err = tagged.Get(ctx, key)
+	assert.True(t, IsEmptyError(err))
+
+	other, err := tagged.NamespacedKey(ctx, "orders", "order:1")
+	require.NoError(t, err)
+	assert.Equal(t, "orders:v0:order:1", other)
+}
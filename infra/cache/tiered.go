@@ -0,0 +1,295 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	"github.com/pixie-sh/core-go/pkg/models/serializer"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+	"github.com/pixie-sh/core-go/pkg/uid"
+)
+
+const (
+	defaultTieredCacheL1TTL               = time.Minute
+	defaultTieredCacheInvalidationChannel = "cache:invalidations"
+
+	// tieredGenerations invalidation generation slots keys are hashed to
+	tieredGenerations = 1024
+)
+
+// tieredKeyEvents keyspace notifications of the L2 keys changing or going away
+var tieredKeyEvents = []string{"set", "del", "expired", "evicted", "incrby", "decrby"}
+
+type TieredCacheConfiguration struct {
+	L1                  MemoryCacheConfiguration `json:"l1"`
+	L1TTL               coretime.Duration        `json:"l1_ttl"`               // max time values stay in L1; 1m if not set
+	InvalidationChannel string                   `json:"invalidation_channel"` // cache:invalidations if not set
+	KeyEvents           bool                     `json:"key_events"`           // also invalidate on the L2 keyspace notifications, see TieredCache
+	DB                  int                      `json:"db"`                   // L2 database of the keyspace notifications
+}
+
+type tieredInvalidation struct {
+	Sender string   `json:"sender"`
+	Keys   []string `json:"keys"`
+}
+
+// TieredCache two tier cache, values are read through a local L1 and falling back to the L2 shared cache.
+// Writes go to L2 and the changed keys are dropped from the L1 of every replica through pub/sub invalidation
+// messages; a replica missing messages, e.g. while reconnecting, serves stale values for at most L1TTL,
+// and never past their L2 expiration. Sets aren't kept in L1.
+// Keys written to L2 by other clients are only invalidated with KeyEvents, from the __keyevent@<db>__
+// notifications of L2; the server must have notify-keyspace-events with at least E, g, $, x and e.
+// In cluster mode notifications are published by each node to its own clients, only the node of the
+// subscription is covered
+type TieredCache struct {
+	config       TieredCacheConfiguration
+	id           string
+	l1           *MemoryCache
+	l2           PubSubCache
+	unsubscribes []func() error
+
+	// generations bumped on every invalidation of the keys of their slot, so an L2 read racing
+	// with an invalidation doesn't fill L1 with the value it replaced
+	mu          sync.Mutex
+	generations [tieredGenerations]uint64
+}
+
+// NewTieredCache subscribes to the invalidation channel until Close
+func NewTieredCache(ctx context.Context, config TieredCacheConfiguration, l2 PubSubCache) (*TieredCache, error) {
+	if config.L1TTL <= 0 {
+		config.L1TTL = coretime.Duration(defaultTieredCacheL1TTL)
+	}
+	if len(config.InvalidationChannel) == 0 {
+		config.InvalidationChannel = defaultTieredCacheInvalidationChannel
+	}
+
+	c := &TieredCache{
+		config: config,
+		id:     uid.NewUUID(),
+		l1:     NewMemoryCache(ctx, config.L1),
+		l2:     l2,
+	}
+
+	channels := map[string]func(ctx context.Context, message []byte){config.InvalidationChannel: c.onInvalidation}
+	if config.KeyEvents {
+		for _, event := range tieredKeyEvents {
+			channels[fmt.Sprintf("__keyevent@%d__:%s", config.DB, event)] = c.onKeyEvent
+		}
+	}
+
+	for channel, handler := range channels {
+		unsubscribe, err := l2.Subscribe(ctx, channel, handler)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		c.unsubscribes = append(c.unsubscribes, unsubscribe)
+	}
+
+	return c, nil
+}
+
+// Close stops receiving invalidations
+func (c *TieredCache) Close() error {
+	var err error
+	for _, unsubscribe := range c.unsubscribes {
+		if unsubscribeErr := unsubscribe(); unsubscribeErr != nil && err == nil {
+			err = unsubscribeErr
+		}
+	}
+	return err
+}
+
+// Get from L1, or from L2 keeping it in L1 for L1TTL or until its L2 expiration if sooner
+func (c *TieredCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.l1.Get(ctx, key)
+	if err == nil {
+		return value, nil
+	}
+
+	generation := c.generation(key)
+	value, err = c.l2.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := c.config.L1TTL.Duration()
+	remaining, err := c.l2.TTL(ctx, key)
+	if err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", key).Warn("error reading cache key ttl, not kept in L1")
+		return value, nil
+	}
+	if remaining > 0 && remaining < ttl {
+		ttl = remaining
+	}
+	// -2 the key is already gone
+	if remaining != -2 {
+		c.fill(ctx, key, value, ttl, generation)
+	}
+	return value, nil
+}
+
+func (c *TieredCache) Peek(ctx context.Context, key string) error {
+	if c.l1.Peek(ctx, key) == nil {
+		return nil
+	}
+	return c.l2.Peek(ctx, key)
+}
+
+func (c *TieredCache) Increment(ctx context.Context, key string) (int64, error) {
+	defer c.invalidate(ctx, key)
+	return c.l2.Increment(ctx, key)
+}
+
+func (c *TieredCache) Decrement(ctx context.Context, key string, by ...int64) (int64, error) {
+	defer c.invalidate(ctx, key)
+	return c.l2.Decrement(ctx, key, by...)
+}
+
+func (c *TieredCache) SetEX(ctx context.Context, key string, value []byte, expiration ...time.Duration) error {
+	err := c.l2.SetEX(ctx, key, value, expiration...)
+	c.invalidate(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	ttl := c.config.L1TTL.Duration()
+	if len(expiration) > 0 && expiration[0] > 0 && expiration[0] < ttl {
+		ttl = expiration[0]
+	}
+	c.fill(ctx, key, value, ttl, c.generation(key))
+	return nil
+}
+
+func (c *TieredCache) Delete(ctx context.Context, key string) error {
+	defer c.invalidate(ctx, key)
+	return c.l2.Delete(ctx, key)
+}
+
+func (c *TieredCache) Scan(ctx context.Context, pattern string, rows int64, cursor ...uint64) ([]string, uint64, error) {
+	return c.l2.Scan(ctx, pattern, rows, cursor...)
+}
+
+func (c *TieredCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return c.l2.TTL(ctx, key)
+}
+
+func (c *TieredCache) BatchSetEX(ctx context.Context, keyPairs []BatchSetExModel) error {
+	keys := make([]string, len(keyPairs))
+	for i, pair := range keyPairs {
+		keys[i] = pair.Key
+	}
+
+	defer c.invalidate(ctx, keys...)
+	return c.l2.BatchSetEX(ctx, keyPairs)
+}
+
+func (c *TieredCache) BatchDelete(ctx context.Context, keys []string) error {
+	defer c.invalidate(ctx, keys...)
+	return c.l2.BatchDelete(ctx, keys)
+}
+
+func (c *TieredCache) BatchOperations(ctx context.Context, operations []BatchOperation) error {
+	keys := make([]string, len(operations))
+	for i, op := range operations {
+		keys[i] = op.Key
+	}
+
+	defer c.invalidate(ctx, keys...)
+	return c.l2.BatchOperations(ctx, operations)
+}
+
+func (c *TieredCache) SetAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return c.l2.SetAdd(ctx, key, members...)
+}
+
+func (c *TieredCache) SetMembers(ctx context.Context, key string) ([]string, error) {
+	return c.l2.SetMembers(ctx, key)
+}
+
+func (c *TieredCache) SetRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return c.l2.SetRem(ctx, key, members...)
+}
+
+func (c *TieredCache) SetCard(ctx context.Context, key string) (int64, error) {
+	return c.l2.SetCard(ctx, key)
+}
+
+func (c *TieredCache) SetIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return c.l2.SetIsMember(ctx, key, member)
+}
+
+// invalidate drops keys from the local L1 and broadcasts the invalidation to the other replicas
+func (c *TieredCache) invalidate(ctx context.Context, keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+
+	c.drop(ctx, keys...)
+
+	message, err := serializer.Serialize(tieredInvalidation{Sender: c.id, Keys: keys})
+	if err == nil {
+		err = c.l2.Publish(ctx, c.config.InvalidationChannel, message)
+	}
+	if err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).With("keys", keys).Warn("error publishing cache invalidation")
+	}
+}
+
+func (c *TieredCache) onInvalidation(ctx context.Context, message []byte) {
+	var invalidation tieredInvalidation
+	err := serializer.Deserialize(message, &invalidation, false)
+	if err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).Warn("invalid cache invalidation message")
+		return
+	}
+
+	if invalidation.Sender == c.id {
+		return
+	}
+
+	c.drop(ctx, invalidation.Keys...)
+}
+
+// onKeyEvent keyspace notifications carry the key as message
+func (c *TieredCache) onKeyEvent(ctx context.Context, message []byte) {
+	c.drop(ctx, string(message))
+}
+
+// drop keys from L1, bumping their generation so L2 reads started before don't fill them back
+func (c *TieredCache) drop(ctx context.Context, keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		c.generations[generationSlot(key)]++
+	}
+	_ = c.l1.BatchDelete(ctx, keys)
+}
+
+// fill L1 with value unless key was invalidated since generation was read
+func (c *TieredCache) fill(ctx context.Context, key string, value []byte, ttl time.Duration, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generations[generationSlot(key)] != generation {
+		return
+	}
+	_ = c.l1.SetEX(ctx, key, value, ttl)
+}
+
+func (c *TieredCache) generation(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generations[generationSlot(key)]
+}
+
+func generationSlot(key string) uint32 {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return hash.Sum32() % tieredGenerations
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTieredCaches(t *testing.T) (*TieredCache, *TieredCache, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	newTiered := func() *TieredCache {
+		redisCache, err := NewRedisCache(context.Background(), RedisCacheConfiguration{Address: mr.Addr()})
+		require.NoError(t, err)
+
+		tiered, err := NewTieredCache(context.Background(), TieredCacheConfiguration{}, redisCache)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = tiered.Close() })
+		return tiered
+	}
+
+	return newTiered(), newTiered(), mr
+}
+
+func TestTieredCache_ReadsThroughL1(t *testing.T) {
+	ctx := context.Background()
+	replica, _, mr := newTestTieredCaches(t)
+
+	require.NoError(t, mr.Set("key", "value"))
+	value, err := replica.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	// served from L1 until invalidated
+	require.NoError(t, mr.Set("key", "changed"))
+	value, err = replica.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), value)
+
+	_, err = replica.Get(ctx, "missing")
+	assert.True(t, IsEmptyError(err))
+}
+
+func TestTieredCache_InvalidatesReplicas(t *testing.T) {
+	ctx := context.Background()
+	writer, reader, _ := newTestTieredCaches(t)
+
+	require.NoError(t, writer.SetEX(ctx, "key", []byte("v1"), time.Hour))
+	value, err := reader.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v1"), value)
+
+	require.NoError(t, writer.SetEX(ctx, "key", []byte("v2"), time.Hour))
+	assert.Eventually(t, func() bool {
+		value, err := reader.Get(ctx, "key")
+		return err == nil && string(value) == "v2"
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, writer.Delete(ctx, "key"))
+	assert.Eventually(t, func() bool {
+		_, err := reader.Get(ctx, "key")
+		return IsEmptyError(err)
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = writer.Increment(ctx, "counter")
+	require.NoError(t, err)
+	value, err = reader.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+	_, err = writer.Increment(ctx, "counter")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		value, err := reader.Get(ctx, "counter")
+		return err == nil && string(value) == "2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTieredCache_L1KeepsL2Expiration(t *testing.T) {
+	ctx := context.Background()
+	replica, _, mr := newTestTieredCaches(t)
+
+	require.NoError(t, mr.Set("expiring", "value"))
+	mr.SetTTL("expiring", 2*time.Second)
+	_, err := replica.Get(ctx, "expiring")
+	require.NoError(t, err)
+
+	ttl, err := replica.l1.TTL(ctx, "expiring")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, ttl, 2*time.Second)
+
+	require.NoError(t, mr.Set("persistent", "value"))
+	_, err = replica.Get(ctx, "persistent")
+	require.NoError(t, err)
+
+	ttl, err = replica.l1.TTL(ctx, "persistent")
+	require.NoError(t, err)
+	assert.Greater(t, ttl, 2*time.Second)
+}
+
+func TestTieredCache_KeyEvents(t *testing.T) {
+	ctx := context.Background()
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	redisCache, err := NewRedisCache(ctx, RedisCacheConfiguration{Address: mr.Addr()})
+	require.NoError(t, err)
+	replica, err := NewTieredCache(ctx, TieredCacheConfiguration{KeyEvents: true}, redisCache)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = replica.Close() })
+
+	require.NoError(t, mr.Set("key", "value"))
+	_, err = replica.Get(ctx, "key")
+	require.NoError(t, err)
+
+	// written by another client, notified by the server
+	require.NoError(t, mr.Set("key", "changed"))
+	mr.Publish("__keyevent@0__:set", "key")
+	assert.Eventually(t, func() bool {
+		value, err := replica.Get(ctx, "key")
+		return err == nil && string(value) == "changed"
+	}, time.Second, 10*time.Millisecond)
+
+	mr.Del("key")
+	mr.Publish("__keyevent@0__:expired", "key")
+	assert.Eventually(t, func() bool {
+		_, err := replica.Get(ctx, "key")
+		return IsEmptyError(err)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTieredCache_InvalidatedReadsDontFillL1(t *testing.T) {
+	ctx := context.Background()
+	replica, _, _ := newTestTieredCaches(t)
+
+	// an L2 read racing with an invalidation of its key
+	generation := replica.generation("key")
+	replica.drop(ctx, "key")
+	replica.fill(ctx, "key", []byte("stale"), time.Minute, generation)
+
+	_, err := replica.l1.Get(ctx, "key")
+	assert.True(t, IsEmptyError(err))
+
+	replica.fill(ctx, "key", []byte("fresh"), time.Minute, replica.generation("key"))
+	value, err := replica.l1.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("fresh"), value)
+}
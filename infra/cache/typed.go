@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+	"golang.org/x/sync/singleflight"
+
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+const (
+	typedEntryValue    = byte(1)
+	typedEntryNegative = byte(2)
+	typedEntryHeader   = 9 // kind + fresh until unix ms
+
+	defaultTypedCacheLockDuration = 10 * time.Second
+)
+
+type TypedCacheConfiguration struct {
+	KeyPrefix    string            `json:"key_prefix"`
+	NegativeTTL  coretime.Duration `json:"negative_ttl"`  // not found loads are cached for it; disabled if not set
+	StaleTTL     coretime.Duration `json:"stale_ttl"`     // expired values are served for it while refreshed in background; disabled if not set
+	LockDuration coretime.Duration `json:"lock_duration"` // shared lock held while loading; 10s if not set
+}
+
+// TypedCache typed values cache encoded with codec. GetOrLoad loads each key once per process through
+// singleflight and, with a locker, once across replicas
+type TypedCache[T any] struct {
+	config TypedCacheConfiguration
+	cache  Cache
+	codec  Codec[T]
+	locker SharedLocker
+	group  singleflight.Group
+	now    func() time.Time
+}
+
+// TypedLoader loads the value of a key missing in cache; errors with errors.NotFoundErrorCode are negative cached
+type TypedLoader[T any] func(ctx context.Context) (T, error)
+
+func NewTypedCache[T any](
+	_ context.Context,
+	config TypedCacheConfiguration,
+	cache Cache,
+	codec Codec[T],
+	withLocker ...SharedLocker,
+) *TypedCache[T] {
+	if config.LockDuration <= 0 {
+		config.LockDuration = coretime.Duration(defaultTypedCacheLockDuration)
+	}
+
+	c := &TypedCache[T]{
+		config: config,
+		cache:  cache,
+		codec:  codec,
+		now:    time.Now,
+	}
+	if len(withLocker) > 0 {
+		c.locker = withLocker[0]
+	}
+	return c
+}
+
+// Get cached value of key, stale values included; misses and negative entries are errors.NotFoundErrorCode
+func (c *TypedCache[T]) Get(ctx context.Context, key string) (T, error) {
+	var empty T
+	entry, err := c.get(ctx, key)
+	if err != nil {
+		return empty, err
+	}
+	if entry == nil || entry.negative {
+		return empty, c.notFound(key)
+	}
+	return entry.value, nil
+}
+
+// Set caches value for ttl, without expiration if ttl is not set
+func (c *TypedCache[T]) Set(ctx context.Context, key string, value T, ttl ...time.Duration) error {
+	var expiration time.Duration
+	if len(ttl) > 0 {
+		expiration = ttl[0]
+	}
+
+	data, err := c.codec.Encode(value)
+	if err != nil {
+		return errors.NewWithError(err, "error encoding cache value of %s", key).WithErrorCode(errors.InvalidFormDataCode)
+	}
+	return c.set(ctx, key, typedEntryValue, data, expiration, c.config.StaleTTL.Duration())
+}
+
+func (c *TypedCache[T]) Delete(ctx context.Context, key string) error {
+	return c.cache.Delete(ctx, c.key(key))
+}
+
+// GetOrLoad cached value of key or the value loaded with loader, cached for ttl.
+// Stale values are returned right away and refreshed in background
+func (c *TypedCache[T]) GetOrLoad(ctx context.Context, key string, loader TypedLoader[T], ttl time.Duration) (T, error) {
+	var empty T
+	entry, err := c.get(ctx, key)
+	if err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", key).Warn("error reading cache, loading value")
+	}
+
+	if entry != nil {
+		if entry.negative {
+			return empty, c.notFound(key)
+		}
+		if entry.stale {
+			go func() {
+				_, _ = c.load(context.WithoutCancel(ctx), key, loader, ttl)
+			}()
+		}
+		return entry.value, nil
+	}
+
+	return c.load(ctx, key, loader, ttl)
+}
+
+type typedEntry[T any] struct {
+	value    T
+	negative bool
+	stale    bool
+}
+
+// load through singleflight, shared by the callers of key; each caller waits for it until its own ctx is done
+// while the load itself isn't canceled with the caller that started it
+func (c *TypedCache[T]) load(ctx context.Context, key string, loader TypedLoader[T], ttl time.Duration) (T, error) {
+	var empty T
+	loading := c.group.DoChan(key, func() (interface{}, error) {
+		return c.lockAndLoad(context.WithoutCancel(ctx), key, loader, ttl)
+	})
+
+	select {
+	case <-ctx.Done():
+		return empty, ctx.Err()
+	case result := <-loading:
+		if result.Err != nil {
+			return empty, result.Err
+		}
+		return result.Val.(T), nil
+	}
+}
+
+func (c *TypedCache[T]) lockAndLoad(ctx context.Context, key string, loader TypedLoader[T], ttl time.Duration) (interface{}, error) {
+	if c.locker != nil {
+		lock, err := c.locker.Lock(ctx, c.key(key)+":lock", c.config.LockDuration.Duration())
+		if err != nil {
+			pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", key).Warn("error locking cache load")
+		} else {
+			defer func() { _ = lock.Unlock() }()
+		}
+
+		// loaded by another replica while waiting for the lock, also when giving up on it
+		entry, err := c.get(ctx, key)
+		if err == nil && entry != nil && !entry.stale {
+			if entry.negative {
+				return nil, c.notFound(key)
+			}
+			return entry.value, nil
+		}
+	}
+
+	value, err := loader(ctx)
+	if err != nil {
+		if _, notFound := errors.Has(err, errors.NotFoundErrorCode); notFound && c.config.NegativeTTL > 0 {
+			c.logSetError(ctx, key, c.set(ctx, key, typedEntryNegative, nil, c.config.NegativeTTL.Duration(), 0))
+		}
+		return nil, err
+	}
+
+	c.logSetError(ctx, key, c.Set(ctx, key, value, ttl))
+	return value, nil
+}
+
+// get entry of key, nil on miss; undecodable entries are misses
+func (c *TypedCache[T]) get(ctx context.Context, key string) (*typedEntry[T], error) {
+	data, err := c.cache.Get(ctx, c.key(key))
+	if err != nil {
+		if IsEmptyError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if len(data) < typedEntryHeader {
+		pixiecontext.GetCtxLogger(ctx).With("key", key).Warn("invalid cache entry")
+		return nil, nil
+	}
+
+	freshUntil := int64(binary.BigEndian.Uint64(data[1:typedEntryHeader]))
+	entry := &typedEntry[T]{stale: freshUntil > 0 && c.now().UnixMilli() >= freshUntil}
+	switch data[0] {
+	case typedEntryNegative:
+		entry.negative = true
+	case typedEntryValue:
+		entry.value, err = c.codec.Decode(data[typedEntryHeader:])
+		if err != nil {
+			pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", key).Warn("error decoding cache entry")
+			return nil, nil
+		}
+	default:
+		pixiecontext.GetCtxLogger(ctx).With("key", key).Warn("invalid cache entry")
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+func (c *TypedCache[T]) set(ctx context.Context, key string, kind byte, data []byte, ttl time.Duration, stale time.Duration) error {
+	var freshUntil int64
+	var expiration []time.Duration
+	if ttl > 0 {
+		freshUntil = c.now().Add(ttl).UnixMilli()
+		expiration = append(expiration, ttl+stale)
+	}
+
+	entry := make([]byte, typedEntryHeader, typedEntryHeader+len(data))
+	entry[0] = kind
+	binary.BigEndian.PutUint64(entry[1:typedEntryHeader], uint64(freshUntil))
+	return c.cache.SetEX(ctx, c.key(key), append(entry, data...), expiration...)
+}
+
+func (c *TypedCache[T]) logSetError(ctx context.Context, key string, err error) {
+	if err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", key).Warn("error caching loaded value")
+	}
+}
+
+func (c *TypedCache[T]) notFound(key string) error {
+	return errors.New("%s not found", key).WithErrorCode(errors.NotFoundErrorCode)
+}
+
+func (c *TypedCache[T]) key(key string) string {
+	return c.config.KeyPrefix + key
+}
@@ -0,0 +1,270 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+type typedTestValue struct {
+	ID   string `json:"id"`
+	Tags []string
+}
+
+func TestCodecs(t *testing.T) {
+	value := typedTestValue{ID: "1", Tags: []string{"a", "b"}}
+
+	for name, codec := range map[string]Codec[typedTestValue]{
+		"json":    JSONCodec[typedTestValue]{},
+		"msgpack": MsgpackCodec[typedTestValue]{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := codec.Encode(value)
+			require.NoError(t, err)
+
+			decoded, err := codec.Decode(data)
+			require.NoError(t, err)
+			assert.Equal(t, value, decoded)
+		})
+	}
+
+	data, err := JSONCodec[string]{}.Encode("value")
+	require.NoError(t, err)
+	assert.Equal(t, `"value"`, string(data))
+}
+
+func TestTypedCache_SetGet(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryCache(ctx, MemoryCacheConfiguration{})
+	typed := NewTypedCache[typedTestValue](ctx, TypedCacheConfiguration{KeyPrefix: "users:"}, memory, MsgpackCodec[typedTestValue]{})
+
+	_, err := typed.Get(ctx, "1")
+	assert.True(t, isNotFound(err))
+
+	require.NoError(t, typed.Set(ctx, "1", typedTestValue{ID: "1"}, time.Minute))
+	value, err := typed.Get(ctx, "1")
+	require.NoError(t, err)
+	assert.Equal(t, "1", value.ID)
+	require.NoError(t, memory.Peek(ctx, "users:1"))
+
+	require.NoError(t, typed.Delete(ctx, "1"))
+	_, err = typed.Get(ctx, "1")
+	assert.True(t, isNotFound(err))
+}
+
+func TestTypedCache_GetOrLoadOnce(t *testing.T) {
+	ctx := context.Background()
+	typed := NewTypedCache[string](ctx, TypedCacheConfiguration{}, NewMemoryCache(ctx, MemoryCacheConfiguration{}), JSONCodec[string]{})
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (string, error) {
+		loads.Add(1)
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := typed.GetOrLoad(ctx, "key", loader, time.Minute)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), loads.Load())
+
+	value, err := typed.GetOrLoad(ctx, "key", loader, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+	assert.Equal(t, int32(1), loads.Load())
+}
+
+func TestTypedCache_GetOrLoadWithLock(t *testing.T) {
+	ctx := context.Background()
+	locker, mr := setupTestRedisLocker(t)
+	defer mr.Close()
+
+	redisCache, err := NewRedisCache(ctx, RedisCacheConfiguration{Address: mr.Addr()})
+	require.NoError(t, err)
+
+	// two replicas sharing the cache and the locker
+	replicas := []*TypedCache[string]{
+		NewTypedCache[string](ctx, TypedCacheConfiguration{}, redisCache, JSONCodec[string]{}, locker),
+		NewTypedCache[string](ctx, TypedCacheConfiguration{}, redisCache, JSONCodec[string]{}, locker),
+	}
+
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (string, error) {
+		loads.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for _, replica := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := replica.GetOrLoad(ctx, "key", loader, time.Minute)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), loads.Load())
+	assert.False(t, mr.Exists("key:lock"))
+}
+
+func TestTypedCache_GetOrLoadCallerCanceled(t *testing.T) {
+	ctx := context.Background()
+	typed := NewTypedCache[string](ctx, TypedCacheConfiguration{}, NewMemoryCache(ctx, MemoryCacheConfiguration{}), JSONCodec[string]{})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (string, error) {
+		close(started)
+		<-release
+		return "value", ctx.Err()
+	}
+
+	canceledCtx, cancel := context.WithCancel(ctx)
+	first := make(chan error, 1)
+	go func() {
+		_, err := typed.GetOrLoad(canceledCtx, "key", loader, time.Minute)
+		first <- err
+	}()
+	<-started
+
+	second := make(chan string, 1)
+	go func() {
+		value, err := typed.GetOrLoad(ctx, "key", loader, time.Minute)
+		assert.NoError(t, err)
+		second <- value
+	}()
+
+	// the first caller gives up, the load it started carries on for the others
+	cancel()
+	assert.ErrorIs(t, <-first, context.Canceled)
+	close(release)
+	assert.Equal(t, "value", <-second)
+}
+
+// failingLocker locker of a lock held by another replica past the retries
+type failingLocker struct{}
+
+func (failingLocker) Lock(context.Context, string, ...time.Duration) (SharedLock, error) {
+	return nil, errors.New("lock held")
+}
+
+func TestTypedCache_GetOrLoadLockFailed(t *testing.T) {
+	ctx := context.Background()
+	typed := NewTypedCache[string](ctx, TypedCacheConfiguration{}, NewMemoryCache(ctx, MemoryCacheConfiguration{}), JSONCodec[string]{}, failingLocker{})
+
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (string, error) {
+		loads.Add(1)
+		return "loaded", nil
+	}
+
+	// loaded by the replica holding the lock while this one waited for it
+	require.NoError(t, typed.Set(ctx, "key", "value", time.Minute))
+
+	value, err := typed.lockAndLoad(ctx, "key", loader, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "value", value)
+	assert.Zero(t, loads.Load())
+
+	value, err = typed.lockAndLoad(ctx, "missing", loader, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "loaded", value)
+	assert.Equal(t, int32(1), loads.Load())
+}
+
+func TestTypedCache_NegativeCaching(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryCache(ctx, MemoryCacheConfiguration{})
+	typed := NewTypedCache[string](ctx, TypedCacheConfiguration{NegativeTTL: coretime.Duration(time.Minute)}, memory, JSONCodec[string]{})
+
+	var loads int
+	loader := func(ctx context.Context) (string, error) {
+		loads++
+		return "", errors.New("missing").WithErrorCode(errors.NotFoundErrorCode)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := typed.GetOrLoad(ctx, "key", loader, time.Minute)
+		assert.True(t, isNotFound(err))
+	}
+	assert.Equal(t, 1, loads)
+
+	// other errors aren't cached
+	failing := func(ctx context.Context) (string, error) {
+		loads++
+		return "", errors.New("failed")
+	}
+	_, err := typed.GetOrLoad(ctx, "other", failing, time.Minute)
+	assert.Error(t, err)
+	_, err = typed.GetOrLoad(ctx, "other", failing, time.Minute)
+	assert.Error(t, err)
+	assert.Equal(t, 3, loads)
+}
+
+func TestTypedCache_StaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	var now atomic.Int64
+	now.Store(time.Now().UnixNano())
+	memory := NewMemoryCache(ctx, MemoryCacheConfiguration{})
+	memory.now = func() time.Time { return time.Unix(0, now.Load()) }
+	typed := NewTypedCache[string](ctx, TypedCacheConfiguration{StaleTTL: coretime.Duration(time.Second)}, memory, JSONCodec[string]{})
+	typed.now = memory.now
+
+	var loads atomic.Int32
+	loader := func(ctx context.Context) (string, error) {
+		if loads.Add(1) == 1 {
+			return "v1", nil
+		}
+		return "v2", nil
+	}
+
+	value, err := typed.GetOrLoad(ctx, "key", loader, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", value)
+
+	// stale: served while refreshed in background
+	now.Add(int64(500 * time.Millisecond))
+	value, err = typed.GetOrLoad(ctx, "key", loader, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "v1", value)
+	assert.Eventually(t, func() bool {
+		value, err := typed.Get(ctx, "key")
+		return err == nil && value == "v2"
+	}, time.Second, 10*time.Millisecond)
+
+	// past the stale window: loaded again
+	now.Add(int64(2 * time.Second))
+	value, err = typed.GetOrLoad(ctx, "key", loader, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", value)
+	assert.Equal(t, int32(3), loads.Load())
+}
+
+func isNotFound(err error) bool {
+	_, notFound := errors.Has(err, errors.NotFoundErrorCode)
+	return notFound
+}
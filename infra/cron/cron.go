@@ -30,19 +30,12 @@ func (c *Manager) Run(_ context.Context) error {
 	return nil
 }
 
-// RunAsync runs the scheduler with cancel context; Run in a go routine
-// Stop function must be called whenever the ctx is canceled
+// RunAsync runs the scheduler in a go routine until ctx is canceled
 func (c *Manager) RunAsync(ctx context.Context) {
+	c.cron.Start()
 	go func() {
-		_ = c.Run(ctx)
-	loop:
-		for {
-			select {
-			case <-ctx.Done():
-				<-c.stop()
-				break loop
-			}
-		}
+		<-ctx.Done()
+		<-c.stop()
 	}()
 }
 
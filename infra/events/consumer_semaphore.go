@@ -0,0 +1,27 @@
+package events
+
+import (
+	"context"
+
+	"github.com/pixie-sh/core-go/infra/rate_limiter"
+)
+
+// WithSemaphore wraps a Consumer handler so the events sharing a key are handled by at most the semaphore
+// limit of consumers at once across replicas; key returning empty handles the event without a lease.
+// The handler context is canceled when the lease is lost
+func WithSemaphore(
+	semaphore rate_limiter.ISemaphore,
+	key func(ctx context.Context, event UntypedEventWrapper) string,
+	handler func(context.Context, UntypedEventWrapper) error,
+) func(context.Context, UntypedEventWrapper) error {
+	return func(ctx context.Context, event UntypedEventWrapper) error {
+		eventKey := key(ctx, event)
+		if len(eventKey) == 0 {
+			return handler(ctx, event)
+		}
+
+		return semaphore.Do(ctx, eventKey, func(ctx context.Context) error {
+			return handler(ctx, event)
+		})
+	}
+}
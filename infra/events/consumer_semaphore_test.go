@@ -0,0 +1,69 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	"github.com/pixie-sh/core-go/infra/rate_limiter"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+func TestWithSemaphore(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	redisCache, err := cache.NewRedisCache(context.Background(), cache.RedisCacheConfiguration{Address: mr.Addr()})
+	require.NoError(t, err)
+	semaphore, err := rate_limiter.NewSemaphore(context.Background(), rate_limiter.SemaphoreConfiguration{
+		Limit:      1,
+		RetryDelay: coretime.Duration(time.Millisecond),
+	}, redisCache)
+	require.NoError(t, err)
+
+	var exporting atomic.Int32
+	var overlapped atomic.Bool
+	handler := WithSemaphore(semaphore,
+		func(_ context.Context, event UntypedEventWrapper) string {
+			if event.PayloadType == "export" {
+				return "events:export"
+			}
+			return ""
+		},
+		func(_ context.Context, event UntypedEventWrapper) error {
+			if event.PayloadType != "export" {
+				return nil
+			}
+			if exporting.Add(1) > 1 {
+				overlapped.Store(true)
+			}
+			time.Sleep(10 * time.Millisecond)
+			exporting.Add(-1)
+			return nil
+		},
+	)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		for _, payloadType := range []string{"export", "notify"} {
+			wg.Add(1)
+			go func(payloadType string) {
+				defer wg.Done()
+				assert.NoError(t, handler(context.Background(), NewUntypedEventWrapper("id", "test", time.Now(), payloadType, nil)))
+			}(payloadType)
+		}
+	}
+	wg.Wait()
+
+	assert.False(t, overlapped.Load())
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
@@ -0,0 +1,313 @@
+package leader_election
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+	"github.com/pixie-sh/core-go/pkg/uid"
+)
+
+const defaultElectionTTL = 15 * time.Second
+
+type ElectionConfiguration struct {
+	Key           string            `json:"key"`            // leadership lock key, shared by the candidates
+	TTL           coretime.Duration `json:"ttl"`            // leadership expires when not renewed for it; 15s if not set
+	RenewInterval coretime.Duration `json:"renew_interval"` // leader renewals; TTL/3 if not set
+	RetryInterval coretime.Duration `json:"retry_interval"` // campaigns while not leader; TTL/3 if not set
+}
+
+// Election campaigns for the leadership of key among the replicas sharing the locker.
+// The leader renews its lease every RenewInterval and ends its term once the lease expires without being
+// renewed, by its own clock with a drift margin; followers campaign every RetryInterval. A leader paused
+// past its lease, e.g. by GC, acts on an ended term until it resumes, downstream writes should be fenced
+// with Token
+type Election struct {
+	config ElectionConfiguration
+	id     string
+	locker *cache.RedisLocker
+
+	mu        sync.Mutex
+	running   bool
+	stop      context.CancelFunc
+	done      chan struct{}
+	lock      *cache.RedisLock
+	until     time.Time // lease expiration of the current term
+	termClose context.CancelFunc
+	observers map[int]chan bool
+	nextID    int
+	onElected []func(ctx context.Context)
+}
+
+func NewElection(_ context.Context, config ElectionConfiguration, locker *cache.RedisLocker) (*Election, error) {
+	if len(config.Key) == 0 {
+		return nil, errors.New("election key is empty").WithErrorCode(errors.InvalidFormDataCode)
+	}
+	if locker == nil {
+		return nil, errors.New("election locker is nil").WithErrorCode(errors.InvalidFormDataCode)
+	}
+
+	if config.TTL <= 0 {
+		config.TTL = coretime.Duration(defaultElectionTTL)
+	}
+	if config.RenewInterval <= 0 {
+		config.RenewInterval = config.TTL / 3
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = config.TTL / 3
+	}
+
+	return &Election{
+		config:    config,
+		id:        uid.NewUUID(),
+		locker:    locker,
+		observers: make(map[int]chan bool),
+	}, nil
+}
+
+// Run campaigns and holds the leadership until ctx is canceled or Resign is called, then steps down; blocking call
+func (e *Election) Run(ctx context.Context) error {
+	e.mu.Lock()
+	if e.running {
+		e.mu.Unlock()
+		return errors.New("election %s already running", e.config.Key)
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	e.running = true
+	e.stop = stop
+	e.done = make(chan struct{})
+	done := e.done
+	e.mu.Unlock()
+
+	defer func() {
+		e.stepDown(ctx)
+
+		e.mu.Lock()
+		e.running = false
+		e.mu.Unlock()
+
+		stop()
+		close(done)
+	}()
+
+	for {
+		interval := e.config.RetryInterval.Duration()
+		if e.IsLeader() {
+			e.renew(ctx)
+		} else {
+			e.campaign(ctx)
+		}
+
+		// leaders wake up by the lease expiration at the latest, to end the term when not renewed
+		if remaining, leading := e.leaseRemaining(); leading {
+			interval = max(min(e.config.RenewInterval.Duration(), remaining), time.Millisecond)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RunAsync Run in a go routine
+func (e *Election) RunAsync(ctx context.Context) {
+	go func() {
+		err := e.Run(ctx)
+		if err != nil {
+			pixiecontext.GetCtxLogger(ctx).With("error", err).Error("error running election")
+		}
+	}()
+}
+
+// Resign steps down and stops campaigning, returns once the leadership is released
+func (e *Election) Resign() {
+	e.mu.Lock()
+	stop, done := e.stop, e.done
+	e.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+
+	stop()
+	<-done
+}
+
+// IsLeader while the lease of the current term hasn't expired; ends the term once expired
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leadingUnlocked()
+}
+
+// Token fencing token of the current leadership term, 0 when not leader
+func (e *Election) Token() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.leadingUnlocked() {
+		return 0
+	}
+	return e.lock.Token()
+}
+
+// Leadership observes the leadership: the channel holds the latest state, starting with the current one,
+// and is closed by stop
+func (e *Election) Leadership() (<-chan bool, func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	id := e.nextID
+	e.nextID++
+
+	leadership := make(chan bool, 1)
+	leadership <- e.leadingUnlocked()
+	e.observers[id] = leadership
+
+	return leadership, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		if observer, ok := e.observers[id]; ok {
+			delete(e.observers, id)
+			close(observer)
+		}
+	}
+}
+
+// OnElected runs fn in a go routine every time the replica is elected; ctx is canceled when the term ends
+func (e *Election) OnElected(fn func(ctx context.Context)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onElected = append(e.onElected, fn)
+}
+
+func (e *Election) campaign(ctx context.Context) {
+	lock, ok, err := e.locker.TryLock(ctx, e.config.Key, 0, cache.LockOptions{Duration: e.config.TTL.Duration(), Fencing: true})
+	if err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", e.config.Key).Warn("error campaigning for leadership")
+		return
+	}
+	if !ok {
+		return
+	}
+
+	termCtx, termClose := context.WithCancel(context.WithoutCancel(ctx))
+
+	e.mu.Lock()
+	e.lock = lock
+	e.until = lock.Until()
+	e.termClose = termClose
+	callbacks := append([]func(ctx context.Context){}, e.onElected...)
+	e.notifyUnlocked(true)
+	e.mu.Unlock()
+
+	pixiecontext.GetCtxLogger(ctx).With("key", e.config.Key).With("candidate", e.id).With("token", lock.Token()).Log("elected leader")
+	for _, callback := range callbacks {
+		go callback(termCtx)
+	}
+}
+
+func (e *Election) renew(ctx context.Context) {
+	e.mu.Lock()
+	lock := e.lock
+	e.mu.Unlock()
+
+	// ended since checked, e.g. by a concurrent IsLeader once the lease expired
+	if lock == nil {
+		return
+	}
+
+	err := lock.Extend()
+	if err == nil {
+		e.mu.Lock()
+		if e.lock == lock {
+			e.until = lock.Until()
+		}
+		e.mu.Unlock()
+		return
+	}
+
+	if _, lost := errors.Has(err, pixieErrors.LockLostErrorCode); lost {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", e.config.Key).Warn("leadership lost")
+		e.endTerm()
+		return
+	}
+
+	pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", e.config.Key).Warn("error renewing leadership, retrying")
+}
+
+func (e *Election) stepDown(ctx context.Context) {
+	lock := e.endTerm()
+	if lock == nil {
+		return
+	}
+
+	if err := lock.Unlock(); err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).With("key", e.config.Key).Warn("error releasing leadership")
+	}
+}
+
+// endTerm ends the current term, returning its lock
+func (e *Election) endTerm() *cache.RedisLock {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.endTermUnlocked()
+}
+
+func (e *Election) endTermUnlocked() *cache.RedisLock {
+	lock := e.lock
+	if lock == nil {
+		return nil
+	}
+
+	e.lock = nil
+	e.until = time.Time{}
+	e.termClose()
+	e.termClose = nil
+	e.notifyUnlocked(false)
+	return lock
+}
+
+// leadingUnlocked whether the current term lease hasn't expired, ending the term once expired
+func (e *Election) leadingUnlocked() bool {
+	if e.lock == nil {
+		return false
+	}
+	if time.Now().Before(e.until) {
+		return true
+	}
+
+	e.endTermUnlocked()
+	return false
+}
+
+// leaseRemaining time until the lease of the current term expires; false when not leader
+func (e *Election) leaseRemaining() (time.Duration, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.leadingUnlocked() {
+		return 0, false
+	}
+	return time.Until(e.until), true
+}
+
+func (e *Election) notifyUnlocked(leading bool) {
+	for _, observer := range e.observers {
+		select {
+		case <-observer:
+		default:
+		}
+		observer <- leading
+	}
+}
@@ -0,0 +1,246 @@
+package leader_election
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pixie-sh/errors-go"
+	"github.com/pixie-sh/logger-go/logger"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	"github.com/pixie-sh/core-go/infra/cron"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+func newTestElections(t *testing.T, count int) ([]*Election, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	var elections []*Election
+	for i := 0; i < count; i++ {
+		locker, err := cache.NewRedisLock(context.Background(), redis.NewClient(&redis.Options{Addr: mr.Addr()}), cache.RedisLockConfiguration{
+			DefaultExpiration: time.Second,
+			DefaultRetryDelay: 10 * time.Millisecond,
+			MaxRetries:        1,
+		})
+		require.NoError(t, err)
+
+		election, err := NewElection(context.Background(), ElectionConfiguration{
+			Key: "leader",
+			TTL: coretime.Duration(300 * time.Millisecond),
+		}, locker)
+		require.NoError(t, err)
+		elections = append(elections, election)
+	}
+
+	return elections, mr
+}
+
+func leaders(elections []*Election) []*Election {
+	var leading []*Election
+	for _, election := range elections {
+		if election.IsLeader() {
+			leading = append(leading, election)
+		}
+	}
+	return leading
+}
+
+func TestElection_SingleLeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	elections, _ := newTestElections(t, 3)
+
+	for _, election := range elections {
+		election.RunAsync(ctx)
+	}
+
+	require.Eventually(t, func() bool { return len(leaders(elections)) == 1 }, time.Second, 10*time.Millisecond)
+	leader := leaders(elections)[0]
+	token := leader.Token()
+	assert.Positive(t, token)
+
+	// renewed past the TTL
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, []*Election{leader}, leaders(elections))
+
+	leader.Resign()
+	assert.False(t, leader.IsLeader())
+	assert.Zero(t, leader.Token())
+
+	require.Eventually(t, func() bool { return len(leaders(elections)) == 1 }, time.Second, 10*time.Millisecond)
+	assert.NotSame(t, leader, leaders(elections)[0])
+	assert.Greater(t, leaders(elections)[0].Token(), token)
+}
+
+func TestElection_LeadershipLost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	elections, mr := newTestElections(t, 1)
+	election := elections[0]
+
+	leadership, stop := election.Leadership()
+	defer stop()
+	assert.False(t, <-leadership)
+
+	terms := make(chan context.Context, 2)
+	election.OnElected(func(ctx context.Context) {
+		terms <- ctx
+	})
+
+	election.RunAsync(ctx)
+	assert.True(t, <-leadership)
+	term := <-terms
+
+	// taken by another candidate
+	require.NoError(t, mr.Set("leader", "other"))
+	assert.False(t, <-leadership)
+	assert.Eventually(t, func() bool { return term.Err() != nil }, time.Second, 10*time.Millisecond)
+
+	mr.Del("leader")
+	assert.True(t, <-leadership)
+	assert.NoError(t, (<-terms).Err())
+}
+
+func TestElection_LeaseExpired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	elections, _ := newTestElections(t, 1)
+	election := elections[0]
+
+	// not renewed before the lease expires
+	election.config.RenewInterval = coretime.Duration(time.Minute)
+
+	terms := make(chan context.Context, 1)
+	election.OnElected(func(ctx context.Context) {
+		terms <- ctx
+	})
+
+	election.RunAsync(ctx)
+	term := <-terms
+	assert.True(t, election.IsLeader())
+	assert.Equal(t, int64(1), election.Token())
+
+	leadership, stop := election.Leadership()
+	defer stop()
+	assert.True(t, <-leadership)
+
+	assert.Eventually(t, func() bool { return !election.IsLeader() }, time.Second, 10*time.Millisecond)
+	assert.Zero(t, election.Token())
+	assert.False(t, <-leadership)
+	assert.Error(t, term.Err())
+}
+
+func TestElection_RenewAfterTermEnded(t *testing.T) {
+	ctx := context.Background()
+	elections, _ := newTestElections(t, 1)
+	election := elections[0]
+
+	election.campaign(ctx)
+	require.True(t, election.IsLeader())
+
+	// Run checked the leadership, then the lease expires and another goroutine ends the term before renew
+	election.mu.Lock()
+	election.until = time.Now()
+	election.mu.Unlock()
+
+	ended := make(chan bool)
+	go func() { ended <- !election.IsLeader() }()
+	require.True(t, <-ended)
+
+	assert.NotPanics(t, func() { election.renew(ctx) })
+	assert.False(t, election.IsLeader())
+}
+
+func TestRunCronOnLeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	elections, _ := newTestElections(t, 2)
+
+	runs := make([]atomic.Int32, len(elections))
+	for i, election := range elections {
+		manager := cron.NewManager(ctx, logger.Logger)
+		job, err := cron.NewJob("job", "counts runs", func() { runs[i].Add(1) })
+		require.NoError(t, err)
+		_, err = manager.AddJob("@every 1s", job)
+		require.NoError(t, err)
+
+		RunCronOnLeader(election, manager)
+		election.RunAsync(ctx)
+	}
+
+	require.Eventually(t, func() bool { return len(leaders(elections)) == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(1500 * time.Millisecond)
+
+	for i, election := range elections {
+		if election.IsLeader() {
+			assert.Positive(t, runs[i].Load())
+		} else {
+			assert.Zero(t, runs[i].Load())
+		}
+	}
+}
+
+type testStarter struct {
+	started chan struct{}
+	stop    chan struct{}
+}
+
+func (s *testStarter) Valid() error  { return nil }
+func (s *testStarter) Setup() error  { return nil }
+func (s *testStarter) Defer()        {}
+func (s *testStarter) PanicHandler() {}
+func (s *testStarter) Start() error {
+	close(s.started)
+	<-s.stop
+	return nil
+}
+
+func TestLeaderStarter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	elections, mr := newTestElections(t, 1)
+	election := elections[0]
+
+	// taken by another replica
+	require.NoError(t, mr.Set("leader", "other"))
+
+	starter := &testStarter{started: make(chan struct{}), stop: make(chan struct{})}
+	defer close(starter.stop)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- NewLeaderStarter(ctx, election, starter).Start()
+	}()
+	election.RunAsync(ctx)
+
+	select {
+	case <-starter.started:
+		t.Fatal("started while not leader")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	mr.Del("leader")
+	select {
+	case <-starter.started:
+	case <-time.After(time.Second):
+		t.Fatal("not started once leader")
+	}
+
+	require.NoError(t, mr.Set("leader", "other"))
+	select {
+	case err := <-result:
+		_, lost := errors.Has(err, pixieErrors.LockLostErrorCode)
+		assert.True(t, lost)
+	case <-time.After(time.Second):
+		t.Fatal("leadership loss not reported")
+	}
+}
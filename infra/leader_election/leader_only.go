@@ -0,0 +1,65 @@
+package leader_election
+
+import (
+	"context"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/cron"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	"github.com/pixie-sh/core-go/pkg/microservice"
+)
+
+// RunCronOnLeader runs the manager jobs only while the replica is the leader
+func RunCronOnLeader(election *Election, manager *cron.Manager) {
+	election.OnElected(func(ctx context.Context) {
+		manager.RunAsync(ctx)
+	})
+}
+
+type leaderStarter struct {
+	microservice.Starter
+
+	ctx      context.Context
+	election *Election
+}
+
+// NewLeaderStarter starter starting only once the replica is the leader; the election must be running.
+// A starter can't be stopped, so Start fails with LockLostErrorCode when the leadership is lost, letting
+// the replica exit instead of running as a stale leader. Start returns nil if ctx is done before elected
+func NewLeaderStarter(ctx context.Context, election *Election, starter microservice.Starter) microservice.Starter {
+	return &leaderStarter{
+		Starter:  starter,
+		ctx:      ctx,
+		election: election,
+	}
+}
+
+func (s *leaderStarter) Start() error {
+	leadership, stop := s.election.Leadership()
+	defer stop()
+
+	for leading := false; !leading; {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		case leading = <-leadership:
+		}
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- s.Starter.Start()
+	}()
+
+	for {
+		select {
+		case err := <-started:
+			return err
+		case leading := <-leadership:
+			if !leading {
+				return errors.New("leadership of %s lost", s.election.config.Key).WithErrorCode(pixieErrors.LockLostErrorCode)
+			}
+		}
+	}
+}
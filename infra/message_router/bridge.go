@@ -2,28 +2,61 @@ package message_router
 
 import (
 	"context"
+	goErrors "errors"
+	"sync"
 
-	"github.com/pixie-sh/core-go/pkg/types"
-
+	"github.com/pixie-sh/errors-go"
 	"github.com/pixie-sh/logger-go/logger"
 
+	"github.com/pixie-sh/core-go/infra/events"
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
 	"github.com/pixie-sh/core-go/pkg/pubsub"
+	"github.com/pixie-sh/core-go/pkg/types"
 	"github.com/pixie-sh/core-go/pkg/uid"
 )
 
+// BridgeSource any events consumer (kafka, sqs, in memory) can feed a Bridge
 type BridgeSource interface {
+	events.Consumer
 }
 
+type BridgeConfiguration struct {
+	ConnectionID string   `json:"connection_id"` // virtual connection ID; a new UUID if empty
+	Batch        bool     `json:"batch"`         // consume with ConsumeBatch instead of Consume
+	ResponsesTo  []string `json:"responses_to"`  // To destinations set on responses sent to the responders
+}
+
+// Bridge exposes a BridgeSource as a virtual SourceConnection,
+// so Router handlers process queue events as they process socket messages.
+// Responses to the virtual connection are produced through the optional responders.
+// The Bridge also relays source subscriptions received on SourceSubscriber to its subscribers.
 type Bridge struct {
 	publisher  *pubsub.GenericPublisher[SourceSubscription]
 	subscriber *pubsub.OnProcessSubscriber[SourceSubscription]
 	source     BridgeSource
+	config     BridgeConfiguration
+	connection *bridgeConnection
 }
 
-func NewBridge(ctx context.Context, source BridgeSource) *Bridge {
+func NewBridge(ctx context.Context, config BridgeConfiguration, source BridgeSource, responders ...events.Producer) *Bridge {
+	if len(config.ConnectionID) == 0 {
+		config.ConnectionID = uid.NewUUID()
+	}
+
 	b := &Bridge{
 		publisher: pubsub.NewGenericPublisher[SourceSubscription](ctx),
 		source:    source,
+		config:    config,
+		connection: &bridgeConnection{
+			ctx:         ctx,
+			id:          config.ConnectionID,
+			locals:      make(map[string]interface{}),
+			subscribers: make(map[string]pubsub.Subscriber[message_wrapper.UntypedMessage]),
+			subscribed:  make(chan struct{}),
+			responders:  responders,
+			responsesTo: config.ResponsesTo,
+		},
 	}
 
 	b.subscriber = pubsub.NewOnProcessSubscriber[SourceSubscription](ctx, uid.NewUUID(), b.subscriberHandler, 512)
@@ -31,9 +64,38 @@ func NewBridge(ctx context.Context, source BridgeSource) *Bridge {
 }
 
 // Listen blocking call that listens to BridgeSource events
-func (b *Bridge) Listen(ctx context.Context) {
-	// TODO: woo
-	// b.publisher.NotifySubscriptions(...sdf)
+// the virtual connection is announced to subscribers and consumption starts once
+// something subscribes to it (usually Router); announced as removed when Listen returns
+func (b *Bridge) Listen(ctx context.Context) error {
+	if types.Nil(b.source) {
+		return errors.New("bridge source is nil").WithErrorCode(errors.InvalidProcessHandlerErrorCode)
+	}
+
+	log := pixiecontext.GetCtxLogger(ctx).With("bridge_connection_id", b.connection.ID())
+	b.publisher.NotifySubscriptions(SourceSubscription{Connection: b.connection, Added: true})
+	defer b.publisher.NotifySubscriptions(SourceSubscription{Connection: b.connection, Added: false})
+
+	err := b.awaitConnectionSubscribers(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Debug("bridge listening")
+	if b.config.Batch {
+		return b.source.ConsumeBatch(ctx, b.handle)
+	}
+
+	return b.source.Consume(ctx, b.handle)
+}
+
+// Connection returns the virtual connection fed by the BridgeSource
+func (b *Bridge) Connection() SourceConnection {
+	return b.connection
+}
+
+// SetLocals sets a value on the virtual connection, available to handlers through SourceConnection.Locals
+func (b *Bridge) SetLocals(key string, val interface{}) {
+	b.connection.SetLocals(key, val)
 }
 
 // Subscribe implements Publisher interface
@@ -55,13 +117,140 @@ func (b *Bridge) subscriberHandler(src SourceSubscription) {
 		return
 	}
 
-	if src.Added {
-		// coiso
+	logger.Logger.Debug("bridge relaying connection %s; added: %t", src.Connection.ID(), src.Added)
+	b.publisher.NotifySubscriptions(src)
+}
+
+// handle delivers the event to the virtual connection subscribers;
+// Router subscriptions process it synchronously, so the event is acknowledged after being routed
+// and the handlers errors are returned for the consumer to retry it. Payload types without handlers
+// are expected on shared topics, those events are acknowledged
+func (b *Bridge) handle(ctx context.Context, wrapper events.UntypedEventWrapper) error {
+	pixiecontext.GetCtxLogger(ctx).
+		With("bridge_connection_id", b.connection.ID()).
+		Debug("bridging event %s(%s)", wrapper.ID, wrapper.PayloadType)
+
+	var routingErrors []error
+	for _, sub := range b.connection.subscriptions() {
+		if router, ok := sub.(routingSubscriber); ok {
+			err := router.route(wrapper.UntypedMessage)
+			if goErrors.Is(err, errNoHandlers) {
+				pixiecontext.GetCtxLogger(ctx).
+					With("bridge_connection_id", b.connection.ID()).
+					Debug("no handlers for bridged event %s(%s), skipped", wrapper.ID, wrapper.PayloadType)
+				continue
+			}
+			if err != nil {
+				routingErrors = append(routingErrors, err)
+			}
+			continue
+		}
+
+		sub.Publish(wrapper.UntypedMessage)
+	}
+
+	if len(routingErrors) > 0 {
+		return errors.New("error routing bridged event %s(%s)", wrapper.ID, wrapper.PayloadType).
+			WithErrorCode(errors.ErrorPerformingRequestErrorCode).
+			WithNestedError(routingErrors...)
 	}
 
-	if !src.Added {
-		// cenas
+	return nil
+}
+
+func (b *Bridge) awaitConnectionSubscribers(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.connection.subscribed:
+		return nil
 	}
+}
 
-	return
+// routingSubscriber subscribers reporting the routing error of the messages, see Router
+type routingSubscriber interface {
+	route(msg message_wrapper.UntypedMessage) error
+}
+
+// bridgeConnection virtual SourceConnection of a Bridge
+type bridgeConnection struct {
+	ctx         context.Context
+	id          string
+	responders  []events.Producer
+	responsesTo []string
+
+	mu             sync.RWMutex
+	locals         map[string]interface{}
+	subscribers    map[string]pubsub.Subscriber[message_wrapper.UntypedMessage]
+	subscribed     chan struct{} // closed on the first subscription
+	subscribedOnce sync.Once
+}
+
+func (c *bridgeConnection) Ctx() context.Context {
+	return c.ctx
+}
+
+func (c *bridgeConnection) ID() string {
+	return c.id
+}
+
+func (c *bridgeConnection) Locals(key string) interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.locals[key]
+}
+
+func (c *bridgeConnection) SetLocals(key string, val interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.locals[key] = val
+}
+
+func (c *bridgeConnection) Subscribe(sub pubsub.Subscriber[message_wrapper.UntypedMessage]) {
+	c.mu.Lock()
+	c.subscribers[sub.ID()] = sub
+	c.mu.Unlock()
+
+	c.subscribedOnce.Do(func() {
+		close(c.subscribed)
+	})
+}
+
+func (c *bridgeConnection) subscriptions() []pubsub.Subscriber[message_wrapper.UntypedMessage] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	subs := make([]pubsub.Subscriber[message_wrapper.UntypedMessage], 0, len(c.subscribers))
+	for _, sub := range c.subscribers {
+		if !types.Nil(sub) {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// Publish receives the responses to the virtual connection and produces them through the responders;
+// without responders the responses are discarded
+func (c *bridgeConnection) Publish(message message_wrapper.UntypedMessage) {
+	log := pixiecontext.GetCtxLogger(c.ctx).
+		With("bridge_connection_id", c.id).
+		With("message", message)
+
+	if len(c.responders) == 0 {
+		log.Debug("bridge without responders; response %s discarded", message.ID)
+		return
+	}
+
+	wrapper := events.NewUntypedEventWrapperFromMessage(message)
+	wrapper.ClearTo().WithTo(c.responsesTo...)
+	for _, responder := range c.responders {
+		if types.Nil(responder) {
+			continue
+		}
+
+		err := responder.Produce(c.ctx, wrapper)
+		if err != nil {
+			log.With("error", err).Error("error producing bridge response %s on %s", message.ID, responder.ID())
+		}
+	}
 }
@@ -0,0 +1,182 @@
+package message_router
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/events"
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	"github.com/pixie-sh/core-go/pkg/types"
+)
+
+type bridgeTestPayload struct {
+	Value string `json:"value"`
+}
+
+// sliceConsumer events.Consumer that delivers the provided events and blocks until ctx is done;
+// the handler results are sent to results when set
+type sliceConsumer struct {
+	events  []events.UntypedEventWrapper
+	results chan error
+}
+
+func (c *sliceConsumer) ConsumeBatch(ctx context.Context, handler func(context.Context, events.UntypedEventWrapper) error) error {
+	return c.Consume(ctx, handler)
+}
+
+func (c *sliceConsumer) Consume(ctx context.Context, handler func(context.Context, events.UntypedEventWrapper) error) error {
+	for _, ev := range c.events {
+		err := handler(ctx, ev)
+		if c.results != nil {
+			c.results <- err
+		}
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+type recordingProducer struct {
+	mu       sync.Mutex
+	produced []events.UntypedEventWrapper
+}
+
+func (p *recordingProducer) ID() string {
+	return "recording"
+}
+
+func (p *recordingProducer) ProduceBatch(ctx context.Context, wrappers ...events.UntypedEventWrapper) error {
+	for _, wrapper := range wrappers {
+		_ = p.Produce(ctx, wrapper)
+	}
+	return nil
+}
+
+func (p *recordingProducer) Produce(_ context.Context, wrapper events.UntypedEventWrapper) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.produced = append(p.produced, wrapper)
+	return nil
+}
+
+func (p *recordingProducer) snapshot() []events.UntypedEventWrapper {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]events.UntypedEventWrapper{}, p.produced...)
+}
+
+func TestBridge_RoutesConsumedEventsAndResponses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payloadType := types.PayloadTypeOf[bridgeTestPayload]().String()
+	consumer := &sliceConsumer{events: []events.UntypedEventWrapper{
+		events.NewUntypedEventWrapperFromMessage(message_wrapper.NewUntypedMessage("ev-1", payloadType, bridgeTestPayload{Value: "hello"})),
+	}}
+	responder := &recordingProducer{}
+
+	router := NewNakedRouter(ctx)
+	handled := make(chan string, 1)
+	Register[bridgeTestPayload](func(ctx context.Context, payload *bridgeTestPayload) error {
+		handled <- payload.Value
+		return nil
+	}, router)
+
+	bridge := NewBridge(ctx, BridgeConfiguration{ConnectionID: "bridge", ResponsesTo: []string{"replies"}}, consumer, responder)
+	bridge.Subscribe(router.SourceSubscriber())
+
+	listened := make(chan error, 1)
+	go func() {
+		listened <- bridge.Listen(ctx)
+	}()
+
+	select {
+	case value := <-handled:
+		assert.Equal(t, "hello", value)
+	case <-time.After(time.Second):
+		t.Fatal("bridged event not handled")
+	}
+
+	require.Eventually(t, func() bool { return len(responder.snapshot()) == 1 }, time.Second, 5*time.Millisecond)
+	response := responder.snapshot()[0]
+	assert.Equal(t, "ev-1", response.ID)
+	assert.Equal(t, []string{"replies"}, response.To)
+
+	cancel()
+	select {
+	case err := <-listened:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("listen did not return")
+	}
+}
+
+func TestBridge_ReturnsRoutingErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payloadType := types.PayloadTypeOf[bridgeTestPayload]().String()
+	consumer := &sliceConsumer{
+		events: []events.UntypedEventWrapper{
+			events.NewUntypedEventWrapperFromMessage(message_wrapper.NewUntypedMessage("ev-1", payloadType, bridgeTestPayload{Value: "fail"})),
+			events.NewUntypedEventWrapperFromMessage(message_wrapper.NewUntypedMessage("ev-2", payloadType, bridgeTestPayload{Value: "ok"})),
+			events.NewUntypedEventWrapperFromMessage(message_wrapper.NewUntypedMessage("ev-3", "unknown", nil)),
+		},
+		results: make(chan error, 3),
+	}
+
+	router := NewNakedRouter(ctx)
+	Register[bridgeTestPayload](func(ctx context.Context, payload *bridgeTestPayload) error {
+		if payload.Value == "fail" {
+			return errors.New("handler failed")
+		}
+		return nil
+	}, router)
+
+	bridge := NewBridge(ctx, BridgeConfiguration{}, consumer)
+	bridge.Subscribe(router.SourceSubscriber())
+	go func() {
+		_ = bridge.Listen(ctx)
+	}()
+
+	// events without handlers are acknowledged, only handler failures are retried
+	for _, failed := range []bool{true, false, false} {
+		select {
+		case err := <-consumer.results:
+			assert.Equal(t, failed, err != nil, err)
+		case <-time.After(time.Second):
+			t.Fatal("bridged event not handled")
+		}
+	}
+}
+
+func TestBridge_AwaitsConnectionSubscribers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bridge := NewBridge(ctx, BridgeConfiguration{}, &sliceConsumer{})
+	awaited := make(chan error, 1)
+	go func() {
+		awaited <- bridge.awaitConnectionSubscribers(ctx)
+	}()
+
+	select {
+	case <-awaited:
+		t.Fatal("returned without subscribers")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	bridge.Connection().Subscribe(&innerSub{connection: bridge.Connection(), router: NewNakedRouter(ctx)})
+	select {
+	case err := <-awaited:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("subscription not signaled")
+	}
+}
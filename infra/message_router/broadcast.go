@@ -34,6 +34,8 @@ type Broadcast struct {
 	informationForChannelID  func(channelID string) (SourceInformation, error)
 	finalize                 func(performedBroadcasts []BroadcastResult) error
 	subscriber               *pubsub.OnProcessSubscriber[SourceSubscription]
+	history                  HistoryStore
+	historyConfig            HistoryConfiguration
 
 	mu      sync.RWMutex
 	sources map[string]*struct {
@@ -105,6 +107,14 @@ func (b *Broadcast) subscriberHandler(src SourceSubscription) {
 
 	if added {
 		logger.Logger.Debug("connection %s on broadcaster to be added", connection.ID())
+
+		// live messages are held back until the history is replayed, so they're delivered after it and once
+		var replaying *replayingConnection
+		if len(src.ReplayCursor) > 0 && b.history != nil {
+			replaying = newReplayingConnection(connection, src.ReplayCursor)
+			connection = replaying
+		}
+
 		sourceData := &struct {
 			SourceConnection
 			SourceInformation
@@ -125,6 +135,15 @@ func (b *Broadcast) subscriberHandler(src SourceSubscription) {
 		b.sources[connection.ID()] = sourceData
 		b.mu.Unlock()
 		logger.Logger.Debug("connection %s on broadcaster added", connection.ID())
+
+		if replaying != nil {
+			channels := append([]string{sourceInformation.ChannelID}, sourceInformation.RelatedTo...)
+			lastCursor, err := b.replay(connection.Ctx(), replaying.SourceConnection, src.ReplayCursor, channels...)
+			if err != nil {
+				logger.Logger.With("error", err).Error("unable to replay history for connection %s", connection.ID())
+			}
+			replaying.replayed(lastCursor)
+		}
 	}
 
 	if !added {
@@ -165,7 +184,8 @@ func (b *Broadcast) BroadcastCtx(ctx *BroadcastContext) []BroadcastResult {
 				message.SetHeader(models.HeaderPublisherID, broadcastID)
 			}
 
-			processedBroadcast = b.processBroadcast(broadcastID, channel.ChannelIdentifier, channel.Messages, processedBroadcast)
+			messages := b.persist(b.appCtx, channel.ChannelIdentifier, channel.Messages)
+			processedBroadcast = b.processBroadcast(broadcastID, channel.ChannelIdentifier, messages, processedBroadcast)
 			processedBroadcasts = append(processedBroadcasts, processedBroadcast)
 
 			if !types.IsEmpty(processedBroadcast) && channel.UseFinalizer {
@@ -186,6 +206,7 @@ func (b *Broadcast) BroadcastCtx(ctx *BroadcastContext) []BroadcastResult {
 }
 
 func (b *Broadcast) Broadcast(fromID string, broadcastChannelID string, messages ...message_wrapper.UntypedMessage) BroadcastResult {
+	messages = b.persist(b.appCtx, broadcastChannelID, messages)
 	return b.processBroadcast(fromID, broadcastChannelID, messages, BroadcastResult{MessagesPerRelatedChannelID: nil})
 }
 
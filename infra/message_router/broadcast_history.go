@@ -0,0 +1,412 @@
+package message_router
+
+import (
+	"cmp"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	"github.com/pixie-sh/core-go/pkg/models"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+// HistoryEntry broadcast message persisted on a channel history
+// Cursor is the store position of the message, clients send it back to replay what they missed
+type HistoryEntry struct {
+	Cursor  string                         `json:"cursor"`
+	Message message_wrapper.UntypedMessage `json:"message"`
+}
+
+// HistoryStore bounded per channel message store used by Broadcast
+// cursors are expected to be monotonic within the store, so a single cursor can be used to
+// replay several channels
+type HistoryStore interface {
+	// Append persists messages on channelID applying the provided retention;
+	// returns the entries in the same order as the provided messages
+	Append(ctx context.Context, channelID string, retention HistoryRetention, messages ...message_wrapper.UntypedMessage) ([]HistoryEntry, error)
+
+	// Since returns up to limit entries of channelID after cursor; empty cursor returns from the oldest retained
+	Since(ctx context.Context, channelID string, cursor string, limit int) ([]HistoryEntry, error)
+}
+
+// HistoryRetention bounds the messages kept per channel
+// zero values mean no bound on that dimension; both zero means history is disabled
+type HistoryRetention struct {
+	MaxMessages int               `json:"max_messages"`
+	MaxAge      coretime.Duration `json:"max_age"`
+}
+
+func (r HistoryRetention) Enabled() bool {
+	return r.MaxMessages > 0 || r.MaxAge > 0
+}
+
+// HistoryConfiguration retention policies per channel prefix
+// the longest matching prefix wins; Default is used when no prefix matches
+type HistoryConfiguration struct {
+	Default     HistoryRetention            `json:"default"`
+	Retentions  map[string]HistoryRetention `json:"retentions"`
+	ReplayLimit int                         `json:"replay_limit"`
+}
+
+// RetentionFor returns the retention for the channelID and if the history is enabled for it
+func (c HistoryConfiguration) RetentionFor(channelID string) (HistoryRetention, bool) {
+	var (
+		retention = c.Default
+		matched   = -1
+	)
+
+	for prefix, prefixRetention := range c.Retentions {
+		if len(prefix) > matched && strings.HasPrefix(channelID, prefix) {
+			retention = prefixRetention
+			matched = len(prefix)
+		}
+	}
+
+	return retention, retention.Enabled()
+}
+
+// WithHistory enables message persistence and replay on the broadcast
+func (b *Broadcast) WithHistory(store HistoryStore, config HistoryConfiguration) *Broadcast {
+	b.history = store
+	b.historyConfig = config
+	return b
+}
+
+// History returns the persisted messages of channelID after cursor
+func (b *Broadcast) History(ctx context.Context, channelID string, cursor string, limit ...int) ([]HistoryEntry, error) {
+	if b.history == nil {
+		return nil, nil
+	}
+
+	max := b.historyConfig.ReplayLimit
+	if len(limit) > 0 {
+		max = limit[0]
+	}
+
+	return b.history.Since(ctx, channelID, cursor, max)
+}
+
+// Replay publishes to the connection the messages persisted on the channels after cursor, ordered by cursor
+func (b *Broadcast) Replay(ctx context.Context, connection SourceConnection, cursor string, channelIDs ...string) error {
+	_, err := b.replay(ctx, connection, cursor, channelIDs...)
+	return err
+}
+
+// replay returns the cursor of the last message published, cursor itself when none was
+func (b *Broadcast) replay(ctx context.Context, connection SourceConnection, cursor string, channelIDs ...string) (string, error) {
+	var replayed []HistoryEntry
+	for _, channelID := range channelIDs {
+		entries, err := b.History(ctx, channelID, cursor)
+		if err != nil {
+			return cursor, err
+		}
+
+		replayed = append(replayed, entries...)
+	}
+
+	sort.SliceStable(replayed, func(i, j int) bool {
+		return CompareHistoryCursors(replayed[i].Cursor, replayed[j].Cursor) < 0
+	})
+
+	lastCursor := cursor
+	for _, entry := range replayed {
+		connection.Publish(entry.Message)
+		lastCursor = entry.Cursor
+	}
+
+	return lastCursor, nil
+}
+
+// CompareHistoryCursors compares the cursors of the provided stores, sequences ("42") and
+// redis stream IDs ("1700000000000-3"); -1 when a is before b, 0 when equal, 1 when after
+func CompareHistoryCursors(a string, b string) int {
+	aTime, aSeq, aOk := parseHistoryCursor(a)
+	bTime, bSeq, bOk := parseHistoryCursor(b)
+	if !aOk || !bOk {
+		return strings.Compare(a, b)
+	}
+
+	if aTime != bTime {
+		return cmp.Compare(aTime, bTime)
+	}
+	return cmp.Compare(aSeq, bSeq)
+}
+
+func parseHistoryCursor(cursor string) (uint64, uint64, bool) {
+	first, second, found := strings.Cut(cursor, "-")
+
+	major, err := strconv.ParseUint(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !found {
+		return major, 0, true
+	}
+
+	minor, err := strconv.ParseUint(second, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// replayingConnection holds back the messages published to the connection while its history is replayed;
+// afterwards messages at or before the last replayed cursor are dropped as already delivered
+type replayingConnection struct {
+	SourceConnection
+
+	mu         sync.Mutex
+	replaying  bool
+	lastCursor string
+	held       []message_wrapper.UntypedMessage
+}
+
+func newReplayingConnection(connection SourceConnection, cursor string) *replayingConnection {
+	return &replayingConnection{
+		SourceConnection: connection,
+		replaying:        true,
+		lastCursor:       cursor,
+	}
+}
+
+func (c *replayingConnection) Publish(message message_wrapper.UntypedMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.replaying {
+		c.held = append(c.held, message)
+		return
+	}
+
+	c.publishUnlocked(message)
+}
+
+// replayed publishes the messages held back during the replay
+func (c *replayingConnection) replayed(lastCursor string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.replaying = false
+	if len(lastCursor) > 0 {
+		c.lastCursor = lastCursor
+	}
+
+	for _, message := range c.held {
+		c.publishUnlocked(message)
+	}
+	c.held = nil
+}
+
+func (c *replayingConnection) publishUnlocked(message message_wrapper.UntypedMessage) {
+	cursor := message.GetHeaderString(models.HeaderHistoryCursor)
+	if len(cursor) > 0 && CompareHistoryCursors(cursor, c.lastCursor) <= 0 {
+		return
+	}
+
+	c.SourceConnection.Publish(message)
+}
+
+// persist stores the messages when channelID has history enabled
+// returned messages are copies with models.HeaderHistoryCursor set; on failure the original messages are returned
+func (b *Broadcast) persist(ctx context.Context, channelID string, messages []message_wrapper.UntypedMessage) []message_wrapper.UntypedMessage {
+	if b.history == nil || len(messages) == 0 {
+		return messages
+	}
+
+	retention, enabled := b.historyConfig.RetentionFor(channelID)
+	if !enabled {
+		return messages
+	}
+
+	entries, err := b.history.Append(ctx, channelID, retention, messages...)
+	if err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).Error("unable to persist broadcast history for %s", channelID)
+		return messages
+	}
+
+	persisted := make([]message_wrapper.UntypedMessage, len(entries))
+	for i, entry := range entries {
+		persisted[i] = entry.Message
+	}
+
+	return persisted
+}
+
+// NewHistoryEntry creates the entry with models.HeaderHistoryCursor set on a copy of the message headers,
+// so the same message can hold distinct cursors per channel
+func NewHistoryEntry(cursor string, message message_wrapper.UntypedMessage) HistoryEntry {
+	headers := make(map[string]interface{}, len(message.Headers)+1)
+	for key, val := range message.Headers {
+		headers[key] = val
+	}
+
+	message.Headers = headers
+	message.SetHeader(models.HeaderHistoryCursor, cursor)
+	return HistoryEntry{
+		Cursor:  cursor,
+		Message: message,
+	}
+}
+
+// MemoryHistoryStore in process ring buffer per channel
+// cursors are shared by all channels, so they are comparable among them
+type MemoryHistoryStore struct {
+	mu       sync.RWMutex
+	sequence uint64
+	channels map[string]*memoryHistoryRing
+}
+
+type memoryHistoryRing struct {
+	entries []memoryHistoryEntry
+	start   int
+	size    int
+	maxAge  time.Duration
+}
+
+type memoryHistoryEntry struct {
+	sequence uint64
+	at       time.Time
+	entry    HistoryEntry
+}
+
+func NewMemoryHistoryStore(_ context.Context) *MemoryHistoryStore {
+	return &MemoryHistoryStore{
+		channels: make(map[string]*memoryHistoryRing),
+	}
+}
+
+// Append implements HistoryStore; without MaxMessages the ring grows unbounded and relies on MaxAge
+func (s *MemoryHistoryStore) Append(_ context.Context, channelID string, retention HistoryRetention, messages ...message_wrapper.UntypedMessage) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ring, ok := s.channels[channelID]
+	if !ok {
+		ring = &memoryHistoryRing{}
+		s.channels[channelID] = ring
+	}
+
+	now := time.Now().UTC()
+	result := make([]HistoryEntry, 0, len(messages))
+	for _, message := range messages {
+		s.sequence++
+		cursor := strconv.FormatUint(s.sequence, 10)
+		entry := NewHistoryEntry(cursor, message)
+
+		ring.push(memoryHistoryEntry{sequence: s.sequence, at: now, entry: entry}, retention.MaxMessages)
+		result = append(result, entry)
+	}
+
+	ring.maxAge = retention.MaxAge.Duration()
+	if ring.maxAge > 0 {
+		ring.expire(now.Add(-ring.maxAge))
+	}
+
+	return result, nil
+}
+
+// Since implements HistoryStore
+func (s *MemoryHistoryStore) Since(_ context.Context, channelID string, cursor string, limit int) ([]HistoryEntry, error) {
+	var after uint64
+	if len(cursor) > 0 {
+		parsed, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		after = parsed
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ring, ok := s.channels[channelID]
+	if !ok {
+		return []HistoryEntry{}, nil
+	}
+
+	var (
+		result  []HistoryEntry
+		expired time.Time
+	)
+
+	if ring.maxAge > 0 {
+		expired = time.Now().UTC().Add(-ring.maxAge)
+	}
+
+	for i := 0; i < ring.size; i++ {
+		item := ring.at(i)
+		if item.sequence <= after || item.at.Before(expired) {
+			continue
+		}
+
+		result = append(result, item.entry)
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+func (r *memoryHistoryRing) at(i int) memoryHistoryEntry {
+	return r.entries[(r.start+i)%len(r.entries)]
+}
+
+func (r *memoryHistoryRing) push(item memoryHistoryEntry, capacity int) {
+	if capacity <= 0 {
+		r.compact()
+		r.entries = append(r.entries, item)
+		r.size++
+		return
+	}
+
+	if len(r.entries) != capacity {
+		r.compact()
+		if len(r.entries) > capacity {
+			r.entries = r.entries[len(r.entries)-capacity:]
+			r.size = capacity
+		}
+
+		grown := make([]memoryHistoryEntry, r.size, capacity)
+		copy(grown, r.entries)
+		r.entries = grown[:capacity]
+	}
+
+	if r.size < capacity {
+		r.entries[(r.start+r.size)%capacity] = item
+		r.size++
+		return
+	}
+
+	r.entries[r.start] = item
+	r.start = (r.start + 1) % capacity
+}
+
+// expire drops entries older than before, oldest are always at the start
+func (r *memoryHistoryRing) expire(before time.Time) {
+	for r.size > 0 && r.at(0).at.Before(before) {
+		r.entries[r.start] = memoryHistoryEntry{}
+		r.start = (r.start + 1) % len(r.entries)
+		r.size--
+	}
+}
+
+// compact rewrites the ring as a plain slice starting at index 0
+func (r *memoryHistoryRing) compact() {
+	if r.start == 0 && len(r.entries) == r.size {
+		return
+	}
+
+	compacted := make([]memoryHistoryEntry, r.size)
+	for i := 0; i < r.size; i++ {
+		compacted[i] = r.at(i)
+	}
+
+	r.entries = compacted
+	r.start = 0
+}
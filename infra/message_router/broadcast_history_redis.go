@@ -0,0 +1,113 @@
+package message_router
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	"github.com/pixie-sh/core-go/pkg/models/serializer"
+	"github.com/pixie-sh/core-go/pkg/types"
+)
+
+const redisHistoryMessageField = "message"
+
+// RedisHistoryStore keeps channel history on redis streams, one stream per channel
+// cursors are the stream entry IDs, they are time based so can be used among channels
+type RedisHistoryStore struct {
+	client    redis.Cmdable
+	keyPrefix string
+}
+
+func NewRedisHistoryStore(_ context.Context, client redis.Cmdable, keyPrefix string) *RedisHistoryStore {
+	return &RedisHistoryStore{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+func (s *RedisHistoryStore) key(channelID string) string {
+	return fmt.Sprintf("%s%s", s.keyPrefix, channelID)
+}
+
+// Append implements HistoryStore; MaxMessages trims with XADD MAXLEN and MaxAge with XTRIM MINID
+// the stream itself expires after MaxAge without new messages
+func (s *RedisHistoryStore) Append(ctx context.Context, channelID string, retention HistoryRetention, messages ...message_wrapper.UntypedMessage) ([]HistoryEntry, error) {
+	key := s.key(channelID)
+	pipeline := s.client.TxPipeline()
+
+	cmds := make([]*redis.StringCmd, len(messages))
+	for i, message := range messages {
+		blob, err := serializer.Serialize(message)
+		if err != nil {
+			return nil, err
+		}
+
+		cmds[i] = pipeline.XAdd(ctx, &redis.XAddArgs{
+			Stream: key,
+			MaxLen: int64(retention.MaxMessages),
+			Values: []interface{}{redisHistoryMessageField, types.UnsafeString(blob)},
+		})
+	}
+
+	if retention.MaxAge > 0 {
+		minID := fmt.Sprintf("%d-0", time.Now().Add(-retention.MaxAge.Duration()).UnixMilli())
+		pipeline.XTrimMinID(ctx, key, minID)
+		pipeline.Expire(ctx, key, retention.MaxAge.Duration())
+	}
+
+	_, err := pipeline.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]HistoryEntry, len(messages))
+	for i, cmd := range cmds {
+		entries[i] = NewHistoryEntry(cmd.Val(), messages[i])
+	}
+
+	return entries, nil
+}
+
+// Since implements HistoryStore
+func (s *RedisHistoryStore) Since(ctx context.Context, channelID string, cursor string, limit int) ([]HistoryEntry, error) {
+	start := "-"
+	if len(cursor) > 0 {
+		start = "(" + cursor
+	}
+
+	var (
+		streamEntries []redis.XMessage
+		err           error
+	)
+
+	if limit > 0 {
+		streamEntries, err = s.client.XRangeN(ctx, s.key(channelID), start, "+", int64(limit)).Result()
+	} else {
+		streamEntries, err = s.client.XRange(ctx, s.key(channelID), start, "+").Result()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]HistoryEntry, 0, len(streamEntries))
+	for _, streamEntry := range streamEntries {
+		blob, ok := streamEntry.Values[redisHistoryMessageField].(string)
+		if !ok {
+			continue
+		}
+
+		var message message_wrapper.UntypedMessage
+		err = serializer.Deserialize(types.UnsafeBytes(blob), &message, false)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, NewHistoryEntry(streamEntry.ID, message))
+	}
+
+	return entries, nil
+}
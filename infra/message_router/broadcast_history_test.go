@@ -0,0 +1,267 @@
+package message_router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pixie-sh/errors-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/message_buses"
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	"github.com/pixie-sh/core-go/pkg/models"
+	"github.com/pixie-sh/core-go/pkg/pubsub"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+func historyMessages(n int) []message_wrapper.UntypedMessage {
+	messages := make([]message_wrapper.UntypedMessage, n)
+	for i := range messages {
+		messages[i] = message_wrapper.NewUntypedMessage(fmt.Sprintf("msg-%d", i), "test", i)
+	}
+	return messages
+}
+
+func TestHistoryConfiguration_RetentionFor(t *testing.T) {
+	config := HistoryConfiguration{
+		Default: HistoryRetention{},
+		Retentions: map[string]HistoryRetention{
+			"room_":         {MaxMessages: 10},
+			"room_vip_":     {MaxMessages: 100},
+			"party_":        {MaxAge: coretime.Duration(time.Hour)},
+			"notifications": {},
+		},
+	}
+
+	retention, enabled := config.RetentionFor("room_123")
+	assert.True(t, enabled)
+	assert.Equal(t, 10, retention.MaxMessages)
+
+	retention, enabled = config.RetentionFor("room_vip_123")
+	assert.True(t, enabled)
+	assert.Equal(t, 100, retention.MaxMessages)
+
+	_, enabled = config.RetentionFor("party_1")
+	assert.True(t, enabled)
+
+	_, enabled = config.RetentionFor("unknown")
+	assert.False(t, enabled)
+
+	_, enabled = config.RetentionFor("notifications_1")
+	assert.False(t, enabled)
+}
+
+func TestMemoryHistoryStore_MaxMessages(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryHistoryStore(ctx)
+	retention := HistoryRetention{MaxMessages: 3}
+
+	entries, err := store.Append(ctx, "room_1", retention, historyMessages(5)...)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	assert.Equal(t, entries[4].Cursor, entries[4].Message.GetHeaderString(models.HeaderHistoryCursor))
+
+	since, err := store.Since(ctx, "room_1", "", 0)
+	require.NoError(t, err)
+	require.Len(t, since, 3)
+	assert.Equal(t, "msg-2", since[0].Message.ID)
+	assert.Equal(t, "msg-4", since[2].Message.ID)
+
+	since, err = store.Since(ctx, "room_1", entries[3].Cursor, 0)
+	require.NoError(t, err)
+	require.Len(t, since, 1)
+	assert.Equal(t, "msg-4", since[0].Message.ID)
+
+	since, err = store.Since(ctx, "room_1", "", 2)
+	require.NoError(t, err)
+	assert.Len(t, since, 2)
+}
+
+func TestMemoryHistoryStore_CursorsSharedAmongChannels(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryHistoryStore(ctx)
+	retention := HistoryRetention{MaxMessages: 10}
+
+	messages := historyMessages(1)
+	roomEntries, err := store.Append(ctx, "room_1", retention, messages...)
+	require.NoError(t, err)
+	partyEntries, err := store.Append(ctx, "party_1", retention, messages...)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, roomEntries[0].Cursor, partyEntries[0].Cursor)
+	assert.Nil(t, messages[0].GetHeader(models.HeaderHistoryCursor))
+
+	since, err := store.Since(ctx, "party_1", roomEntries[0].Cursor, 0)
+	require.NoError(t, err)
+	assert.Len(t, since, 1)
+}
+
+func TestMemoryHistoryStore_MaxAge(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryHistoryStore(ctx)
+	retention := HistoryRetention{MaxAge: coretime.Duration(50 * time.Millisecond)}
+
+	_, err := store.Append(ctx, "room_1", retention, historyMessages(2)...)
+	require.NoError(t, err)
+
+	time.Sleep(60 * time.Millisecond)
+	since, err := store.Since(ctx, "room_1", "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, since)
+
+	_, err = store.Append(ctx, "room_1", retention, historyMessages(1)...)
+	require.NoError(t, err)
+	since, err = store.Since(ctx, "room_1", "", 0)
+	require.NoError(t, err)
+	assert.Len(t, since, 1)
+}
+
+type historyTestConnection struct {
+	id       string
+	mu       sync.Mutex
+	received []message_wrapper.UntypedMessage
+}
+
+func (c *historyTestConnection) Ctx() context.Context                                        { return context.Background() }
+func (c *historyTestConnection) ID() string                                                  { return c.id }
+func (c *historyTestConnection) Locals(string) interface{}                                   { return nil }
+func (c *historyTestConnection) Subscribe(pubsub.Subscriber[message_wrapper.UntypedMessage]) {}
+func (c *historyTestConnection) Publish(message message_wrapper.UntypedMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.received = append(c.received, message)
+}
+
+func (c *historyTestConnection) receivedIDs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, len(c.received))
+	for i, message := range c.received {
+		ids[i] = message.ID
+	}
+	return ids
+}
+
+// blockingHistoryStore blocks Since until released, signaling entered
+type blockingHistoryStore struct {
+	HistoryStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingHistoryStore) Since(ctx context.Context, channelID string, cursor string, limit int) ([]HistoryEntry, error) {
+	close(s.entered)
+	<-s.release
+	return s.HistoryStore.Since(ctx, channelID, cursor, limit)
+}
+
+func newHistoryTestBroadcast(t *testing.T, store HistoryStore) *Broadcast {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return NewBroadcast(ctx, "broadcast", message_buses.NewBusPool(ctx),
+		func(conn SourceConnection) (SourceInformation, error) {
+			return SourceInformation{ChannelID: "room_1"}, nil
+		},
+		func(channelID string) (SourceInformation, error) {
+			return SourceInformation{}, errors.New("unknown channel %s", channelID)
+		},
+		func([]BroadcastResult) error { return nil },
+	).WithHistory(store, HistoryConfiguration{Default: HistoryRetention{MaxMessages: 10}})
+}
+
+func TestBroadcast_WithHistoryPersists(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryHistoryStore(ctx)
+	broadcast := newHistoryTestBroadcast(t, store)
+
+	connection := &historyTestConnection{id: "conn-1"}
+	broadcast.subscriberHandler(SourceSubscription{Connection: connection, Added: true})
+
+	broadcast.Broadcast("sender", "room_1", historyMessages(2)...)
+	assert.Equal(t, []string{"msg-0", "msg-1"}, connection.receivedIDs())
+
+	entries, err := broadcast.History(ctx, "room_1", "")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, entries[1].Cursor, connection.received[1].GetHeaderString(models.HeaderHistoryCursor))
+}
+
+func TestBroadcast_ReplayOnSubscribe(t *testing.T) {
+	ctx := context.Background()
+	memoryStore := NewMemoryHistoryStore(ctx)
+	store := &blockingHistoryStore{HistoryStore: memoryStore, entered: make(chan struct{}), release: make(chan struct{})}
+	broadcast := newHistoryTestBroadcast(t, store)
+
+	entries, err := memoryStore.Append(ctx, "room_1", HistoryRetention{MaxMessages: 10}, historyMessages(3)...)
+	require.NoError(t, err)
+
+	connection := &historyTestConnection{id: "conn-1"}
+	subscribed := make(chan struct{})
+	go func() {
+		defer close(subscribed)
+		broadcast.subscriberHandler(SourceSubscription{Connection: connection, Added: true, ReplayCursor: entries[0].Cursor})
+	}()
+
+	// broadcast while the history is being read, it's both persisted and delivered live
+	<-store.entered
+	live := message_wrapper.NewUntypedMessage("live", "test", nil)
+	broadcast.Broadcast("sender", "room_1", live)
+	assert.Empty(t, connection.receivedIDs())
+
+	close(store.release)
+	<-subscribed
+	assert.Equal(t, []string{"msg-1", "msg-2", "live"}, connection.receivedIDs())
+
+	broadcast.Broadcast("sender", "room_1", historyMessages(1)...)
+	assert.Equal(t, []string{"msg-1", "msg-2", "live", "msg-0"}, connection.receivedIDs())
+}
+
+func TestCompareHistoryCursors(t *testing.T) {
+	assert.Equal(t, -1, CompareHistoryCursors("9", "10"))
+	assert.Equal(t, 0, CompareHistoryCursors("10", "10"))
+	assert.Equal(t, 1, CompareHistoryCursors("1700000000001-0", "1700000000000-12"))
+	assert.Equal(t, -1, CompareHistoryCursors("1700000000000-2", "1700000000000-12"))
+}
+
+func TestRedisHistoryStore(t *testing.T) {
+	ctx := context.Background()
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	store := NewRedisHistoryStore(ctx, redis.NewClient(&redis.Options{Addr: mr.Addr()}), "history:")
+	retention := HistoryRetention{MaxMessages: 3, MaxAge: coretime.Duration(time.Hour)}
+
+	entries, err := store.Append(ctx, "room_1", retention, historyMessages(5)...)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	assert.Equal(t, entries[4].Cursor, entries[4].Message.GetHeaderString(models.HeaderHistoryCursor))
+	assert.Equal(t, time.Hour, mr.TTL("history:room_1"))
+
+	since, err := store.Since(ctx, "room_1", "", 0)
+	require.NoError(t, err)
+	require.Len(t, since, 3)
+	assert.Equal(t, "msg-2", since[0].Message.ID)
+	assert.Equal(t, entries[2].Cursor, since[0].Cursor)
+
+	since, err = store.Since(ctx, "room_1", entries[3].Cursor, 0)
+	require.NoError(t, err)
+	require.Len(t, since, 1)
+	assert.Equal(t, "msg-4", since[0].Message.ID)
+
+	since, err = store.Since(ctx, "room_1", "", 2)
+	require.NoError(t, err)
+	assert.Len(t, since, 2)
+
+	since, err = store.Since(ctx, "missing", "", 0)
+	require.NoError(t, err)
+	assert.Empty(t, since)
+}
@@ -11,6 +11,9 @@ import (
 type SourceSubscription struct {
 	Connection SourceConnection
 	Added      bool
+
+	// ReplayCursor when set on Added, the Broadcast history after the cursor is replayed to the connection
+	ReplayCursor string
 }
 
 type MessageHandler = func(ctx *RouterContext)
@@ -0,0 +1,440 @@
+package message_router_client
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+	"golang.org/x/net/websocket"
+
+	"github.com/pixie-sh/core-go/infra/message_factory"
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	"github.com/pixie-sh/core-go/pkg/models"
+	"github.com/pixie-sh/core-go/pkg/models/serializer"
+	"github.com/pixie-sh/core-go/pkg/types"
+	"github.com/pixie-sh/core-go/pkg/uid"
+)
+
+// MessageHandler handles inbound messages of a payload type
+type MessageHandler = func(ctx context.Context, message message_wrapper.UntypedMessage)
+
+// SSAMatcher identifies server side acknowledgements and returns the acknowledged request ID;
+// the message is only handled as SSA if a request with that ID is awaiting
+type SSAMatcher func(message message_wrapper.UntypedMessage) (requestID string, ok bool)
+
+// SameIDSSAMatcher SSAs carry the request ID, as the Router createSSA functions usually do
+func SameIDSSAMatcher(message message_wrapper.UntypedMessage) (string, bool) {
+	return message.ID, true
+}
+
+type ssaResult struct {
+	message message_wrapper.UntypedMessage
+	err     error
+}
+
+// Client message_router websocket client
+// inbound messages are decoded through the message_factory.Factory and dispatched to the registered handlers;
+// the connection is re-established with backoff when lost and the subscriptions are sent again
+type Client struct {
+	config  Configuration
+	factory *message_factory.Factory
+	isSSA   SSAMatcher
+
+	writeMu sync.Mutex
+
+	mu            sync.RWMutex
+	conn          *websocket.Conn
+	closed        bool
+	handlers      map[string]MessageHandler
+	pending       map[string]chan ssaResult
+	subscriptions []message_wrapper.UntypedMessage
+	historyCursor string
+}
+
+// NewClient creates a Client; without factory message_factory.Singleton is used
+func NewClient(_ context.Context, config Configuration, factory *message_factory.Factory, withSSAMatcher ...SSAMatcher) *Client {
+	if factory == nil {
+		factory = message_factory.Singleton
+	}
+
+	var isSSA SSAMatcher = SameIDSSAMatcher
+	if len(withSSAMatcher) > 0 && withSSAMatcher[0] != nil {
+		isSSA = withSSAMatcher[0]
+	}
+
+	return &Client{
+		config:   config.withDefaults(),
+		factory:  factory,
+		isSSA:    isSSA,
+		handlers: make(map[string]MessageHandler),
+		pending:  make(map[string]chan ssaResult),
+	}
+}
+
+// Connect dials the endpoint and starts reading; reconnections happen until Close or ctx is done
+func (c *Client) Connect(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	c.closed = false
+	c.mu.Unlock()
+
+	go c.run(ctx, conn)
+	return nil
+}
+
+// Close closes the connection without reconnecting; awaiting requests fail
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+
+	c.failPending(errors.New("client closed").WithErrorCode(errors.ConnectionNotActive))
+	if conn != nil {
+		return conn.Close()
+	}
+
+	return nil
+}
+
+// Register sets the handler of a payload type, replacing any previous one
+func (c *Client) Register(payloadType string, handler MessageHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[payloadType] = handler
+}
+
+// Unregister removes the handler of a payload type
+func (c *Client) Unregister(payloadType string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.handlers, payloadType)
+}
+
+// Send sends the message without awaiting the SSA
+func (c *Client) Send(ctx context.Context, message message_wrapper.UntypedMessage) error {
+	return c.send(ctx, c.prepare(message))
+}
+
+// Request sends the message and awaits its SSA, up to SSATimeout;
+// the SSA error, if any, is returned along with the SSA
+func (c *Client) Request(ctx context.Context, message message_wrapper.UntypedMessage) (message_wrapper.UntypedMessage, error) {
+	message = c.prepare(message)
+
+	result := make(chan ssaResult, 1)
+	c.mu.Lock()
+	c.pending[message.ID] = result
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, message.ID)
+		c.mu.Unlock()
+	}()
+
+	err := c.send(ctx, message)
+	if err != nil {
+		return message_wrapper.UntypedMessage{}, err
+	}
+
+	timer := time.NewTimer(c.config.SSATimeout.Duration())
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return message_wrapper.UntypedMessage{}, ctx.Err()
+	case <-timer.C:
+		return message_wrapper.UntypedMessage{}, errors.New("timeout awaiting SSA of %s (%s)", message.ID, message.PayloadType).
+			WithErrorCode(errors.ErrorPerformingRequestErrorCode)
+	case res := <-result:
+		if res.err != nil {
+			return res.message, res.err
+		}
+
+		if res.message.Error != nil {
+			return res.message, res.message.Error
+		}
+
+		return res.message, nil
+	}
+}
+
+// Subscribe performs the Request and, when acknowledged, keeps the message to send it again after reconnecting;
+// on resend the last history cursor received is set on the message header, so missed broadcasts can be replayed
+func (c *Client) Subscribe(ctx context.Context, message message_wrapper.UntypedMessage) (message_wrapper.UntypedMessage, error) {
+	message = c.prepare(message)
+	ssa, err := c.Request(ctx, message)
+	if err != nil {
+		return ssa, err
+	}
+
+	c.mu.Lock()
+	c.subscriptions = append(c.subscriptions, message)
+	c.mu.Unlock()
+	return ssa, nil
+}
+
+// Unsubscribe forgets the subscription made with subscriptionID and performs the Request of message, if provided
+func (c *Client) Unsubscribe(ctx context.Context, subscriptionID string, message ...message_wrapper.UntypedMessage) (message_wrapper.UntypedMessage, error) {
+	c.mu.Lock()
+	for i, subscription := range c.subscriptions {
+		if subscription.ID == subscriptionID {
+			c.subscriptions = append(c.subscriptions[:i], c.subscriptions[i+1:]...)
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if len(message) == 0 {
+		return message_wrapper.UntypedMessage{}, nil
+	}
+
+	return c.Request(ctx, message[0])
+}
+
+// HistoryCursor last models.HeaderHistoryCursor received
+func (c *Client) HistoryCursor() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.historyCursor
+}
+
+func (c *Client) prepare(message message_wrapper.UntypedMessage) message_wrapper.UntypedMessage {
+	if len(message.ID) == 0 {
+		message.ID = uid.NewUUID()
+	}
+
+	if len(message.FromSenderID) == 0 {
+		message.FromSenderID = c.config.SenderID
+	}
+
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now().UTC()
+	}
+
+	return message
+}
+
+func (c *Client) send(ctx context.Context, message message_wrapper.UntypedMessage) error {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		return errors.New("client not connected").WithErrorCode(errors.ConnectionNotActive)
+	}
+
+	blob, err := serializer.Serialize(message)
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	err = websocket.Message.Send(conn, string(blob))
+	if err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).Error("error sending %s (%s)", message.ID, message.PayloadType)
+		return errors.NewWithError(err, "error sending %s", message.ID).WithErrorCode(errors.ConnectionNotActive)
+	}
+
+	return nil
+}
+
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	wsConfig, err := websocket.NewConfig(c.config.URL, c.config.Origin)
+	if err != nil {
+		return nil, errors.NewWithError(err, "invalid websocket url %s", c.config.URL).WithErrorCode(errors.InvalidFormDataCode)
+	}
+
+	wsConfig.Header = http.Header{}
+	for key, val := range c.config.Headers {
+		wsConfig.Header.Set(key, val)
+	}
+
+	conn, err := wsConfig.DialContext(ctx)
+	if err != nil {
+		return nil, errors.NewWithError(err, "unable to connect to %s", c.config.URL).WithErrorCode(errors.ConnectionNotActive)
+	}
+
+	return conn, nil
+}
+
+// run reads conn until it fails, then reconnects
+func (c *Client) run(ctx context.Context, conn *websocket.Conn) {
+	log := pixiecontext.GetCtxLogger(ctx).With("url", c.config.URL)
+
+	for {
+		c.read(ctx, conn)
+		c.failPending(errors.New("connection lost").WithErrorCode(errors.ConnectionNotActive))
+
+		var ok bool
+		conn, ok = c.reconnect(ctx)
+		if !ok {
+			log.Debug("message_router client stopped")
+			return
+		}
+
+		log.Debug("message_router client reconnected")
+		go c.resubscribe(ctx)
+	}
+}
+
+func (c *Client) read(ctx context.Context, conn *websocket.Conn) {
+	for {
+		var blob []byte
+		err := websocket.Message.Receive(conn, &blob)
+		if err != nil {
+			if !c.stopped(ctx) {
+				pixiecontext.GetCtxLogger(ctx).With("error", err).Warn("message_router client connection lost")
+			}
+			return
+		}
+
+		c.dispatch(ctx, blob)
+	}
+}
+
+func (c *Client) reconnect(ctx context.Context) (*websocket.Conn, bool) {
+	log := pixiecontext.GetCtxLogger(ctx).With("url", c.config.URL)
+
+	for attempt := 1; c.config.MaxReconnectAttempts == 0 || attempt <= c.config.MaxReconnectAttempts; attempt++ {
+		if c.stopped(ctx) {
+			return nil, false
+		}
+
+		timer := time.NewTimer(c.config.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, false
+		case <-timer.C:
+		}
+
+		conn, err := c.dial(ctx)
+		if err != nil {
+			log.With("error", err).Warn("message_router client reconnect attempt %d failed", attempt)
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			_ = conn.Close()
+			return nil, false
+		}
+		c.conn = conn
+		c.mu.Unlock()
+		return conn, true
+	}
+
+	log.Error("message_router client gave up reconnecting after %d attempts", c.config.MaxReconnectAttempts)
+	return nil, false
+}
+
+func (c *Client) resubscribe(ctx context.Context) {
+	c.mu.RLock()
+	subscriptions := append([]message_wrapper.UntypedMessage{}, c.subscriptions...)
+	cursor := c.historyCursor
+	c.mu.RUnlock()
+
+	for _, subscription := range subscriptions {
+		headers := make(map[string]interface{}, len(subscription.Headers)+1)
+		for key, val := range subscription.Headers {
+			headers[key] = val
+		}
+		subscription.Headers = headers
+
+		if len(cursor) > 0 {
+			subscription.SetHeader(models.HeaderHistoryCursor, cursor)
+		}
+
+		_, err := c.Request(ctx, subscription)
+		if err != nil {
+			pixiecontext.GetCtxLogger(ctx).
+				With("error", err).
+				With("subscription", subscription).
+				Error("message_router client error resubscribing %s (%s)", subscription.ID, subscription.PayloadType)
+		}
+	}
+}
+
+func (c *Client) dispatch(ctx context.Context, blob []byte) {
+	log := pixiecontext.GetCtxLogger(ctx)
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.
+				With("stack_trace", debug.Stack()).
+				With("recover", r).
+				Error("recovered from panic at message router client")
+		}
+	}()
+
+	message, err := c.factory.Create(ctx, blob)
+	if err != nil {
+		// unknown payload types are handled untyped, SSAs usually aren't registered
+		err = serializer.Deserialize(blob, &message)
+		if err != nil {
+			log.With("error", err).Error("message_router client unable to decode inbound message")
+			return
+		}
+	}
+
+	cursor := message.GetHeaderString(models.HeaderHistoryCursor)
+
+	c.mu.Lock()
+	if len(cursor) > 0 {
+		c.historyCursor = cursor
+	}
+
+	var awaiting chan ssaResult
+	if requestID, ok := c.isSSA(message); ok {
+		awaiting = c.pending[requestID]
+		delete(c.pending, requestID)
+	}
+
+	handler, ok := c.handlers[message.PayloadType]
+	if !ok {
+		handler, ok = c.handlers[types.PayloadTypeFallback]
+	}
+	c.mu.Unlock()
+
+	if awaiting != nil {
+		awaiting <- ssaResult{message: message}
+		return
+	}
+
+	if !ok {
+		log.Debug("message_router client no handlers provided for %s (%s)", message.ID, message.PayloadType)
+		return
+	}
+
+	handler(ctx, message)
+}
+
+func (c *Client) failPending(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, awaiting := range c.pending {
+		awaiting <- ssaResult{err: err}
+		delete(c.pending, id)
+	}
+}
+
+func (c *Client) stopped(ctx context.Context) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.closed || ctx.Err() != nil
+}
@@ -0,0 +1,156 @@
+package message_router_client
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+
+	"github.com/pixie-sh/core-go/infra/message_factory"
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	"github.com/pixie-sh/core-go/pkg/models"
+	"github.com/pixie-sh/core-go/pkg/models/serializer"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+	"github.com/pixie-sh/core-go/pkg/types"
+)
+
+type subscribeRoom struct {
+	RoomID string `json:"room_id"`
+}
+
+type roomMessage struct {
+	Text string `json:"text"`
+}
+
+type failingRequest struct{}
+
+// testServer acknowledges every message with the same ID;
+// subscriptions are answered with a roomMessage and the first connection is dropped after it
+type testServer struct {
+	mu          sync.Mutex
+	connections int
+	received    []message_wrapper.UntypedMessage
+}
+
+func (s *testServer) handler(conn *websocket.Conn) {
+	s.mu.Lock()
+	s.connections++
+	connection := s.connections
+	s.mu.Unlock()
+
+	for {
+		var blob []byte
+		if websocket.Message.Receive(conn, &blob) != nil {
+			return
+		}
+
+		var request message_wrapper.UntypedMessage
+		if serializer.Deserialize(blob, &request) != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.received = append(s.received, request)
+		s.mu.Unlock()
+
+		ssa := message_wrapper.NewUntypedMessage(request.ID, "ssa", nil)
+		if request.PayloadType == types.PayloadTypeOf[failingRequest]().String() {
+			ssa.SetError(errors.New("request failed").WithErrorCode(errors.ErrorPerformingRequestErrorCode))
+		}
+		s.write(conn, ssa)
+
+		if request.PayloadType == types.PayloadTypeOf[subscribeRoom]().String() {
+			push := message_wrapper.NewUntypedMessage("push", types.PayloadTypeOf[roomMessage]().String(), roomMessage{Text: "hello"})
+			push.SetHeader(models.HeaderHistoryCursor, "cursor-1")
+			s.write(conn, push)
+
+			if connection == 1 {
+				_ = conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *testServer) write(conn *websocket.Conn, message message_wrapper.UntypedMessage) {
+	blob, _ := serializer.Serialize(message)
+	_ = websocket.Message.Send(conn, string(blob))
+}
+
+func (s *testServer) snapshot() []message_wrapper.UntypedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]message_wrapper.UntypedMessage{}, s.received...)
+}
+
+func newTestClient(t *testing.T) (*Client, *testServer) {
+	srv := &testServer{}
+	httpServer := httptest.NewServer(websocket.Handler(srv.handler))
+	t.Cleanup(httpServer.Close)
+
+	factory := message_factory.NewFactory()
+	message_factory.RegisterMessage[roomMessage](false, factory)
+
+	client := NewClient(context.Background(), Configuration{
+		URL:                 "ws" + strings.TrimPrefix(httpServer.URL, "http"),
+		SenderID:            "bot",
+		SSATimeout:          coretime.Duration(time.Second),
+		ReconnectMinBackoff: coretime.Duration(10 * time.Millisecond),
+		ReconnectMaxBackoff: coretime.Duration(50 * time.Millisecond),
+	}, factory)
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client, srv
+}
+
+func TestClient_RequestAwaitsSSA(t *testing.T) {
+	ctx := context.Background()
+	client, _ := newTestClient(t)
+	require.NoError(t, client.Connect(ctx))
+
+	ssa, err := client.Request(ctx, message_wrapper.NewUntypedMessage("req-1", "ping", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "req-1", ssa.ID)
+
+	ssa, err = client.Request(ctx, message_wrapper.NewUntypedMessage("req-2", types.PayloadTypeOf[failingRequest]().String(), failingRequest{}))
+	require.Error(t, err)
+	assert.Equal(t, "req-2", ssa.ID)
+}
+
+func TestClient_TypedHandlersAndResubscribe(t *testing.T) {
+	ctx := context.Background()
+	client, srv := newTestClient(t)
+
+	received := make(chan string, 2)
+	Register[roomMessage](func(ctx context.Context, message *roomMessage) error {
+		received <- message.Text
+		return nil
+	}, client)
+
+	require.NoError(t, client.Connect(ctx))
+	_, err := client.Subscribe(ctx, message_wrapper.NewUntypedMessage("sub-1", types.PayloadTypeOf[subscribeRoom]().String(), subscribeRoom{RoomID: "1"}))
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case text := <-received:
+			assert.Equal(t, "hello", text)
+		case <-time.After(2 * time.Second):
+			t.Fatal("typed handler not called")
+		}
+	}
+
+	requests := srv.snapshot()
+	require.Len(t, requests, 2)
+	assert.Equal(t, "sub-1", requests[1].ID)
+	assert.Equal(t, "bot", requests[1].FromSenderID)
+	assert.Equal(t, "cursor-1", requests[1].GetHeaderString(models.HeaderHistoryCursor))
+	assert.Equal(t, "cursor-1", client.HistoryCursor())
+}
@@ -0,0 +1,58 @@
+package message_router_client
+
+import (
+	"time"
+
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+// Configuration message_router websocket client config
+type Configuration struct {
+	URL     string            `json:"url"`     // ws:// or wss:// endpoint
+	Origin  string            `json:"origin"`  // origin header; URL is used if empty
+	Headers map[string]string `json:"headers"` // extra handshake headers, eg: Authorization
+
+	SenderID   string            `json:"sender_id"`   // FromSenderID set on sent messages without one
+	SSATimeout coretime.Duration `json:"ssa_timeout"` // max wait for a request SSA
+
+	ReconnectMinBackoff  coretime.Duration `json:"reconnect_min_backoff"`
+	ReconnectMaxBackoff  coretime.Duration `json:"reconnect_max_backoff"`
+	MaxReconnectAttempts int               `json:"max_reconnect_attempts"` // 0 retries forever
+}
+
+func (c Configuration) withDefaults() Configuration {
+	if len(c.Origin) == 0 {
+		c.Origin = c.URL
+	}
+
+	if c.SSATimeout <= 0 {
+		c.SSATimeout = coretime.Duration(10 * time.Second)
+	}
+
+	if c.ReconnectMinBackoff <= 0 {
+		c.ReconnectMinBackoff = coretime.Duration(100 * time.Millisecond)
+	}
+
+	if c.ReconnectMaxBackoff < c.ReconnectMinBackoff {
+		c.ReconnectMaxBackoff = coretime.Duration(10 * time.Second)
+		if c.ReconnectMaxBackoff < c.ReconnectMinBackoff {
+			c.ReconnectMaxBackoff = c.ReconnectMinBackoff
+		}
+	}
+
+	return c
+}
+
+// backoff exponential backoff for the given attempt, capped at ReconnectMaxBackoff
+func (c Configuration) backoff(attempt int) time.Duration {
+	wait := c.ReconnectMinBackoff.Duration()
+	for i := 1; i < attempt && wait < c.ReconnectMaxBackoff.Duration(); i++ {
+		wait *= 2
+	}
+
+	if wait > c.ReconnectMaxBackoff.Duration() {
+		wait = c.ReconnectMaxBackoff.Duration()
+	}
+
+	return wait
+}
@@ -0,0 +1,44 @@
+package message_router_client
+
+import (
+	"context"
+
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	"github.com/pixie-sh/core-go/pkg/models/serializer"
+	"github.com/pixie-sh/core-go/pkg/types"
+)
+
+// Register mirrors message_router.Register for inbound messages of type M;
+// payloads not decoded by the factory are converted to M
+func Register[M any](handler func(context.Context, *M) error, client *Client) {
+	pt := types.PayloadTypeOf[M]()
+	client.Register(pt.String(), func(ctx context.Context, message message_wrapper.UntypedMessage) {
+		log := pixiecontext.GetCtxLogger(ctx).With("event_id", message.ID)
+
+		typedData, ok := message.Payload.(M)
+		if !ok {
+			var err error
+			typedData, err = serializer.FromAny[M](message.Payload, false)
+			if err != nil {
+				log.With("error", err).Error("unable to convert '%s' payload to '%s'", message.ID, pt.String())
+				return
+			}
+		}
+
+		err := handler(ctx, &typedData)
+		if err != nil {
+			log.With("error", err).Log("message processed with error")
+		}
+	})
+}
+
+// RegisterFallback mirrors message_router.RegisterFallback, handles payload types without handler
+func RegisterFallback(handler func(context.Context, *message_wrapper.UntypedMessage) error, client *Client) {
+	client.Register(types.PayloadTypeFallback, func(ctx context.Context, message message_wrapper.UntypedMessage) {
+		err := handler(ctx, &message)
+		if err != nil {
+			pixiecontext.GetCtxLogger(ctx).With("event_id", message.ID).With("error", err).Log("message processed with error")
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package message_router_repositories
+
+import "time"
+
+type BroadcastHistory struct {
+	ID        uint64    `gorm:"primaryKey;autoIncrement"`
+	ChannelID string    `gorm:"type:text;index:idx_broadcast_history_channel_id_id,priority:1"`
+	MessageID string    `gorm:"type:text"`
+	Blob      string    `gorm:"type:jsonb"`
+	CreatedAt time.Time `gorm:"index"`
+} //@name BroadcastHistory
+
+func (BroadcastHistory) TableName() string {
+	return "broadcast_history"
+}
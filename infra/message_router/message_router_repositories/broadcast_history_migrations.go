@@ -0,0 +1,28 @@
+package message_router_repositories
+
+import (
+	"github.com/pixie-sh/database-helpers-go/database"
+	"gorm.io/gorm"
+)
+
+var CreateBroadcastHistoryTable1792359817927 = database.Migration{
+	ID: "1792359817927_CreateBroadcastHistoryTable",
+	Migrate: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            CREATE TABLE IF NOT EXISTS broadcast_history (
+                id BIGSERIAL PRIMARY KEY,
+                channel_id TEXT NOT NULL,
+                message_id TEXT NOT NULL,
+                blob JSONB NOT NULL,
+                created_at TIMESTAMP WITH TIME ZONE NOT NULL
+            );
+            CREATE INDEX IF NOT EXISTS idx_broadcast_history_channel_id_id ON broadcast_history(channel_id, id);
+            CREATE INDEX IF NOT EXISTS idx_broadcast_history_created_at ON broadcast_history(created_at);
+        `).Error
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            DROP TABLE IF EXISTS broadcast_history;
+        `).Error
+	},
+}
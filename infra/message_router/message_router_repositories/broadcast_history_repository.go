@@ -0,0 +1,136 @@
+package message_router_repositories
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pixie-sh/database-helpers-go/database"
+
+	"github.com/pixie-sh/core-go/infra/message_router"
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	"github.com/pixie-sh/core-go/pkg/models/serializer"
+	"github.com/pixie-sh/core-go/pkg/types"
+)
+
+type BroadcastHistoryRepository struct {
+	database.Repository[BroadcastHistoryRepository]
+}
+
+func NewBroadcastHistoryRepository(db *database.DB) BroadcastHistoryRepository {
+	return BroadcastHistoryRepository{database.NewRepository(db, NewBroadcastHistoryRepository)}
+}
+
+// Append implements message_router.HistoryStore
+// rows beyond retention are pruned on the same transaction
+func (r BroadcastHistoryRepository) Append(
+	_ context.Context,
+	channelID string,
+	retention message_router.HistoryRetention,
+	messages ...message_wrapper.UntypedMessage,
+) ([]message_router.HistoryEntry, error) {
+	now := time.Now().UTC()
+	rows := make([]BroadcastHistory, len(messages))
+	for i, message := range messages {
+		blob, err := serializer.Serialize(message)
+		if err != nil {
+			return nil, err
+		}
+
+		rows[i] = BroadcastHistory{
+			ChannelID: channelID,
+			MessageID: message.ID,
+			Blob:      types.UnsafeString(blob),
+			CreatedAt: now,
+		}
+	}
+
+	err := r.Transaction(func(tx *database.DB) error {
+		err := tx.Model(&BroadcastHistory{}).Create(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		return r.WithTx(tx).prune(channelID, retention, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]message_router.HistoryEntry, len(rows))
+	for i, row := range rows {
+		entries[i] = message_router.NewHistoryEntry(strconv.FormatUint(row.ID, 10), messages[i])
+	}
+
+	return entries, nil
+}
+
+// Since implements message_router.HistoryStore
+func (r BroadcastHistoryRepository) Since(_ context.Context, channelID string, cursor string, limit int) ([]message_router.HistoryEntry, error) {
+	query := r.DB.Model(&BroadcastHistory{}).
+		Where("channel_id = ?", channelID).
+		Order("id ASC")
+
+	if len(cursor) > 0 {
+		after, err := types.ParseUint64WithError(cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		query = query.Where("id > ?", after)
+	}
+
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+
+	var rows []BroadcastHistory
+	err := query.Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]message_router.HistoryEntry, 0, len(rows))
+	for _, row := range rows {
+		var message message_wrapper.UntypedMessage
+		err = serializer.Deserialize(types.UnsafeBytes(row.Blob), &message, false)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, message_router.NewHistoryEntry(strconv.FormatUint(row.ID, 10), message))
+	}
+
+	return entries, nil
+}
+
+// DeleteOlderThan removes history of all channels created before the provided time
+// channels without new messages are not pruned by Append, use it on a scheduled job
+func (r BroadcastHistoryRepository) DeleteOlderThan(before time.Time) (int64, error) {
+	result := r.DB.Where("created_at < ?", before).Delete(&BroadcastHistory{})
+	return result.RowsAffected, result.Error
+}
+
+func (r BroadcastHistoryRepository) prune(channelID string, retention message_router.HistoryRetention, now time.Time) error {
+	if retention.MaxMessages > 0 {
+		err := r.DB.Exec(`
+			DELETE FROM broadcast_history
+			WHERE channel_id = ? AND id <= (
+				SELECT id FROM broadcast_history
+				WHERE channel_id = ?
+				ORDER BY id DESC
+				OFFSET ? LIMIT 1
+			)`, channelID, channelID, retention.MaxMessages).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if retention.MaxAge > 0 {
+		return r.DB.
+			Where("channel_id = ? AND created_at < ?", channelID, now.Add(-retention.MaxAge.Duration())).
+			Delete(&BroadcastHistory{}).Error
+	}
+
+	return nil
+}
@@ -25,7 +25,12 @@ type innerSub struct {
 }
 
 func (sub *innerSub) Publish(msg message_wrapper.UntypedMessage) {
-	sub.router.listen(sub.connection, msg)
+	_ = sub.router.listen(sub.connection, msg)
+}
+
+// route same as Publish returning the routing error, see Bridge
+func (sub *innerSub) route(msg message_wrapper.UntypedMessage) error {
+	return sub.router.listen(sub.connection, msg)
 }
 
 func (sub *innerSub) ID() string {
@@ -138,6 +143,9 @@ func (r *Router) subscriberHandler(src SourceSubscription) {
 	})
 }
 
+// errNoHandlers returned by listen for requests whose payload type has no handlers, see Bridge.handle
+var errNoHandlers = errors.New("no handlers provided").WithErrorCode(errors.ErrorPerformingRequestErrorCode)
+
 func (r *Router) routing(ctx *RouterContext) {
 	request := ctx.Request
 
@@ -149,7 +157,9 @@ func (r *Router) routing(ctx *RouterContext) {
 	if !ok {
 		handlers, ok = r.handlers[types.PayloadTypeFallback]
 		if !ok {
-			ssa := r.createSSA(*request, errors.New("no handlers provided").WithErrorCode(errors.ErrorPerformingRequestErrorCode))
+			ctx.Error = errors.New("no handlers provided").WithErrorCode(errors.ErrorPerformingRequestErrorCode)
+			ctx.unhandled = true
+			ssa := r.createSSA(*request, ctx.Error)
 
 			logger.Logger.
 				With("request", request).
@@ -195,7 +205,8 @@ func (r *Router) processResponses(connection SourceConnection, responses []messa
 	}
 }
 
-func (r *Router) listen(connection SourceConnection, request message_wrapper.UntypedMessage) {
+// listen routes the request from connection, returning the routing error
+func (r *Router) listen(connection SourceConnection, request message_wrapper.UntypedMessage) (err error) {
 	log := logger.Logger.With(logger.TraceID, request.ID)
 
 	defer func() {
@@ -204,6 +215,8 @@ func (r *Router) listen(connection SourceConnection, request message_wrapper.Unt
 				With("stack_trace", debug.Stack()).
 				With("recover", r).
 				Error("recovered from panic at message router for %s", request.PayloadType)
+
+			err = errors.New("panic routing %s: %v", request.PayloadType, r).WithErrorCode(errors.ErrorPerformingRequestErrorCode)
 		}
 	}()
 
@@ -225,6 +238,14 @@ func (r *Router) listen(connection SourceConnection, request message_wrapper.Unt
 			go r.notificationsBroadcast.BroadcastCtx(routerContext.NotificationsCtx)
 		}
 	}
+
+	if routerContext.unhandled {
+		return errNoHandlers
+	}
+	if routerContext.Error != nil {
+		return routerContext.Error
+	}
+	return nil
 }
 
 // Handle the routing logic for SourceConnection do not apply here
@@ -37,6 +37,8 @@ type RouterContext struct {
 	// no response nor broadcast are sent
 	Error  errors.E
 	Logger logger.Interface
+
+	unhandled bool // no handlers for the request payload type
 }
 
 func NewRouterContext(ctx context.Context) *RouterContext {
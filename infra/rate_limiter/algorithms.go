@@ -0,0 +1,121 @@
+package rate_limiter
+
+// Algorithm rate limiting algorithm of the Limiter
+type Algorithm = string
+
+const (
+	// AlgorithmFixedWindow at most limit requests per window, the window starting on its first request
+	AlgorithmFixedWindow Algorithm = "fixed_window"
+	// AlgorithmSlidingWindowLog at most limit requests in any window, exact; keeps a timestamp per request
+	AlgorithmSlidingWindowLog Algorithm = "sliding_window_log"
+	// AlgorithmSlidingWindowCounter sliding window approximated by weighting the previous fixed window
+	AlgorithmSlidingWindowCounter Algorithm = "sliding_window_counter"
+	// AlgorithmTokenBucket bucket of burst tokens refilled at limit per window
+	AlgorithmTokenBucket Algorithm = "token_bucket"
+	// AlgorithmGCRA generic cell rate algorithm; token bucket semantics with a single timestamp per key
+	AlgorithmGCRA Algorithm = "gcra"
+)
+
+// scripts receive KEYS[1] the limited key and ARGV limit, window in ms, burst and a unique request ID;
+// they reply {allowed, remaining, reset in ms, retry after in ms}. Time is read from the redis
+// server so every replica shares the same clock
+const scriptNow = `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+`
+
+var algorithmScripts = map[Algorithm]string{
+	AlgorithmFixedWindow: scriptNow + `
+local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+    ttl = window
+end
+if count >= limit then
+    return {0, 0, ttl, ttl}
+end
+count = redis.call('INCR', KEYS[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+    redis.call('PEXPIRE', KEYS[1], window)
+end
+return {1, limit - count, ttl, 0}
+`,
+
+	AlgorithmSlidingWindowLog: scriptNow + `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count >= limit then
+    local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+    local retry = tonumber(oldest[2]) + window - now
+    local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+    return {0, 0, tonumber(newest[2]) + window - now, retry}
+end
+redis.call('ZADD', KEYS[1], now, ARGV[4])
+redis.call('PEXPIRE', KEYS[1], window)
+return {1, limit - count - 1, window, 0}
+`,
+
+	AlgorithmSlidingWindowCounter: scriptNow + `
+local current_window = math.floor(now / window)
+local elapsed = now - current_window * window
+local data = redis.call('HMGET', KEYS[1], 'window', 'current', 'previous')
+local stored_window = tonumber(data[1])
+local current = tonumber(data[2]) or 0
+local previous = tonumber(data[3]) or 0
+if stored_window == current_window - 1 then
+    previous = current
+    current = 0
+elseif stored_window ~= current_window then
+    previous = 0
+    current = 0
+end
+local estimated = previous * (window - elapsed) / window + current
+if estimated + 1 > limit then
+    local retry
+    if current + 1 <= limit then
+        retry = math.ceil(window - (limit - current - 1) * window / previous - elapsed)
+    else
+        retry = math.ceil(2 * window - (limit - 1) * window / current - elapsed)
+    end
+    return {0, 0, window - elapsed, retry}
+end
+redis.call('HSET', KEYS[1], 'window', current_window, 'current', current + 1, 'previous', previous)
+redis.call('PEXPIRE', KEYS[1], 2 * window)
+return {1, math.floor(limit - estimated - 1), window - elapsed, 0}
+`,
+
+	AlgorithmTokenBucket: scriptNow + `
+local refill = limit / window
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(data[1]) or burst
+local at = tonumber(data[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * refill)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+    allowed = 1
+    tokens = tokens - 1
+else
+    retry = math.ceil((1 - tokens) / refill)
+end
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.6f', tokens), 'at', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / refill))
+return {allowed, math.floor(tokens), math.ceil((burst - tokens) / refill), retry}
+`,
+
+	AlgorithmGCRA: scriptNow + `
+local emission = window / limit
+local tolerance = emission * burst
+local tat = math.max(tonumber(redis.call('GET', KEYS[1]) or now), now)
+local next_tat = tat + emission
+local allow_at = next_tat - tolerance
+if now < allow_at then
+    return {0, 0, math.ceil(tat - now), math.ceil(allow_at - now)}
+end
+redis.call('SET', KEYS[1], string.format('%.3f', next_tat), 'PX', math.ceil(next_tat - now))
+return {1, math.floor((now - allow_at) / emission), math.ceil(next_tat - now), 0}
+`,
+}
@@ -27,3 +27,13 @@ type ILimitRateLimiter interface {
 	GetLimit() int
 	GetWindowSize() time.Duration
 }
+
+type ILimiter interface {
+	Allow(ctx context.Context, key string, withLimit ...Limit) (Result, error)
+}
+
+type ISemaphore interface {
+	TryAcquire(ctx context.Context, key string, withLimit ...int) (*Lease, bool, error)
+	Acquire(ctx context.Context, key string, withLimit ...int) (*Lease, error)
+	Do(ctx context.Context, key string, fn func(ctx context.Context) error, withLimit ...int) error
+}
@@ -0,0 +1,185 @@
+package rate_limiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	"github.com/pixie-sh/core-go/pkg/uid"
+)
+
+// Limit requests allowed per window; Burst is the capacity of the token bucket and gcra algorithms, Limit if not set
+type Limit struct {
+	Limit  int
+	Window time.Duration
+	Burst  int
+}
+
+// Result of a limited request; ResetAfter is the time until the quota is restored, RetryAfter
+// the time until the next request is allowed, zero if allowed
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+// Headers RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset, plus Retry-After if not allowed; times in seconds, rounded up
+func (r Result) Headers() map[string]string {
+	headers := map[string]string{
+		HeaderRateLimitLimit:     strconv.Itoa(r.Limit),
+		HeaderRateLimitRemaining: strconv.Itoa(r.Remaining),
+		HeaderRateLimitReset:     strconv.FormatInt(ceilSeconds(r.ResetAfter), 10),
+	}
+	if !r.Allowed {
+		headers[HeaderRetryAfter] = strconv.FormatInt(ceilSeconds(r.RetryAfter), 10)
+	}
+	return headers
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+// Limiter atomic rate limiter running the configured algorithm as a Lua script
+type Limiter struct {
+	cache  cache.ScriptCache
+	script string
+	limit  Limit
+}
+
+// NewLimiter limiter of config.Algorithm, fixed window if not set
+func NewLimiter(_ context.Context, config RateLimiterConfiguration, scriptCache cache.ScriptCache) (*Limiter, error) {
+	algorithm := config.Algorithm
+	if len(algorithm) == 0 {
+		algorithm = AlgorithmFixedWindow
+	}
+
+	script, ok := algorithmScripts[algorithm]
+	if !ok {
+		return nil, errors.New("unknown rate limiter algorithm '%s'", algorithm).WithErrorCode(errors.InvalidFormDataCode)
+	}
+
+	window, err := time.ParseDuration(config.WindowSizeDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := Limit{Limit: config.Limit, Window: window, Burst: config.Burst}
+	err = limit.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Limiter{
+		cache:  scriptCache,
+		script: script,
+		limit:  limit,
+	}, nil
+}
+
+// Allow consumes a request of key if allowed; withLimit overrides the configured limit
+func (l *Limiter) Allow(ctx context.Context, key string, withLimit ...Limit) (Result, error) {
+	limit := l.limit
+	if len(withLimit) > 0 {
+		limit = withLimit[0]
+		err := limit.validate()
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Limit
+	}
+
+	reply, err := l.cache.Eval(ctx, l.script, []string{key},
+		limit.Limit,
+		limit.Window.Milliseconds(),
+		burst,
+		uid.NewUUID(),
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 4 {
+		return Result{}, errors.New("unexpected rate limiter reply %v", reply)
+	}
+
+	numbers := make([]int64, len(values))
+	for i, value := range values {
+		numbers[i], err = replyInt64(value)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	return Result{
+		Allowed:    numbers[0] == 1,
+		Limit:      limit.Limit,
+		Remaining:  int(max(numbers[1], 0)),
+		ResetAfter: time.Duration(max(numbers[2], 0)) * time.Millisecond,
+		RetryAfter: time.Duration(max(numbers[3], 0)) * time.Millisecond,
+	}, nil
+}
+
+// incrementScript KEYS[1] counter, ARGV window in ms; expires the counter on its first occurrence only
+const incrementScript = `
+local count = redis.call('INCR', KEYS[1])
+if count == 1 or redis.call('PTTL', KEYS[1]) < 0 then
+    redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`
+
+// Increment occurrences of key atomically, the counter expiring window after its first occurrence
+func (l *Limiter) Increment(ctx context.Context, key string, window time.Duration) (int, error) {
+	reply, err := l.cache.Eval(ctx, incrementScript, []string{key}, max(window.Milliseconds(), 1))
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := replyInt64(reply)
+	if err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// GetLimit configured limit
+func (l *Limiter) GetLimit() Limit {
+	return l.limit
+}
+
+func (l Limit) validate() error {
+	if l.Limit <= 0 || l.Window < time.Millisecond {
+		return errors.New("rate limit must be positive per window of at least 1ms, got %d per %s", l.Limit, l.Window).
+			WithErrorCode(errors.InvalidFormDataCode)
+	}
+	return nil
+}
+
+func replyInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	default:
+		return 0, errors.New("unexpected rate limiter reply value %v", value)
+	}
+}
@@ -0,0 +1,217 @@
+package rate_limiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+)
+
+type testClock struct {
+	mr  *miniredis.Miniredis
+	now time.Time
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.mr.SetTime(c.now)
+	c.mr.FastForward(d)
+}
+
+func setupTestLimiter(t *testing.T, algorithm Algorithm, limit int, window string, burst int) (*Limiter, *cache.RedisCache, *testClock) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	clock := &testClock{mr: mr, now: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)}
+	mr.SetTime(clock.now)
+
+	redisCache, err := cache.NewRedisCache(context.Background(), cache.RedisCacheConfiguration{Address: mr.Addr()})
+	require.NoError(t, err)
+
+	limiter, err := NewLimiter(context.Background(), RateLimiterConfiguration{
+		Limit:              limit,
+		WindowSizeDuration: window,
+		Algorithm:          algorithm,
+		Burst:              burst,
+	}, redisCache)
+	require.NoError(t, err)
+
+	return limiter, redisCache, clock
+}
+
+func allowN(t *testing.T, limiter *Limiter, key string, n int) []Result {
+	results := make([]Result, n)
+	for i := range results {
+		var err error
+		results[i], err = limiter.Allow(context.Background(), key)
+		require.NoError(t, err)
+	}
+	return results
+}
+
+func TestLimiter_Algorithms(t *testing.T) {
+	for _, algorithm := range []Algorithm{
+		AlgorithmFixedWindow,
+		AlgorithmSlidingWindowLog,
+		AlgorithmSlidingWindowCounter,
+		AlgorithmTokenBucket,
+		AlgorithmGCRA,
+	} {
+		t.Run(algorithm, func(t *testing.T) {
+			limiter, _, clock := setupTestLimiter(t, algorithm, 3, "1m", 0)
+
+			results := allowN(t, limiter, "user", 4)
+			for i, result := range results[:3] {
+				assert.True(t, result.Allowed, "request %d", i)
+				assert.Equal(t, 2-i, result.Remaining, "request %d", i)
+				assert.Equal(t, 3, result.Limit)
+				assert.Zero(t, result.RetryAfter)
+			}
+			assert.False(t, results[3].Allowed)
+			assert.Zero(t, results[3].Remaining)
+			assert.Positive(t, results[3].RetryAfter)
+			assert.LessOrEqual(t, results[3].RetryAfter, 2*time.Minute)
+
+			other := allowN(t, limiter, "other", 1)
+			assert.True(t, other[0].Allowed)
+
+			clock.advance(results[3].RetryAfter)
+			assert.True(t, allowN(t, limiter, "user", 1)[0].Allowed)
+		})
+	}
+}
+
+func TestLimiter_FixedWindowKeepsWindow(t *testing.T) {
+	limiter, _, clock := setupTestLimiter(t, AlgorithmFixedWindow, 2, "1m", 0)
+
+	allowN(t, limiter, "user", 1)
+	clock.advance(40 * time.Second)
+	results := allowN(t, limiter, "user", 2)
+	assert.True(t, results[0].Allowed)
+	assert.False(t, results[1].Allowed)
+	assert.Equal(t, 20*time.Second, results[1].RetryAfter)
+
+	clock.advance(20 * time.Second)
+	assert.True(t, allowN(t, limiter, "user", 1)[0].Allowed)
+}
+
+func TestLimiter_TokenBucketRefills(t *testing.T) {
+	limiter, _, clock := setupTestLimiter(t, AlgorithmTokenBucket, 1, "1s", 5)
+
+	results := allowN(t, limiter, "user", 6)
+	assert.True(t, results[4].Allowed)
+	assert.False(t, results[5].Allowed)
+	assert.Equal(t, time.Second, results[5].RetryAfter)
+
+	clock.advance(2 * time.Second)
+	results = allowN(t, limiter, "user", 3)
+	assert.True(t, results[0].Allowed)
+	assert.True(t, results[1].Allowed)
+	assert.False(t, results[2].Allowed)
+}
+
+func TestLimiter_LimitOverride(t *testing.T) {
+	limiter, _, _ := setupTestLimiter(t, AlgorithmGCRA, 10, "1m", 0)
+
+	result, err := limiter.Allow(context.Background(), "user", Limit{Limit: 1, Window: time.Hour})
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Limit)
+
+	result, err = limiter.Allow(context.Background(), "user", Limit{Limit: 1, Window: time.Hour})
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	_, err = limiter.Allow(context.Background(), "user", Limit{})
+	assert.Error(t, err)
+}
+
+func TestRateLimiter_AllowRequestWithAlgorithm(t *testing.T) {
+	_, redisCache, _ := setupTestLimiter(t, AlgorithmFixedWindow, 1, "1m", 0)
+
+	rl, err := NewRateLimiter(redisCache, RateLimiterConfiguration{Limit: 2, WindowSizeDuration: "1m", Algorithm: AlgorithmSlidingWindowLog})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	assert.True(t, rl.AllowRequest(ctx, "user", "export"))
+	assert.True(t, rl.AllowRequest(ctx, "user", "export"))
+	assert.False(t, rl.AllowRequest(ctx, "user", "export"))
+
+	_, err = NewRateLimiter(redisCache, RateLimiterConfiguration{Limit: 2, WindowSizeDuration: "1m", Algorithm: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestRateLimiter_DefaultsToFixedWindow(t *testing.T) {
+	_, redisCache, clock := setupTestLimiter(t, AlgorithmFixedWindow, 1, "1m", 0)
+
+	rl, err := NewRateLimiter(redisCache, RateLimiterConfiguration{Limit: 5, WindowSizeDuration: "1m"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rl.AllowRequest(ctx, "user", "export") {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(5), allowed.Load())
+
+	clock.advance(time.Minute)
+	assert.True(t, rl.AllowRequest(ctx, "user", "export"))
+}
+
+func TestRateLimiter_Increment(t *testing.T) {
+	_, redisCache, clock := setupTestLimiter(t, AlgorithmFixedWindow, 1, "1m", 0)
+
+	rl, err := NewRateLimiter(redisCache, RateLimiterConfiguration{Limit: 5, WindowSizeDuration: "1m"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := rl.Increment(ctx, "login:user")
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	count, err := rl.Increment(ctx, "login:user")
+	require.NoError(t, err)
+	assert.Equal(t, 21, count)
+
+	allowed, retryAfter := rl.Try(ctx, "login:user")
+	assert.False(t, allowed)
+	assert.Equal(t, time.Minute, retryAfter)
+
+	// the window isn't extended by later occurrences
+	clock.advance(30 * time.Second)
+	_, err = rl.Increment(ctx, "login:user")
+	require.NoError(t, err)
+	ttl, err := redisCache.TTL(ctx, "login:user")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, ttl)
+
+	count, err = rl.Increment(ctx, "signup:user", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	clock.advance(time.Second)
+	allowed, _ = rl.Try(ctx, "signup:user")
+	assert.True(t, allowed)
+}
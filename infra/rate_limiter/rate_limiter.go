@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/pixie-sh/errors-go"
+
 	"github.com/pixie-sh/core-go/pkg/types"
 
 	"github.com/pixie-sh/core-go/infra/cache"
@@ -14,32 +16,65 @@ import (
 )
 
 type RateLimiterConfiguration struct {
-	Limit              int    `json:"limit"`
-	WindowSizeDuration string `json:"window_size_duration"`
+	Limit              int       `json:"limit"`
+	WindowSizeDuration string    `json:"window_size_duration"`
+	Algorithm          Algorithm `json:"algorithm"` // atomic algorithm of AllowRequest, requires a cache.ScriptCache; fixed window if not set
+	Burst              int       `json:"burst"`     // token_bucket and gcra capacity; limit if not set
 }
 
 type RateLimiter struct {
 	cache      ICache
 	limit      int
 	windowSize time.Duration
+	limiter    *Limiter
 }
 
-func NewRateLimiter(cache ICache, config RateLimiterConfiguration) (*RateLimiter, error) {
+func NewRateLimiter(rateCache ICache, config RateLimiterConfiguration) (*RateLimiter, error) {
 	dur, err := time.ParseDuration(config.WindowSizeDuration)
 	if err != nil {
 		return nil, err
 	}
 
-	return &RateLimiter{
-		cache:      cache,
+	rl := &RateLimiter{
+		cache:      rateCache,
 		limit:      config.Limit,
 		windowSize: dur,
-	}, nil
+	}
+
+	scriptCache, ok := rateCache.(cache.ScriptCache)
+	if !ok && len(config.Algorithm) > 0 {
+		return nil, errors.New("rate limiter algorithm '%s' requires a script cache", config.Algorithm)
+	}
+
+	if ok {
+		rl.limiter, err = NewLimiter(context.Background(), config, scriptCache)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rl, nil
 }
 
-// AllowRequest check and increments occurrences if allowed
+// AllowRequest check and increments occurrences if allowed; customTTL overrides the window.
+// The check and increment are atomic unless the cache doesn't run scripts
 func (rl *RateLimiter) AllowRequest(ctx context.Context, uuid string, requestType string, customTTL ...time.Duration) bool {
 	key := fmt.Sprintf("%s:%s", uuid, requestType)
+	if rl.limiter != nil {
+		limit := rl.limiter.GetLimit()
+		if len(customTTL) > 0 {
+			limit.Window = customTTL[0]
+		}
+
+		result, err := rl.limiter.Allow(ctx, key, limit)
+		if err != nil {
+			logger.Logger.Warn("error allowing request; %s", err)
+			return false
+		}
+
+		return result.Allowed
+	}
+
 	count, err := rl.getCount(ctx, key)
 	if err != nil {
 		logger.Logger.Warn("error get count from cache; %s", err)
@@ -60,6 +95,20 @@ func (rl *RateLimiter) AllowRequest(ctx context.Context, uuid string, requestTyp
 
 // Increment Occurrences of pair: uuid+requestType
 func (rl *RateLimiter) Increment(ctx context.Context, key string, customTTL ...time.Duration) (int, error) {
+	if rl.limiter != nil {
+		window := rl.windowSize
+		if len(customTTL) > 0 {
+			window = customTTL[0]
+		}
+
+		count, err := rl.limiter.Increment(ctx, key, window)
+		if err != nil {
+			logger.Logger.Warn("error increment from cache; %s", err)
+			return -1, err
+		}
+		return count, nil
+	}
+
 	err := rl.incrementCount(ctx, key, customTTL...)
 	if err != nil {
 		logger.Logger.Warn("error get count from cache; %s", err)
@@ -144,6 +193,7 @@ func (rl *RateLimiter) getCount(ctx context.Context, key string) (int, error) {
 	return strconv.Atoi(string(value))
 }
 
+// incrementCount get and set counter of caches not running scripts, not atomic
 func (rl *RateLimiter) incrementCount(ctx context.Context, key string, customTTL ...time.Duration) error {
 	ttl := rl.windowSize
 	if len(customTTL) > 0 {
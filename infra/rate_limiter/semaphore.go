@@ -0,0 +1,264 @@
+package rate_limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+	"github.com/pixie-sh/core-go/pkg/uid"
+)
+
+const (
+	defaultSemaphoreLeaseTTL   = 30 * time.Second
+	defaultSemaphoreRetryDelay = 50 * time.Millisecond
+)
+
+// semaphore leases are the members of a sorted set scored by their expiration in ms; expired leases are
+// dropped before counting so leases of crashed holders free their slot once expired
+const semaphoreScriptNow = `
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+`
+
+// semaphoreExpireKey expires the set with its last lease
+const semaphoreExpireKey = `
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if newest[2] then
+    redis.call('PEXPIREAT', KEYS[1], tonumber(newest[2]))
+end
+`
+
+// semaphoreAcquireScript ARGV limit, lease ttl in ms and lease id; replies {acquired, retry after in ms}
+var semaphoreAcquireScript = cache.NewScript(semaphoreScriptNow + `
+local limit = tonumber(ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= limit then
+    local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+    return {0, tonumber(oldest[2]) - now}
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[3])
+` + semaphoreExpireKey + `
+return {1, 0}
+`)
+
+// semaphoreRefreshScript ARGV lease ttl in ms and lease id; replies 0 when the lease expired
+var semaphoreRefreshScript = cache.NewScript(semaphoreScriptNow + `
+if not redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+    return 0
+end
+redis.call('ZADD', KEYS[1], 'XX', now + tonumber(ARGV[1]), ARGV[2])
+` + semaphoreExpireKey + `
+return 1
+`)
+
+var semaphoreReleaseScript = cache.NewScript(`
+return redis.call('ZREM', KEYS[1], ARGV[1])
+`)
+
+type SemaphoreConfiguration struct {
+	Limit      int               `json:"limit"`       // leases held at once per key
+	LeaseTTL   coretime.Duration `json:"lease_ttl"`   // leases not released nor refreshed expire after it, e.g. of crashed holders; 30s if not set
+	MaxWait    coretime.Duration `json:"max_wait"`    // Acquire gives up after it; bound by ctx only if not set
+	RetryDelay coretime.Duration `json:"retry_delay"` // Acquire polling interval while full; 50ms if not set
+}
+
+// Semaphore distributed counting semaphore capping the concurrent holders of a key, e.g. exports per tenant
+// or calls to a partner API, across the replicas sharing the cache
+type Semaphore struct {
+	cache  cache.ScriptingCache
+	config SemaphoreConfiguration
+}
+
+// Lease slot of a semaphore key held until released or expired
+type Lease struct {
+	semaphore *Semaphore
+	key       string
+	id        string
+
+	mu        sync.Mutex
+	expiresAt time.Time // by the local clock, from the start of the last acquire or refresh
+}
+
+func NewSemaphore(_ context.Context, config SemaphoreConfiguration, scriptCache cache.ScriptingCache) (*Semaphore, error) {
+	if config.Limit <= 0 {
+		return nil, errors.New("semaphore limit must be positive, got %d", config.Limit).WithErrorCode(errors.InvalidFormDataCode)
+	}
+	if config.LeaseTTL <= 0 {
+		config.LeaseTTL = coretime.Duration(defaultSemaphoreLeaseTTL)
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = coretime.Duration(defaultSemaphoreRetryDelay)
+	}
+
+	return &Semaphore{
+		cache:  scriptCache,
+		config: config,
+	}, nil
+}
+
+// TryAcquire a lease of key if there's a free slot; withLimit overrides the configured limit
+func (s *Semaphore) TryAcquire(ctx context.Context, key string, withLimit ...int) (*Lease, bool, error) {
+	lease, _, err := s.tryAcquire(ctx, key, withLimit...)
+	return lease, lease != nil, err
+}
+
+// Acquire a lease of key waiting for a free slot up to MaxWait or until ctx is done;
+// RateLimitExceededErrorCode if none frees up within MaxWait, the ctx error when done before
+func (s *Semaphore) Acquire(ctx context.Context, key string, withLimit ...int) (*Lease, error) {
+	waitCtx := ctx
+	if s.config.MaxWait > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, s.config.MaxWait.Duration())
+		defer cancel()
+	}
+
+	for {
+		lease, retryAfter, err := s.tryAcquire(waitCtx, key, withLimit...)
+		if lease != nil {
+			return lease, nil
+		}
+		if waitCtx.Err() != nil {
+			return nil, s.waitEnded(ctx, waitCtx, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-waitCtx.Done():
+			return nil, s.waitEnded(ctx, waitCtx, key)
+		case <-time.After(min(s.config.RetryDelay.Duration(), max(retryAfter, time.Millisecond))):
+		}
+	}
+}
+
+// waitEnded ctx error when the caller is done, RateLimitExceededErrorCode when MaxWait expired
+func (s *Semaphore) waitEnded(ctx context.Context, waitCtx context.Context, key string) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return errors.NewWithError(waitCtx.Err(), "concurrency limit of %s reached", key).
+		WithErrorCode(pixieErrors.RateLimitExceededErrorCode)
+}
+
+// Do runs fn holding a lease of key, refreshed while fn runs. When the lease can't be refreshed before
+// expiring fn's context is canceled and a LockLostErrorCode error is returned
+func (s *Semaphore) Do(ctx context.Context, key string, fn func(ctx context.Context) error, withLimit ...int) error {
+	lease, err := s.Acquire(ctx, key, withLimit...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if releaseErr := lease.Release(context.WithoutCancel(ctx)); releaseErr != nil {
+			pixiecontext.GetCtxLogger(ctx).With("error", releaseErr).With("key", key).Warn("error releasing semaphore lease")
+		}
+	}()
+
+	return cache.KeepAlive(ctx, key, s.config.LeaseTTL.Duration()/3, lease.Refresh, fn)
+}
+
+func (s *Semaphore) tryAcquire(ctx context.Context, key string, withLimit ...int) (*Lease, time.Duration, error) {
+	limit := s.config.Limit
+	if len(withLimit) > 0 {
+		limit = withLimit[0]
+		if limit <= 0 {
+			return nil, 0, errors.New("semaphore limit must be positive, got %d", limit).WithErrorCode(errors.InvalidFormDataCode)
+		}
+	}
+
+	id := uid.NewUUID()
+	start := time.Now()
+	reply, err := s.cache.RunScript(ctx, semaphoreAcquireScript, []string{key}, limit, s.config.LeaseTTL.Duration().Milliseconds(), id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, 0, errors.New("unexpected semaphore reply %v", reply)
+	}
+
+	acquired, err := replyInt64(values[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	if acquired == 1 {
+		return &Lease{semaphore: s, key: key, id: id, expiresAt: start.Add(s.config.LeaseTTL.Duration())}, 0, nil
+	}
+
+	retryAfter, err := replyInt64(values[1])
+	if err != nil {
+		return nil, 0, err
+	}
+	return nil, time.Duration(retryAfter) * time.Millisecond, nil
+}
+
+// Refresh extends the lease for another LeaseTTL; LockLostErrorCode if it already expired,
+// also when failing past its local expiration
+func (l *Lease) Refresh(ctx context.Context) error {
+	start := time.Now()
+	reply, err := l.semaphore.cache.RunScript(ctx, semaphoreRefreshScript, []string{l.key}, l.semaphore.config.LeaseTTL.Duration().Milliseconds(), l.id)
+	if err != nil {
+		if !start.Before(l.ExpiresAt()) {
+			return errors.NewWithError(err, "semaphore lease of %s expired", l.key).WithErrorCode(pixieErrors.LockLostErrorCode)
+		}
+		return err
+	}
+
+	refreshed, err := replyInt64(reply)
+	if err != nil {
+		return err
+	}
+	if refreshed != 1 {
+		return errors.New("semaphore lease of %s expired", l.key).WithErrorCode(pixieErrors.LockLostErrorCode)
+	}
+
+	l.mu.Lock()
+	l.expiresAt = start.Add(l.semaphore.config.LeaseTTL.Duration())
+	l.mu.Unlock()
+	return nil
+}
+
+// KeepAlive refreshes the lease every third of LeaseTTL until stop is called, for leases outliving a call,
+// e.g. while a response body is open. The returned context is canceled with a LockLostErrorCode cause
+// once the lease can't be refreshed before expiring; stop doesn't release the lease
+func (l *Lease) KeepAlive(ctx context.Context) (context.Context, func()) {
+	leaseCtx := make(chan context.Context, 1)
+	stopping := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		_ = cache.KeepAlive(ctx, l.key, l.semaphore.config.LeaseTTL.Duration()/3, l.Refresh, func(ctx context.Context) error {
+			leaseCtx <- ctx
+			<-stopping
+			return nil
+		})
+	}()
+
+	var once sync.Once
+	return <-leaseCtx, func() {
+		once.Do(func() {
+			close(stopping)
+			<-stopped
+		})
+	}
+}
+
+// ExpiresAt local time the lease expires at unless refreshed
+func (l *Lease) ExpiresAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expiresAt
+}
+
+// Release frees the lease slot; releasing an expired lease is a no-op
+func (l *Lease) Release(ctx context.Context) error {
+	_, err := l.semaphore.cache.RunScript(ctx, semaphoreReleaseScript, []string{l.key}, l.id)
+	return err
+}
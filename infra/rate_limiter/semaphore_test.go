@@ -0,0 +1,212 @@
+package rate_limiter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pixie-sh/errors-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+func setupTestSemaphore(t *testing.T, config SemaphoreConfiguration) (*Semaphore, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	redisCache, err := cache.NewRedisCache(context.Background(), cache.RedisCacheConfiguration{Address: mr.Addr()})
+	require.NoError(t, err)
+
+	semaphore, err := NewSemaphore(context.Background(), config, redisCache)
+	require.NoError(t, err)
+	return semaphore, mr
+}
+
+func TestNewSemaphore_InvalidLimit(t *testing.T) {
+	_, err := NewSemaphore(context.Background(), SemaphoreConfiguration{}, nil)
+	assert.Error(t, err)
+}
+
+func TestSemaphore_TryAcquireRelease(t *testing.T) {
+	ctx := context.Background()
+	semaphore, _ := setupTestSemaphore(t, SemaphoreConfiguration{Limit: 2})
+
+	first, ok, err := semaphore.TryAcquire(ctx, "exports")
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = semaphore.TryAcquire(ctx, "exports")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, ok, err = semaphore.TryAcquire(ctx, "exports")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// keys are independent and limits can be overridden
+	_, ok, err = semaphore.TryAcquire(ctx, "imports", 1)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, ok, err = semaphore.TryAcquire(ctx, "imports", 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, first.Release(ctx))
+	require.NoError(t, first.Release(ctx))
+	_, ok, err = semaphore.TryAcquire(ctx, "exports")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestSemaphore_ExpiredLeases(t *testing.T) {
+	ctx := context.Background()
+	semaphore, mr := setupTestSemaphore(t, SemaphoreConfiguration{Limit: 1, LeaseTTL: coretime.Duration(10 * time.Second)})
+
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+
+	crashed, ok, err := semaphore.TryAcquire(ctx, "exports")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 10*time.Second, mr.TTL("exports"))
+
+	mr.SetTime(now.Add(5 * time.Second))
+	require.NoError(t, crashed.Refresh(ctx))
+
+	mr.SetTime(now.Add(12 * time.Second))
+	_, ok, err = semaphore.TryAcquire(ctx, "exports")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// the holder crashed, the slot frees up once its lease expires
+	mr.SetTime(now.Add(16 * time.Second))
+	_, ok, err = semaphore.TryAcquire(ctx, "exports")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	err = crashed.Refresh(ctx)
+	_, lost := errors.Has(err, pixieErrors.LockLostErrorCode)
+	assert.True(t, lost)
+}
+
+func TestSemaphore_AcquireWaits(t *testing.T) {
+	ctx := context.Background()
+	semaphore, _ := setupTestSemaphore(t, SemaphoreConfiguration{
+		Limit:      1,
+		MaxWait:    coretime.Duration(100 * time.Millisecond),
+		RetryDelay: coretime.Duration(5 * time.Millisecond),
+	})
+
+	lease, err := semaphore.Acquire(ctx, "exports")
+	require.NoError(t, err)
+
+	_, err = semaphore.Acquire(ctx, "exports")
+	_, limited := errors.Has(err, pixieErrors.RateLimitExceededErrorCode)
+	assert.True(t, limited)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = lease.Release(ctx)
+	}()
+	_, err = semaphore.Acquire(ctx, "exports")
+	assert.NoError(t, err)
+}
+
+func TestSemaphore_DoLimitsConcurrency(t *testing.T) {
+	ctx := context.Background()
+	semaphore, _ := setupTestSemaphore(t, SemaphoreConfiguration{
+		Limit:      2,
+		RetryDelay: coretime.Duration(time.Millisecond),
+	})
+
+	var running, peak atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, semaphore.Do(ctx, "exports", func(ctx context.Context) error {
+				current := running.Add(1)
+				for {
+					previous := peak.Load()
+					if current <= previous || peak.CompareAndSwap(previous, current) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				running.Add(-1)
+				return nil
+			}))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(2), peak.Load())
+}
+
+func TestSemaphore_DoLeaseLost(t *testing.T) {
+	ctx := context.Background()
+	semaphore, mr := setupTestSemaphore(t, SemaphoreConfiguration{
+		Limit:    1,
+		LeaseTTL: coretime.Duration(30 * time.Millisecond),
+	})
+
+	err := semaphore.Do(ctx, "exports", func(ctx context.Context) error {
+		// refreshed past the lease TTL
+		time.Sleep(60 * time.Millisecond)
+		require.NoError(t, ctx.Err())
+
+		mr.Del("exports")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	_, lost := errors.Has(err, pixieErrors.LockLostErrorCode)
+	assert.True(t, lost)
+}
+
+func TestSemaphore_AcquireCallerCanceled(t *testing.T) {
+	semaphore, _ := setupTestSemaphore(t, SemaphoreConfiguration{
+		Limit:      1,
+		MaxWait:    coretime.Duration(time.Second),
+		RetryDelay: coretime.Duration(5 * time.Millisecond),
+	})
+
+	_, err := semaphore.Acquire(context.Background(), "exports")
+	require.NoError(t, err)
+
+	// the caller gives up before MaxWait, it isn't a limit reached
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = semaphore.Acquire(ctx, "exports")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, limited := errors.Has(err, pixieErrors.RateLimitExceededErrorCode)
+	assert.False(t, limited)
+}
+
+func TestSemaphore_DoLeaseExpiredLocally(t *testing.T) {
+	ctx := context.Background()
+	semaphore, mr := setupTestSemaphore(t, SemaphoreConfiguration{
+		Limit:    1,
+		LeaseTTL: coretime.Duration(60 * time.Millisecond),
+	})
+
+	start := time.Now()
+	err := semaphore.Do(ctx, "exports", func(ctx context.Context) error {
+		// refreshes fail from now on, the lease expires without the holder hearing from redis
+		mr.Close()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	_, lost := errors.Has(err, pixieErrors.LockLostErrorCode)
+	assert.True(t, lost)
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/pixie-sh/errors-go"
 
+	"github.com/pixie-sh/core-go/infra/events"
 	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
 	"github.com/pixie-sh/core-go/pkg/types"
 	"github.com/pixie-sh/core-go/pkg/types/maps"
@@ -69,6 +70,19 @@ type MachineModel struct {
 	ConditionalTransitions ConditionalTransitions `json:"conditional_transitions"`
 	CurrentState           State                  `json:"current_state"`
 	CurrentStateAt         *time.Time             `json:"current_state_at"`
+	TerminalStates         []State                `json:"terminal_states,omitempty"`
+	DefinitionName         string                 `json:"definition_name,omitempty"`
+	DefinitionVersion      int                    `json:"definition_version,omitempty"`
+	Timeouts               map[State]StateTimeout `json:"timeouts,omitempty"`
+	Parents                map[State]State        `json:"parents,omitempty"`
+	InitialSubStates       map[State]State        `json:"initial_sub_states,omitempty"`
+	ParallelStates         []State                `json:"parallel_states,omitempty"`
+	ActiveStates           []State                `json:"active_states,omitempty"`
+	ActiveStatesAt         map[State]time.Time    `json:"active_states_at,omitempty"`
+	Version                int64                  `json:"version"`
+
+	// History transitions since the last Save, appended by storages keeping the transition history; not serialized
+	History []TransitionRecord `json:"-"`
 }
 
 type CheckerMachine interface {
@@ -87,6 +101,13 @@ type TriggerMachine interface {
 	Save() error
 }
 
+// PayloadTriggerMachine TriggerMachine providing payloads to guards and actions
+type PayloadTriggerMachine interface {
+	TriggerMachine
+
+	TriggerWithPayload(ctx context.Context, event Event, payload any) (State, error)
+}
+
 type Machine struct {
 	guid    string
 	id      string
@@ -99,9 +120,29 @@ type Machine struct {
 	conditionalsTransitions ConditionalTransitions
 	currentState            State
 	currentStateAt          time.Time
+	terminalStates          []State
+	timeouts                map[State]StateTimeout
+	parents                 map[State]State
+	children                map[State][]State
+	initials                map[State]State
+	parallel                map[State]struct{}
+	active                  []State
+	activeAt                map[State]time.Time // entry time of the active leaves of parallel regions
+	definitionName          string
+	definitionVersion       int
+	version                 int64
 	entityStorage           StateMachineEntityStorage
+	hooks                   machineHooks
+	emitTransitions         bool
+	transitionProducers     []events.Producer
+	pendingEvents           []events.UntypedEventWrapper
+	pendingHistory          []TransitionRecord
 }
 
+// StateMachineStorage persists machines with compare-and-swap semantics:
+// Store only succeeds if the persisted version is model.Version, persisting model.Version+1;
+// otherwise it fails with pixieErrors.StateMachineVersionConflictErrorCode.
+// Get returns the model with the persisted version
 type StateMachineStorage interface {
 	Store(model MachineModel) error
 	Get(machineID string) (MachineModel, error)
@@ -129,7 +170,13 @@ func NewMachine(_ context.Context, machineID string, storage StateMachineStorage
 		visitedStates:           []VisitedState{},
 		transitions:             make(Transitions),
 		conditionalsTransitions: make(ConditionalTransitions),
+		timeouts:                make(map[State]StateTimeout),
+		parents:                 make(map[State]State),
+		children:                make(map[State][]State),
+		initials:                make(map[State]State),
+		parallel:                make(map[State]struct{}),
 		entityStorage:           entityStorage,
+		hooks:                   newMachineHooks(),
 	}
 
 	return m
@@ -147,13 +194,17 @@ func (m *Machine) SetInitialState(state State) error {
 	if _, exists := m.states[state]; !exists {
 		return errors.New("state does not exist")
 	}
-	m.currentState = state
 	m.currentStateAt = time.Now().UTC()
+	if m.isHierarchicalUnlocked() {
+		return m.setInitialHierarchicalUnlocked(state)
+	}
+
+	m.currentState = state
 	return nil
 }
 
 // CurrentOrVisited has the user been in this step or is he currently?
-func (m Machine) CurrentOrVisited(state State) error {
+func (m *Machine) CurrentOrVisited(state State) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -174,13 +225,13 @@ func (m Machine) CurrentOrVisited(state State) error {
 	return nil
 }
 
-func (m Machine) visitedOneOfUnlocked(state ...State) error {
+func (m *Machine) visitedOneOfUnlocked(state ...State) error {
 	for _, visited := range state {
 		contains := slices.Find(m.visitedStates, func(item VisitedState) bool {
 			return item.State == visited
 		})
 
-		if !types.IsEmpty(contains) {
+		if !types.IsEmpty(contains) || m.activeInRegionsUnlocked(visited) {
 			return nil
 		}
 	}
@@ -197,7 +248,7 @@ func (m Machine) visitedOneOfUnlocked(state ...State) error {
 
 // VisitedOneOf return error if none of provided states were visited;
 // return nil if at least one state of requested one was visited
-func (m Machine) VisitedOneOf(state ...State) error {
+func (m *Machine) VisitedOneOf(state ...State) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	return m.visitedOneOfUnlocked(state...)
@@ -205,7 +256,7 @@ func (m Machine) VisitedOneOf(state ...State) error {
 
 // VisitedAll return error if at least is not visited
 // return nil if all were visited
-func (m Machine) VisitedAll(state ...State) error {
+func (m *Machine) VisitedAll(state ...State) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -213,14 +264,14 @@ func (m Machine) VisitedAll(state ...State) error {
 	return err
 }
 
-func (m Machine) visitedAllUnlocked(state ...State) ([]State, error) {
+func (m *Machine) visitedAllUnlocked(state ...State) ([]State, error) {
 	var notVisited []State
 	for _, stateToLookup := range state {
 		contains := slices.Find(m.visitedStates, func(item VisitedState) bool {
 			return item.State == stateToLookup
 		})
 
-		if types.IsEmpty(contains) {
+		if types.IsEmpty(contains) && !m.activeInRegionsUnlocked(stateToLookup) {
 			notVisited = append(notVisited, stateToLookup)
 		}
 	}
@@ -362,18 +413,54 @@ func (m *Machine) AddConditionalTransition(
 // It returns the next state if the transition is successful or an error if the
 // event is invalid or the transition conditions are not met.
 func (m *Machine) Trigger(ctx context.Context, event Event) (State, error) {
+	return m.TriggerWithPayload(ctx, event, nil)
+}
+
+// TriggerWithPayload Trigger providing a payload to guards and actions.
+// Candidate transitions must meet their conditions and guards; a guard rejecting the
+// regular transition returns the guard error. Exit, transition and enter actions are
+// performed in that order and the state change is rolled back if any of them fails.
+func (m *Machine) TriggerWithPayload(ctx context.Context, event Event, payload any) (State, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if m.isHierarchicalUnlocked() {
+		return m.triggerHierarchicalUnlocked(ctx, event, payload)
+	}
+
+	nextState, err := m.resolveTransitionUnlocked(ctx, m.currentState, event, payload)
+	if err != nil {
+		return NilState, err
+	}
+
+	return m.transitionUnlocked(ctx, m.transitionContextUnlocked(m.currentState, event, nextState, payload))
+}
+
+func (m *Machine) transitionContextUnlocked(from State, event Event, to State, payload any) TransitionContext {
+	return TransitionContext{
+		Machine: machineView{m: m},
+		From:    from,
+		Event:   event,
+		To:      to,
+		Payload: payload,
+	}
+}
+
+// resolveTransitionUnlocked next state from 'from' with event, meeting conditions and guards
+func (m *Machine) resolveTransitionUnlocked(ctx context.Context, from State, event Event, payload any) (State, error) {
 	var errFields = make(map[State][]*errors.FieldError)
-	nextStateConditions, existsConditional := m.conditionalsTransitions[m.currentState][event]
+	nextStateConditions, existsConditional := m.conditionalsTransitions[from][event]
 	if existsConditional {
 		for _, nextStateCondition := range nextStateConditions {
-			fieldErr, err := m.evaluateCondition(event, nextStateCondition.If, nextStateCondition.IfStates)
+			fieldErr, err := m.evaluateCondition(from, event, nextStateCondition.If, nextStateCondition.IfStates)
 			if err != nil {
 				return NilState, err
 			}
 
+			if fieldErr == nil {
+				fieldErr, _ = m.evaluateGuardsUnlocked(ctx, m.transitionContextUnlocked(from, event, nextStateCondition.To, payload))
+			}
+
 			if fieldErr != nil {
 				_, ok := errFields[nextStateCondition.To]
 				if !ok {
@@ -388,33 +475,43 @@ func (m *Machine) Trigger(ctx context.Context, event Event) (State, error) {
 		for _, nextStateCondition := range nextStateConditions {
 			_, withErr := errFields[nextStateCondition.To]
 			if !withErr {
-				return m.affectStateWithNextStateUnlocked(nextStateCondition.To, time.Now().UTC())
+				return nextStateCondition.To, nil
 			}
 		}
 	}
 
 	if len(errFields) > 0 {
 		pixiecontext.GetCtxLogger(ctx).With("event", event).
-			With("state", m.currentState).
+			With("state", from).
 			With("errFields", errFields).
 			Debug("invalid contional transition")
 	}
 
-	nextState, exists := m.transitions[m.currentState][event]
+	nextState, exists := m.transitions[from][event]
 	if !exists {
 		errList := maps.MapSliceValues(errFields)
 		return NilState, errors.NewValidationError("Invalid transition", append(errList, &errors.FieldError{
 			Field:   "event",
 			Rule:    "InvalidTransition",
 			Param:   event.String(),
-			Message: fmt.Sprintf("invalid transition from '%s' with '%s'", m.currentState, event),
+			Message: fmt.Sprintf("invalid transition from '%s' with '%s'", from, event),
 		})...).WithErrorCode(errors.StateMachineInvalidTransitionErrorCode)
 	}
 
-	return m.affectStateWithNextStateUnlocked(nextState, time.Now().UTC())
+	fieldErr, guardErr := m.evaluateGuardsUnlocked(ctx, m.transitionContextUnlocked(from, event, nextState, payload))
+	if fieldErr != nil {
+		castedErr, ok := errors.As(guardErr)
+		if ok {
+			return NilState, castedErr
+		}
+
+		return NilState, errors.NewValidationError("Invalid transition", fieldErr).WithErrorCode(errors.StateMachineInvalidTransitionErrorCode)
+	}
+
+	return nextState, nil
 }
 
-func (m Machine) CurrentState() State {
+func (m *Machine) CurrentState() State {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -423,24 +520,64 @@ func (m Machine) CurrentState() State {
 
 func (m *Machine) Save() error {
 	m.mu.Lock()
-	model := MachineModel{
+	model := m.modelUnlocked()
+	model.History = m.pendingHistory
+	pending := m.pendingEvents
+	producers := m.transitionProducers
+	m.pendingEvents = nil
+	m.pendingHistory = nil
+	m.mu.Unlock()
+
+	err := m.entityStorage.StoreCurrentState(model.CurrentState)
+	if err != nil {
+		m.mu.Lock()
+		m.pendingEvents = append(pending, m.pendingEvents...)
+		m.pendingHistory = append(model.History, m.pendingHistory...)
+		m.mu.Unlock()
+		return err
+	}
+
+	stored, err := m.storeWithEvents(model, pending, producers)
+	m.mu.Lock()
+	if stored {
+		m.version = model.Version + 1
+	} else {
+		m.pendingHistory = append(model.History, m.pendingHistory...)
+	}
+	m.mu.Unlock()
+
+	return err
+}
+
+func (m *Machine) modelUnlocked() MachineModel {
+	currentStateAt := m.currentStateAt
+	return MachineModel{
 		Guid:                   m.guid,
 		ID:                     m.id,
 		States:                 maps.MapKeys(m.states),
-		VisitedStates:          m.visitedStates,
+		VisitedStates:          slices.Copy(m.visitedStates),
 		Transitions:            m.transitions,
 		ConditionalTransitions: m.conditionalsTransitions,
 		CurrentState:           m.currentState,
-		CurrentStateAt:         &m.currentStateAt,
-	}
-	m.mu.Unlock()
-
-	err := m.entityStorage.StoreCurrentState(m.currentState)
-	if err != nil {
-		return err
+		CurrentStateAt:         &currentStateAt,
+		TerminalStates:         m.terminalStates,
+		Timeouts:               m.timeouts,
+		Parents:                m.parents,
+		InitialSubStates:       m.initials,
+		ParallelStates:         m.parallelStatesUnlocked(),
+		ActiveStates:           slices.Copy(m.active),
+		ActiveStatesAt:         copyStateTimes(m.activeAt),
+		DefinitionName:         m.definitionName,
+		DefinitionVersion:      m.definitionVersion,
+		Version:                m.version,
 	}
+}
 
-	return m.storage.Store(model)
+// Version persisted version the machine was restored or last saved with
+func (m *Machine) Version() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.version
 }
 
 func (m *Machine) Restore() error {
@@ -463,17 +600,47 @@ func (m *Machine) Restore() error {
 	m.conditionalsTransitions = restored.ConditionalTransitions
 	m.currentState = restored.CurrentState
 	m.currentStateAt = *restored.CurrentStateAt
+	m.terminalStates = restored.TerminalStates
+	m.timeouts = restored.Timeouts
+	if m.timeouts == nil {
+		m.timeouts = make(map[State]StateTimeout)
+	}
+	m.definitionName = restored.DefinitionName
+	m.definitionVersion = restored.DefinitionVersion
+	m.restoreHierarchyUnlocked(restored)
+	m.version = restored.Version
+	m.pendingEvents = nil
+	m.pendingHistory = nil
 	m.id = restored.ID
 	m.guid = restored.Guid
 
 	return nil
 }
 
-func (m Machine) ID() string {
+func (m *Machine) ID() string {
 	return m.id
 }
 
-func (m Machine) evaluateCondition(event Event, nextStateConditionIf ConditionEnum, ifStates []State) (*errors.FieldError, error) {
+// Definition returns the name and version of the Definition the machine was created or migrated with
+func (m *Machine) Definition() (string, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.definitionName, m.definitionVersion
+}
+
+// IsTerminal whether every active state is one of the definition terminal states
+func (m *Machine) IsTerminal() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, state := range m.activeUnlocked() {
+		if !slices.Contains(m.terminalStates, state) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Machine) evaluateCondition(from State, event Event, nextStateConditionIf ConditionEnum, ifStates []State) (*errors.FieldError, error) {
 	switch nextStateConditionIf {
 	case ConditionNeverVisitedOneOf:
 		err := m.visitedOneOfUnlocked(ifStates...)
@@ -484,7 +651,7 @@ func (m Machine) evaluateCondition(event Event, nextStateConditionIf ConditionEn
 				Param: event.String(),
 				Message: fmt.Sprintf(
 					"invalid transition from '%s' with '%s', conditions '%s' are not met",
-					m.currentState,
+					from,
 					event,
 					nextStateConditionIf,
 				),
@@ -499,7 +666,7 @@ func (m Machine) evaluateCondition(event Event, nextStateConditionIf ConditionEn
 				Param: event.String(),
 				Message: fmt.Sprintf(
 					"invalid transition from '%s' with '%s', conditions '%s' are not met",
-					m.currentState,
+					from,
 					event,
 					nextStateConditionIf,
 				),
@@ -514,7 +681,7 @@ func (m Machine) evaluateCondition(event Event, nextStateConditionIf ConditionEn
 				Param: event.String(),
 				Message: fmt.Sprintf(
 					"invalid transition from '%s' with '%s', conditions '%s' are not met",
-					m.currentState,
+					from,
 					event,
 					nextStateConditionIf,
 				),
@@ -529,7 +696,7 @@ func (m Machine) evaluateCondition(event Event, nextStateConditionIf ConditionEn
 				Param: event.String(),
 				Message: fmt.Sprintf(
 					"invalid transition from '%s' with '%s', conditions '%s' are not met",
-					m.currentState,
+					from,
 					event,
 					nextStateConditionIf,
 				),
@@ -555,6 +722,6 @@ func (m *Machine) affectStateWithNextStateUnlocked(nextState State, now time.Tim
 	return m.currentState, nil
 }
 
-func (m Machine) Visited() []VisitedState {
+func (m *Machine) Visited() []VisitedState {
 	return slices.Copy(m.visitedStates)
 }
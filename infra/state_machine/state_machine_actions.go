@@ -0,0 +1,258 @@
+package state_machine
+
+import (
+	"context"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	"github.com/pixie-sh/core-go/pkg/models/serializer"
+	"github.com/pixie-sh/core-go/pkg/types"
+	"github.com/pixie-sh/core-go/pkg/types/slices"
+)
+
+// TransitionContext transition being evaluated or performed, provided to guards and actions
+// Machine is a read only view of the machine; during enter actions it already reflects the To state
+type TransitionContext struct {
+	Machine CheckerMachine
+	From    State
+	Event   Event
+	To      State
+	Payload any
+}
+
+// Guard returns an error to reject the transition
+type Guard func(ctx context.Context, transition TransitionContext) error
+
+// Action callback performed during a transition; an error rolls back the state change
+type Action func(ctx context.Context, transition TransitionContext) error
+
+// TypedGuard guard over a typed payload; payloads of other types are converted to P
+func TypedGuard[P any](guard func(ctx context.Context, transition TransitionContext, payload P) error) Guard {
+	return func(ctx context.Context, transition TransitionContext) error {
+		payload, err := payloadOf[P](transition)
+		if err != nil {
+			return err
+		}
+
+		return guard(ctx, transition, payload)
+	}
+}
+
+// TypedAction action over a typed payload; payloads of other types are converted to P
+func TypedAction[P any](action func(ctx context.Context, transition TransitionContext, payload P) error) Action {
+	return func(ctx context.Context, transition TransitionContext) error {
+		payload, err := payloadOf[P](transition)
+		if err != nil {
+			return err
+		}
+
+		return action(ctx, transition, payload)
+	}
+}
+
+func payloadOf[P any](transition TransitionContext) (P, error) {
+	payload, ok := transition.Payload.(P)
+	if ok {
+		return payload, nil
+	}
+
+	payload, err := serializer.FromAny[P](transition.Payload)
+	if err != nil {
+		return payload, errors.NewWithError(err, "invalid payload '%s' for transition from '%s' with '%s'",
+			types.NameOf(transition.Payload), transition.From, transition.Event).
+			WithErrorCode(errors.InvalidTypeErrorCode)
+	}
+
+	return payload, nil
+}
+
+type transitionKey struct {
+	from  State
+	event Event
+}
+
+type guardKey struct {
+	from  State
+	event Event
+	to    State
+}
+
+// machineHooks guards and actions are code, they are not part of MachineModel and survive Restore
+type machineHooks struct {
+	guards       map[guardKey][]Guard
+	onExit       map[State][]Action
+	onTransition map[transitionKey][]Action
+	onEnter      map[State][]Action
+}
+
+func newMachineHooks() machineHooks {
+	return machineHooks{
+		guards:       make(map[guardKey][]Guard),
+		onExit:       make(map[State][]Action),
+		onTransition: make(map[transitionKey][]Action),
+		onEnter:      make(map[State][]Action),
+	}
+}
+
+// AddGuard adds a guard to the transition from 'from' to 'to' with event, either regular or conditional.
+// Guards are evaluated in order after the transition conditions; a rejected conditional transition
+// lets the next candidate be evaluated, as a condition that is not met does.
+func (m *Machine) AddGuard(from State, event Event, to State, guard Guard) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.states[from]; !exists {
+		return errors.New("'from' state does not exist").WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+	if _, exists := m.states[to]; !exists {
+		return errors.New("'to' state does not exist").WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+	if guard == nil {
+		return errors.New("nil guard").WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+
+	key := guardKey{from: from, event: event, to: to}
+	m.hooks.guards[key] = append(m.hooks.guards[key], guard)
+	return nil
+}
+
+// OnExit adds an action performed when leaving state, before the transition actions
+func (m *Machine) OnExit(state State, action Action) error {
+	return m.addStateAction(m.hooks.onExit, state, action)
+}
+
+// OnEnter adds an action performed after the state is changed to state
+func (m *Machine) OnEnter(state State, action Action) error {
+	return m.addStateAction(m.hooks.onEnter, state, action)
+}
+
+// OnTransition adds an action performed when 'from' is left with event, after exit actions and before the state change
+func (m *Machine) OnTransition(from State, event Event, action Action) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.states[from]; !exists {
+		return errors.New("'from' state does not exist").WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+	if action == nil {
+		return errors.New("nil action").WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+
+	key := transitionKey{from: from, event: event}
+	m.hooks.onTransition[key] = append(m.hooks.onTransition[key], action)
+	return nil
+}
+
+func (m *Machine) addStateAction(actions map[State][]Action, state State, action Action) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.states[state]; !exists {
+		return errors.New("state does not exist").WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+	if action == nil {
+		return errors.New("nil action").WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+
+	actions[state] = append(actions[state], action)
+	return nil
+}
+
+// evaluateGuardsUnlocked returns the first guard rejection as field error and the guard error
+func (m *Machine) evaluateGuardsUnlocked(ctx context.Context, transition TransitionContext) (*errors.FieldError, error) {
+	for _, guard := range m.hooks.guards[guardKey{from: transition.From, event: transition.Event, to: transition.To}] {
+		err := guard(ctx, transition)
+		if err != nil {
+			return &errors.FieldError{
+				Field:   "event",
+				Rule:    "GuardRejected",
+				Param:   transition.Event.String(),
+				Message: err.Error(),
+			}, err
+		}
+	}
+
+	return nil, nil
+}
+
+// transitionUnlocked performs exit, transition and enter actions around the state change;
+// the first failing action stops the transition and the previous state is restored
+func (m *Machine) transitionUnlocked(ctx context.Context, transition TransitionContext) (State, error) {
+	var (
+		previousState   = m.currentState
+		previousStateAt = m.currentStateAt
+		previousVisited = slices.Copy(m.visitedStates)
+	)
+
+	rollback := func(err error, stage string) (State, error) {
+		m.currentState = previousState
+		m.currentStateAt = previousStateAt
+		m.visitedStates = previousVisited
+
+		castedErr, ok := errors.As(err)
+		if ok {
+			return NilState, castedErr
+		}
+
+		return NilState, errors.NewWithError(err, "%s action failed on transition from '%s' with '%s'", stage, transition.From, transition.Event).
+			WithErrorCode(pixieErrors.StateMachineActionFailedErrorCode)
+	}
+
+	for _, action := range m.hooks.onExit[transition.From] {
+		if err := action(ctx, transition); err != nil {
+			return rollback(err, "exit")
+		}
+	}
+
+	for _, action := range m.hooks.onTransition[transitionKey{from: transition.From, event: transition.Event}] {
+		if err := action(ctx, transition); err != nil {
+			return rollback(err, "transition")
+		}
+	}
+
+	_, _ = m.affectStateWithNextStateUnlocked(transition.To, time.Now().UTC())
+	for _, action := range m.hooks.onEnter[transition.To] {
+		if err := action(ctx, transition); err != nil {
+			return rollback(err, "enter")
+		}
+	}
+
+	m.recordTransitionUnlocked(ctx, transition, m.currentStateAt)
+	return m.currentState, nil
+}
+
+// machineView CheckerMachine used by guards and actions while the machine is locked
+type machineView struct {
+	m *Machine
+}
+
+func (v machineView) ID() string {
+	return v.m.id
+}
+
+func (v machineView) CurrentOrVisited(state State) error {
+	if v.m.currentState == state {
+		return nil
+	}
+
+	return v.m.visitedOneOfUnlocked(state)
+}
+
+func (v machineView) VisitedOneOf(state ...State) error {
+	return v.m.visitedOneOfUnlocked(state...)
+}
+
+func (v machineView) VisitedAll(state ...State) error {
+	_, err := v.m.visitedAllUnlocked(state...)
+	return err
+}
+
+func (v machineView) Visited() []VisitedState {
+	return slices.Copy(v.m.visitedStates)
+}
+
+func (v machineView) CurrentState() State {
+	return v.m.currentState
+}
@@ -0,0 +1,126 @@
+package state_machine
+
+import (
+	"context"
+	"testing"
+
+	perrors "github.com/pixie-sh/errors-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+)
+
+type paymentPayload struct {
+	Amount int `json:"amount"`
+}
+
+func newCheckoutMachine(t *testing.T) *Machine {
+	m := NewMachine(context.Background(), "checkout", &MockStorage{t: t})
+	m.AddState("cart")
+	m.AddState("paid")
+	m.AddState("review")
+	require.NoError(t, m.SetInitialState("cart"))
+	require.NoError(t, m.AddTransition("cart", "pay", "paid"))
+	return m
+}
+
+func TestTriggerWithPayload_Guards(t *testing.T) {
+	m := newCheckoutMachine(t)
+	require.NoError(t, m.AddGuard("cart", "pay", "paid", TypedGuard[paymentPayload](
+		func(ctx context.Context, transition TransitionContext, payload paymentPayload) error {
+			if payload.Amount <= 0 {
+				return perrors.New("amount must be positive").WithErrorCode(perrors.InvalidFormDataCode)
+			}
+			return nil
+		})))
+
+	ctx := context.Background()
+	_, err := m.TriggerWithPayload(ctx, "pay", paymentPayload{Amount: 0})
+	require.Error(t, err)
+	_, hasCode := perrors.Has(err, perrors.InvalidFormDataCode)
+	assert.True(t, hasCode, "guard error code must be kept")
+	assert.Equal(t, State("cart"), m.CurrentState())
+
+	_, err = m.Trigger(ctx, "pay")
+	assert.Error(t, err, "nil payload does not convert to the guard type")
+
+	state, err := m.TriggerWithPayload(ctx, "pay", map[string]interface{}{"amount": 10})
+	assert.NoError(t, err)
+	assert.Equal(t, State("paid"), state)
+}
+
+func TestTriggerWithPayload_GuardRejectsConditionalCandidate(t *testing.T) {
+	m := newCheckoutMachine(t)
+	require.NoError(t, m.AddConditionalTransition("cart", "pay", "review", ConditionNeverVisitedOneOf, "paid"))
+	require.NoError(t, m.AddGuard("cart", "pay", "review", TypedGuard[paymentPayload](
+		func(ctx context.Context, transition TransitionContext, payload paymentPayload) error {
+			if payload.Amount < 1000 {
+				return perrors.New("no review needed")
+			}
+			return nil
+		})))
+
+	state, err := m.TriggerWithPayload(context.Background(), "pay", paymentPayload{Amount: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, State("paid"), state)
+}
+
+func TestTriggerWithPayload_ActionsOrder(t *testing.T) {
+	m := newCheckoutMachine(t)
+
+	var calls []string
+	record := func(name string) Action {
+		return func(ctx context.Context, transition TransitionContext) error {
+			calls = append(calls, name+":"+transition.Machine.CurrentState().String())
+			return nil
+		}
+	}
+
+	require.NoError(t, m.OnExit("cart", record("exit")))
+	require.NoError(t, m.OnTransition("cart", "pay", record("transition")))
+	require.NoError(t, m.OnEnter("paid", record("enter")))
+
+	_, err := m.Trigger(context.Background(), "pay")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"exit:cart", "transition:cart", "enter:paid"}, calls)
+}
+
+func TestTriggerWithPayload_RollbackOnActionFailure(t *testing.T) {
+	m := newCheckoutMachine(t)
+	visitedBefore := m.Visited()
+
+	require.NoError(t, m.OnEnter("paid", func(ctx context.Context, transition TransitionContext) error {
+		return assert.AnError
+	}))
+
+	_, err := m.Trigger(context.Background(), "pay")
+	require.Error(t, err)
+	_, hasCode := perrors.Has(err, pixieErrors.StateMachineActionFailedErrorCode)
+	assert.True(t, hasCode)
+	assert.Equal(t, State("cart"), m.CurrentState())
+	assert.Equal(t, visitedBefore, m.Visited())
+}
+
+func TestHooksSurviveRestore(t *testing.T) {
+	storage := &MockStorage{t: t}
+	m := NewMachine(context.Background(), "checkout", storage)
+	m.AddState("cart")
+	m.AddState("paid")
+	require.NoError(t, m.SetInitialState("cart"))
+	require.NoError(t, m.AddTransition("cart", "pay", "paid"))
+	require.NoError(t, m.Save())
+
+	restored := NewMachine(context.Background(), "checkout", storage)
+	restored.AddState("paid")
+	entered := false
+	require.NoError(t, restored.OnEnter("paid", func(ctx context.Context, transition TransitionContext) error {
+		entered = true
+		return nil
+	}))
+	require.NoError(t, restored.Restore())
+
+	_, err := restored.Trigger(context.Background(), "pay")
+	require.NoError(t, err)
+	assert.True(t, entered)
+}
@@ -0,0 +1,69 @@
+package state_machine
+
+import (
+	"context"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	"github.com/pixie-sh/core-go/pkg/pagination"
+)
+
+const defaultBulkTriggerBatchSize = 100
+
+type BulkTriggerConfiguration struct {
+	BatchSize      uint64                      `json:"batch_size"` // machines fetched per batch; 100 if not set
+	TriggerAndSave TriggerAndSaveConfiguration `json:"trigger_and_save"`
+}
+
+// BulkTriggerResult triggered machines count and the errors of the machines failing, by machine ID
+type BulkTriggerResult struct {
+	Triggered int
+	Failed    map[string]error
+}
+
+// MachineIDsFetcher fetches a batch of machine IDs; batches smaller than batchSize end the bulk.
+// Triggered machines may leave the fetched set, so fetchers over a filter page with a cursor instead of page
+type MachineIDsFetcher func(ctx context.Context, page uint64, batchSize uint64) ([]string, error)
+
+// BulkTrigger triggers event, with payload, on each fetched machine with TriggerAndSave, in batches.
+// Machines failing to provide or trigger are reported in the result and don't stop the bulk;
+// fetch errors and context cancellation do, returning the partial result
+func BulkTrigger(
+	ctx context.Context,
+	fetch MachineIDsFetcher,
+	provider MachineProvider,
+	event Event,
+	payload any,
+	config BulkTriggerConfiguration,
+	withLocker ...cache.SharedLocker,
+) (BulkTriggerResult, error) {
+	if config.BatchSize == 0 {
+		config.BatchSize = defaultBulkTriggerBatchSize
+	}
+
+	log := pixiecontext.GetCtxLogger(ctx).With("event", event)
+	result := BulkTriggerResult{Failed: make(map[string]error)}
+
+	err := pagination.PaginatedFunctionProcessor(ctx, fetch, func(ctx context.Context, machineIDs []string) error {
+		for _, machineID := range machineIDs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			machine, err := provider(ctx, machineID)
+			if err == nil {
+				_, err = TriggerAndSave(ctx, machine, event, payload, config.TriggerAndSave, withLocker...)
+			}
+			if err != nil {
+				log.With("machine_id", machineID).With("error", err).Warn("error triggering state machine in bulk")
+				result.Failed[machineID] = err
+				continue
+			}
+
+			result.Triggered++
+		}
+		return nil
+	}, config.BatchSize, log)
+
+	return result, err
+}
@@ -0,0 +1,58 @@
+package state_machine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkTrigger(t *testing.T) {
+	ctx := context.Background()
+	storage := newVersionedStorage()
+
+	var machineIDs []string
+	for i := 0; i < 5; i++ {
+		m := newPaymentMachine(t, storage, time.Hour)
+		m.id = fmt.Sprintf("payment-%d", i)
+		if i == 2 {
+			_, err := m.Trigger(ctx, "pay")
+			require.NoError(t, err)
+		}
+		require.NoError(t, m.Save())
+		machineIDs = append(machineIDs, m.id)
+	}
+	machineIDs = append(machineIDs, "unknown")
+
+	var fetched []uint64
+	fetch := func(_ context.Context, page uint64, batchSize uint64) ([]string, error) {
+		fetched = append(fetched, page)
+		start := min(page*batchSize, uint64(len(machineIDs)))
+		return machineIDs[start:min(start+batchSize, uint64(len(machineIDs)))], nil
+	}
+
+	result, err := BulkTrigger(ctx, fetch, RestoreMachineProvider(storage), "expire", nil, BulkTriggerConfiguration{BatchSize: 4})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{0, 1}, fetched)
+	assert.Equal(t, 4, result.Triggered)
+	assert.Len(t, result.Failed, 2)
+	assert.Contains(t, result.Failed, "payment-2")
+	assert.Contains(t, result.Failed, "unknown")
+
+	persisted, err := storage.Get("payment-4")
+	require.NoError(t, err)
+	assert.Equal(t, State("expired"), persisted.CurrentState)
+}
+
+func TestBulkTrigger_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fetch := func(context.Context, uint64, uint64) ([]string, error) { return []string{"payment"}, nil }
+	result, err := BulkTrigger(ctx, fetch, RestoreMachineProvider(newVersionedStorage()), "expire", nil, BulkTriggerConfiguration{})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, result.Triggered)
+}
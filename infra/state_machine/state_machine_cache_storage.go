@@ -0,0 +1,190 @@
+package state_machine
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	mapper "github.com/pixie-sh/core-go/pkg/models/serializer"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+	"github.com/pixie-sh/core-go/pkg/types/slices"
+)
+
+const defaultCacheStorageKeyPrefix = "state_machine:"
+
+// cacheStoreScript KEYS[1] machine hash; ARGV version, next version, model, expiration in ms (0 persists)
+const cacheStoreScript = `
+local current = redis.call('HGET', KEYS[1], 'version')
+if (current or '0') ~= ARGV[1] then
+    return 0
+end
+redis.call('HSET', KEYS[1], 'version', ARGV[2], 'model', ARGV[3])
+if tonumber(ARGV[4]) > 0 then
+    redis.call('PEXPIRE', KEYS[1], ARGV[4])
+else
+    redis.call('PERSIST', KEYS[1])
+end
+return 1
+`
+
+const cacheGetScript = `return redis.call('HMGET', KEYS[1], 'version', 'model')`
+
+// MachineArchive durable storage of finished machines, written without version check
+type MachineArchive interface {
+	Archive(model MachineModel) error
+}
+
+type CacheStorageConfiguration struct {
+	KeyPrefix   string            `json:"key_prefix"`   // prefix of the machine keys, followed by the machine ID; state_machine: if not set
+	TTL         coretime.Duration `json:"ttl"`          // expiration of the machines, renewed on each store; no expiration if not set
+	TerminalTTL coretime.Duration `json:"terminal_ttl"` // expiration of the machines in terminal states; TTL if not set
+}
+
+// CacheStorage StateMachineStorage on a cache, for short lived machines; each machine is a hash
+// with its version and model, stored with compare-and-swap by a Lua script.
+// The transition history (MachineModel.History) is not kept
+type CacheStorage struct {
+	ctx     context.Context
+	config  CacheStorageConfiguration
+	cache   cache.ScriptCache
+	archive MachineArchive
+}
+
+// NewCacheStorage storage on scriptCache; with an archive, machines reaching terminal states are written through to it
+func NewCacheStorage(ctx context.Context, config CacheStorageConfiguration, scriptCache cache.ScriptCache, withArchive ...MachineArchive) *CacheStorage {
+	if len(config.KeyPrefix) == 0 {
+		config.KeyPrefix = defaultCacheStorageKeyPrefix
+	}
+	if config.TerminalTTL <= 0 {
+		config.TerminalTTL = config.TTL
+	}
+
+	s := &CacheStorage{
+		ctx:    ctx,
+		config: config,
+		cache:  scriptCache,
+	}
+	if len(withArchive) > 0 {
+		s.archive = withArchive[0]
+	}
+
+	return s
+}
+
+// Store implements StateMachineStorage; once stored, terminal machines are written through to the archive.
+// A failing write through doesn't undo the store and can be retried with WriteThrough
+func (s *CacheStorage) Store(model MachineModel) error {
+	model.History = nil
+	blob, err := mapper.Serialize(model)
+	if err != nil {
+		return err
+	}
+
+	terminal := model.IsTerminal()
+	ttl := s.config.TTL
+	if terminal {
+		ttl = s.config.TerminalTTL
+	}
+
+	result, err := s.cache.Eval(s.ctx, cacheStoreScript, []string{s.key(model.ID)},
+		strconv.FormatInt(model.Version, 10),
+		strconv.FormatInt(model.Version+1, 10),
+		blob,
+		ttl.Duration().Milliseconds(),
+	)
+	if err != nil {
+		return err
+	}
+
+	stored, ok := result.(int64)
+	if !ok || stored != 1 {
+		return errors.New("state machine %s was changed, version %d is outdated", model.ID, model.Version).
+			WithErrorCode(pixieErrors.StateMachineVersionConflictErrorCode)
+	}
+
+	if terminal && s.archive != nil {
+		model.Version++
+		return s.writeThrough(model)
+	}
+
+	return nil
+}
+
+// Get implements StateMachineStorage
+func (s *CacheStorage) Get(machineID string) (MachineModel, error) {
+	var model MachineModel
+
+	result, err := s.cache.Eval(s.ctx, cacheGetScript, []string{s.key(machineID)})
+	if err != nil {
+		return model, err
+	}
+
+	fields, ok := result.([]interface{})
+	if !ok || len(fields) != 2 || fields[1] == nil {
+		return model, errors.New("state machine %s not found", machineID).WithErrorCode(errors.NotFoundErrorCode)
+	}
+
+	version, err := strconv.ParseInt(fields[0].(string), 10, 64)
+	if err != nil {
+		return model, err
+	}
+
+	err = mapper.DeserializeFromStr(fields[1].(string), &model, false)
+	if err != nil {
+		return model, err
+	}
+
+	model.Version = version
+	return model, nil
+}
+
+// Delete removes the machine
+func (s *CacheStorage) Delete(machineID string) error {
+	return s.cache.Delete(s.ctx, s.key(machineID))
+}
+
+// WriteThrough writes the stored machine through to the archive
+func (s *CacheStorage) WriteThrough(machineID string) error {
+	if s.archive == nil {
+		return errors.New("state machine cache storage has no archive")
+	}
+
+	model, err := s.Get(machineID)
+	if err != nil {
+		return err
+	}
+
+	return s.writeThrough(model)
+}
+
+func (s *CacheStorage) writeThrough(model MachineModel) error {
+	err := s.archive.Archive(model)
+	if err != nil {
+		return errors.NewWithError(err, "state machine %s stored, write through failed", model.ID).
+			WithErrorCode(errors.ErrorPerformingRequestErrorCode)
+	}
+
+	return nil
+}
+
+func (s *CacheStorage) key(machineID string) string {
+	return s.config.KeyPrefix + machineID
+}
+
+// IsTerminal whether every active state is terminal
+func (m MachineModel) IsTerminal() bool {
+	active := m.ActiveStates
+	if len(active) == 0 {
+		active = []State{m.CurrentState}
+	}
+
+	for _, state := range active {
+		if !slices.Contains(m.TerminalStates, state) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package state_machine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	perrors "github.com/pixie-sh/errors-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+type recordingArchive struct {
+	archived []MachineModel
+	fail     bool
+}
+
+func (a *recordingArchive) Archive(model MachineModel) error {
+	if a.fail {
+		return perrors.New("archive unavailable")
+	}
+	a.archived = append(a.archived, model)
+	return nil
+}
+
+func newTestCacheStorage(t *testing.T, config CacheStorageConfiguration, withArchive ...MachineArchive) (*CacheStorage, *miniredis.Miniredis) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	redisCache, err := cache.NewRedisCache(context.Background(), cache.RedisCacheConfiguration{Address: mr.Addr()})
+	require.NoError(t, err)
+
+	return NewCacheStorage(context.Background(), config, redisCache, withArchive...), mr
+}
+
+func TestCacheStorage_StoreAndRestore(t *testing.T) {
+	ctx := context.Background()
+	storage, mr := newTestCacheStorage(t, CacheStorageConfiguration{TTL: coretime.Duration(time.Hour)})
+
+	m := newPaymentMachine(t, storage, time.Hour)
+	require.NoError(t, m.Save())
+	assert.Equal(t, time.Hour, mr.TTL("state_machine:payment"))
+
+	restored := NewMachine(ctx, "payment", storage)
+	require.NoError(t, restored.Restore())
+	assert.Equal(t, State("pending"), restored.CurrentState())
+	_, ok := restored.DueTimeout()
+	assert.True(t, ok)
+
+	_, err := storage.Get("unknown")
+	_, notFound := perrors.Has(err, perrors.NotFoundErrorCode)
+	assert.True(t, notFound)
+
+	require.NoError(t, storage.Delete("payment"))
+	assert.False(t, mr.Exists("state_machine:payment"))
+}
+
+func TestCacheStorage_VersionConflict(t *testing.T) {
+	ctx := context.Background()
+	storage, _ := newTestCacheStorage(t, CacheStorageConfiguration{})
+
+	require.NoError(t, newPaymentMachine(t, storage, time.Hour).Save())
+
+	first := NewMachine(ctx, "payment", storage)
+	require.NoError(t, first.Restore())
+	second := NewMachine(ctx, "payment", storage)
+	require.NoError(t, second.Restore())
+
+	_, err := first.Trigger(ctx, "pay")
+	require.NoError(t, err)
+	require.NoError(t, first.Save())
+
+	_, err = second.Trigger(ctx, "expire")
+	require.NoError(t, err)
+	err = second.Save()
+	_, conflict := perrors.Has(err, pixieErrors.StateMachineVersionConflictErrorCode)
+	assert.True(t, conflict)
+
+	persisted, err := storage.Get("payment")
+	require.NoError(t, err)
+	assert.Equal(t, State("paid"), persisted.CurrentState)
+	assert.Equal(t, int64(2), persisted.Version)
+}
+
+func TestCacheStorage_WriteThroughOnTerminal(t *testing.T) {
+	ctx := context.Background()
+	archive := &recordingArchive{}
+	storage, mr := newTestCacheStorage(t, CacheStorageConfiguration{
+		TTL:         coretime.Duration(time.Hour),
+		TerminalTTL: coretime.Duration(time.Minute),
+	}, archive)
+
+	m := newPaymentMachine(t, storage, time.Hour)
+	m.terminalStates = []State{"paid", "expired"}
+	require.NoError(t, m.Save())
+	assert.Empty(t, archive.archived)
+
+	_, err := m.Trigger(ctx, "pay")
+	require.NoError(t, err)
+
+	archive.fail = true
+	err = m.Save()
+	_, failed := perrors.Has(err, perrors.ErrorPerformingRequestErrorCode)
+	assert.True(t, failed)
+	assert.Equal(t, time.Minute, mr.TTL("state_machine:payment"))
+
+	archive.fail = false
+	require.NoError(t, storage.WriteThrough("payment"))
+	require.Len(t, archive.archived, 1)
+	assert.Equal(t, State("paid"), archive.archived[0].CurrentState)
+	assert.Equal(t, int64(2), archive.archived[0].Version)
+}
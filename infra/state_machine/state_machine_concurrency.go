@@ -0,0 +1,114 @@
+package state_machine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+const defaultTriggerAndSaveAttempts = 3
+
+type TriggerAndSaveConfiguration struct {
+	MaxAttempts   int               `json:"max_attempts"`    // Trigger and Save attempts on version conflict; 3 if not set
+	LockDuration  coretime.Duration `json:"lock_duration"`   // locker default expiration if not set
+	LockKeyPrefix string            `json:"lock_key_prefix"` // prefix of the lock key, followed by the machine ID
+}
+
+// TriggerAndSave triggers the event and saves the machine; on version conflict the machine is
+// restored from storage and the event triggered again, up to MaxAttempts.
+// With a locker, the machine is restored and changed while holding a lock on its ID, serializing
+// processes that share the locker; version conflicts still protect writers that don't lock.
+// Guards and actions run once per attempt.
+func TriggerAndSave(
+	ctx context.Context,
+	machine *Machine,
+	event Event,
+	payload any,
+	config TriggerAndSaveConfiguration,
+	withLocker ...cache.SharedLocker,
+) (State, error) {
+	state, _, err := triggerAndSave(ctx, machine, event, payload, config, nil, withLocker...)
+	return state, err
+}
+
+// triggerAndSave TriggerAndSave skipping the trigger, returning false, when the restored machine
+// doesn't meet the precondition
+func triggerAndSave(
+	ctx context.Context,
+	machine *Machine,
+	event Event,
+	payload any,
+	config TriggerAndSaveConfiguration,
+	precondition func(*Machine) bool,
+	withLocker ...cache.SharedLocker,
+) (State, bool, error) {
+	log := pixiecontext.GetCtxLogger(ctx).With("machine_id", machine.ID()).With("event", event)
+
+	attempts := config.MaxAttempts
+	if attempts <= 0 {
+		attempts = defaultTriggerAndSaveAttempts
+	}
+
+	restore := false
+	if len(withLocker) > 0 && withLocker[0] != nil {
+		var lockDuration []time.Duration
+		if config.LockDuration > 0 {
+			lockDuration = append(lockDuration, config.LockDuration.Duration())
+		}
+
+		lock, err := withLocker[0].Lock(ctx, fmt.Sprintf("%s%s", config.LockKeyPrefix, machine.ID()), lockDuration...)
+		if err != nil {
+			return NilState, false, err
+		}
+		defer func() {
+			if unlockErr := lock.Unlock(); unlockErr != nil {
+				log.With("error", unlockErr).Warn("error unlocking state machine")
+			}
+		}()
+
+		// the machine may have changed before the lock was acquired
+		restore = true
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if restore {
+			err := machine.Restore()
+			if err != nil {
+				return NilState, false, err
+			}
+		}
+
+		if precondition != nil && !precondition(machine) {
+			return machine.CurrentState(), false, nil
+		}
+
+		state, err := machine.TriggerWithPayload(ctx, event, payload)
+		if err != nil {
+			return NilState, false, err
+		}
+
+		err = machine.Save()
+		if err == nil {
+			return state, true, nil
+		}
+
+		if _, conflict := errors.Has(err, pixieErrors.StateMachineVersionConflictErrorCode); !conflict {
+			return NilState, false, err
+		}
+
+		log.With("attempt", attempt).Debug("state machine version conflict, reloading")
+		lastErr = err
+		restore = true
+	}
+
+	return NilState, false, errors.NewWithError(lastErr, "unable to save state machine %s after %d attempts", machine.ID(), attempts).
+		WithErrorCode(pixieErrors.StateMachineVersionConflictErrorCode)
+}
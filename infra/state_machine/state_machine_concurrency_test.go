@@ -0,0 +1,146 @@
+package state_machine
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	perrors "github.com/pixie-sh/errors-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+)
+
+// versionedStorage in memory StateMachineStorage with compare-and-swap semantics
+type versionedStorage struct {
+	mu     sync.Mutex
+	models map[string]MachineModel
+}
+
+func newVersionedStorage() *versionedStorage {
+	return &versionedStorage{models: make(map[string]MachineModel)}
+}
+
+func (s *versionedStorage) Store(model MachineModel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.models[model.ID].Version != model.Version {
+		return perrors.New("version conflict").WithErrorCode(pixieErrors.StateMachineVersionConflictErrorCode)
+	}
+
+	model.Version++
+	s.models[model.ID] = model
+	return nil
+}
+
+func (s *versionedStorage) Get(machineID string) (MachineModel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	model, ok := s.models[machineID]
+	if !ok {
+		return MachineModel{}, perrors.New("machine not found").WithErrorCode(perrors.NotFoundErrorCode)
+	}
+	return model, nil
+}
+
+func newCounterMachine(t *testing.T, storage StateMachineStorage) *Machine {
+	m := NewMachine(context.Background(), "counter", storage)
+	m.AddState("one")
+	m.AddState("two")
+	m.AddState("three")
+	require.NoError(t, m.SetInitialState("one"))
+	require.NoError(t, m.AddTransition("one", "next", "two"))
+	require.NoError(t, m.AddTransition("two", "next", "three"))
+	return m
+}
+
+func TestSave_VersionConflict(t *testing.T) {
+	storage := newVersionedStorage()
+	require.NoError(t, newCounterMachine(t, storage).Save())
+
+	first := NewMachine(context.Background(), "counter", storage)
+	require.NoError(t, first.Restore())
+	second := NewMachine(context.Background(), "counter", storage)
+	require.NoError(t, second.Restore())
+
+	_, err := first.Trigger(context.Background(), "next")
+	require.NoError(t, err)
+	require.NoError(t, first.Save())
+	assert.Equal(t, int64(2), first.Version())
+
+	_, err = second.Trigger(context.Background(), "next")
+	require.NoError(t, err)
+	err = second.Save()
+	_, conflict := perrors.Has(err, pixieErrors.StateMachineVersionConflictErrorCode)
+	assert.True(t, conflict)
+}
+
+func TestTriggerAndSave_RetriesOnConflict(t *testing.T) {
+	ctx := context.Background()
+	storage := newVersionedStorage()
+	require.NoError(t, newCounterMachine(t, storage).Save())
+
+	stale := NewMachine(ctx, "counter", storage)
+	require.NoError(t, stale.Restore())
+
+	concurrent := NewMachine(ctx, "counter", storage)
+	require.NoError(t, concurrent.Restore())
+	_, err := TriggerAndSave(ctx, concurrent, "next", nil, TriggerAndSaveConfiguration{})
+	require.NoError(t, err)
+
+	state, err := TriggerAndSave(ctx, stale, "next", nil, TriggerAndSaveConfiguration{})
+	require.NoError(t, err)
+	assert.Equal(t, State("three"), state)
+
+	persisted, err := storage.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, State("three"), persisted.CurrentState)
+	assert.Equal(t, int64(3), persisted.Version)
+}
+
+func TestTriggerAndSave_WithLocker(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	locker, err := cache.NewRedisLock(context.Background(), redis.NewClient(&redis.Options{Addr: mr.Addr()}), cache.RedisLockConfiguration{
+		DefaultExpiration: time.Second,
+		DefaultRetryDelay: 10 * time.Millisecond,
+		MaxRetries:        100,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	storage := newVersionedStorage()
+	require.NoError(t, newCounterMachine(t, storage).Save())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := NewMachine(ctx, "counter", storage)
+			_, err := TriggerAndSave(ctx, m, "next", nil, TriggerAndSaveConfiguration{MaxAttempts: 1, LockKeyPrefix: "sm:"}, locker)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	persisted, err := storage.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, State("three"), persisted.CurrentState)
+	assert.False(t, mr.Exists("sm:counter"))
+}
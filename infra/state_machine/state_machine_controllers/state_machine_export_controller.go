@@ -0,0 +1,72 @@
+package state_machine_controllers
+
+import (
+	"context"
+	goErrors "errors"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/state_machine"
+	"github.com/pixie-sh/core-go/pkg/comm/http"
+	"github.com/pixie-sh/core-go/pkg/errors/db_errors"
+)
+
+const (
+	ExportFormatMermaid = "mermaid"
+	ExportFormatDOT     = "dot"
+	ExportFormatJSON    = "json"
+)
+
+// SetupExportController mounts GET /:machine_id on router, rendering the persisted machine
+// as Mermaid (default), Graphviz DOT or the JSON model according to the format query parameter
+func SetupExportController(_ context.Context, router http.ServerGroup, storage state_machine.StateMachineStorage) error {
+	router.Get("/:machine_id", func(ctx http.ServerCtx) error {
+		machineID := ctx.Params("machine_id")
+		if len(machineID) == 0 {
+			return http.APIError(ctx, errors.New("machine_id is required").WithErrorCode(errors.ErrorPerformingRequestErrorCode))
+		}
+
+		format := ctx.Query("format", ExportFormatMermaid)
+		switch format {
+		case ExportFormatMermaid, ExportFormatDOT, ExportFormatJSON:
+		default:
+			return http.APIError(ctx, errors.New("invalid format '%s'", format).WithErrorCode(errors.ErrorPerformingRequestErrorCode))
+		}
+
+		model, err := storage.Get(machineID)
+		if err != nil {
+			if isNotFound(err) {
+				http.GetCtxLogger(ctx).With("error", err).With("machine_id", machineID).Debug("state machine not found")
+				return http.APIError(ctx, errors.New("state machine %s not found", machineID).WithErrorCode(errors.NotFoundErrorCode))
+			}
+
+			// storage errors may carry connection or query details, only the cause log has them
+			http.GetCtxLogger(ctx).With("error", err).With("machine_id", machineID).Error("error getting state machine")
+			return http.APIError(ctx, errors.New("error getting state machine %s", machineID).WithErrorCode(errors.ErrorPerformingRequestErrorCode))
+		}
+
+		switch format {
+		case ExportFormatDOT:
+			ctx.Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			return ctx.SendString(model.DOT())
+		case ExportFormatJSON:
+			return http.APIResponse(ctx, model)
+		default:
+			ctx.Set("Content-Type", "text/plain; charset=utf-8")
+			return ctx.SendString(model.Mermaid())
+		}
+	})
+
+	return nil
+}
+
+// isNotFound storages report missing machines either with a not found code or the raw gorm error
+func isNotFound(err error) bool {
+	if _, ok := errors.Has(err, errors.NotFoundErrorCode); ok {
+		return true
+	}
+	if _, ok := errors.Has(err, errors.EntityNotFoundErrorCode); ok {
+		return true
+	}
+	return goErrors.Is(err, db_errors.DBEntityNotFound)
+}
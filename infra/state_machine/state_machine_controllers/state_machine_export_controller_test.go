@@ -0,0 +1,93 @@
+package state_machine_controllers
+
+import (
+	"context"
+	goErrors "errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	perrors "github.com/pixie-sh/errors-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pixie-sh/core-go/infra/state_machine"
+	"github.com/pixie-sh/core-go/pkg/comm/http"
+)
+
+type mapStorage map[string]state_machine.MachineModel
+
+func (s mapStorage) Store(model state_machine.MachineModel) error {
+	s[model.ID] = model
+	return nil
+}
+
+func (s mapStorage) Get(machineID string) (state_machine.MachineModel, error) {
+	if machineID == "unavailable" {
+		return state_machine.MachineModel{}, perrors.New("connection refused").WithErrorCode(perrors.ErrorPerformingRequestErrorCode)
+	}
+
+	model, ok := s[machineID]
+	if !ok {
+		return model, perrors.New("not found").WithErrorCode(perrors.NotFoundErrorCode)
+	}
+	return model, nil
+}
+
+func TestSetupExportController(t *testing.T) {
+	ctx := context.Background()
+	storage := mapStorage{}
+
+	m := state_machine.NewMachine(ctx, "order-1", storage)
+	m.AddState("new")
+	m.AddState("paid")
+	require.NoError(t, m.SetInitialState("new"))
+	require.NoError(t, m.AddTransition("new", "pay", "paid"))
+	require.NoError(t, m.Save())
+
+	app := fiber.New(fiber.Config(http.DefaultServerConfiguration))
+	require.NoError(t, SetupExportController(ctx, app.Group("/state-machines"), storage))
+
+	get := func(target string) (int, string) {
+		resp, err := app.Test(httptest.NewRequest("GET", target, nil))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("/state-machines/order-1")
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, "new --> paid: pay")
+
+	status, body = get("/state-machines/order-1?format=dot")
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, `"new" -> "paid" [label="pay"];`)
+
+	status, body = get("/state-machines/order-1?format=json")
+	assert.Equal(t, 200, status)
+	assert.Contains(t, body, `"current_state":"new"`)
+
+	status, _ = get("/state-machines/order-1?format=svg")
+	assert.Equal(t, perrors.ErrorPerformingRequestErrorCode.HTTPError, status)
+
+	status, _ = get("/state-machines/unknown")
+	assert.Equal(t, perrors.NotFoundErrorCode.HTTPError, status)
+
+	// storage failures aren't reported as missing machines nor leak their cause
+	status, body = get("/state-machines/unavailable")
+	assert.Equal(t, perrors.ErrorPerformingRequestErrorCode.HTTPError, status)
+	assert.Contains(t, body, "error getting state machine unavailable")
+	assert.NotContains(t, body, "connection refused")
+}
+
+func TestIsNotFound(t *testing.T) {
+	assert.True(t, isNotFound(perrors.New("missing").WithErrorCode(perrors.NotFoundErrorCode)))
+	assert.True(t, isNotFound(perrors.New("missing").WithErrorCode(perrors.EntityNotFoundErrorCode)))
+	assert.True(t, isNotFound(gorm.ErrRecordNotFound))
+	assert.False(t, isNotFound(goErrors.New("connection refused")))
+}
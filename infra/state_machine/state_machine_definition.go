@@ -0,0 +1,584 @@
+package state_machine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/pkg/configuration"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+	"github.com/pixie-sh/core-go/pkg/types/slices"
+)
+
+// UndefinedVersion version of machines created without Definition; migrations may start from it
+const UndefinedVersion = 0
+
+type TransitionDefinition struct {
+	From  State `json:"from" toml:"from"`
+	Event Event `json:"event" toml:"event"`
+	To    State `json:"to" toml:"to"`
+}
+
+// SubStatesDefinition nests States in Parent, entering Initial or, if Parallel, every sub state as a region
+type SubStatesDefinition struct {
+	Parent   State   `json:"parent" toml:"parent"`
+	States   []State `json:"states" toml:"states"`
+	Initial  State   `json:"initial" toml:"initial"`
+	Parallel bool    `json:"parallel" toml:"parallel"`
+}
+
+// TimeoutDefinition fires Event once the machine stays in State for After
+type TimeoutDefinition struct {
+	State State             `json:"state" toml:"state"`
+	After coretime.Duration `json:"after" toml:"after"`
+	Event Event             `json:"event" toml:"event"`
+}
+
+type ConditionalTransitionDefinition struct {
+	From     State         `json:"from" toml:"from"`
+	Event    Event         `json:"event" toml:"event"`
+	To       State         `json:"to" toml:"to"`
+	If       ConditionEnum `json:"if" toml:"if"`
+	IfStates []State       `json:"if_states" toml:"if_states"`
+}
+
+// Definition versioned declarative machine definition
+type Definition struct {
+	Name                   string                            `json:"name" toml:"name"`
+	Version                int                               `json:"version" toml:"version"`
+	States                 []State                           `json:"states" toml:"states"`
+	InitialState           State                             `json:"initial_state" toml:"initial_state"`
+	TerminalStates         []State                           `json:"terminal_states" toml:"terminal_states"`
+	Transitions            []TransitionDefinition            `json:"transitions" toml:"transitions"`
+	ConditionalTransitions []ConditionalTransitionDefinition `json:"conditional_transitions" toml:"conditional_transitions"`
+	Timeouts               []TimeoutDefinition               `json:"timeouts" toml:"timeouts"`
+	SubStates              []SubStatesDefinition             `json:"sub_states" toml:"sub_states"`
+}
+
+// DefinitionMigration explicit path of the machines of definition Name from version From to version To
+// states are renamed through StateMapping; states without mapping must exist in To
+type DefinitionMigration struct {
+	Name         string          `json:"name" toml:"name"`
+	From         int             `json:"from" toml:"from"`
+	To           int             `json:"to" toml:"to"`
+	StateMapping map[State]State `json:"state_mapping" toml:"state_mapping"`
+}
+
+// DefinitionsConfiguration definitions and migrations, usually loaded with LoadDefinitions
+type DefinitionsConfiguration struct {
+	Definitions []Definition          `json:"definitions" toml:"definitions"`
+	Migrations  []DefinitionMigration `json:"migrations" toml:"migrations"`
+}
+
+// Validate checks states, transitions and conditions, that every state is reachable from
+// the initial state and that only terminal states have no outgoing transitions
+func (d Definition) Validate() error {
+	var fieldErrors []*errors.FieldError
+	invalid := func(field string, rule string, param string, message string, args ...interface{}) {
+		fieldErrors = append(fieldErrors, &errors.FieldError{
+			Field:   field,
+			Rule:    rule,
+			Param:   param,
+			Message: fmt.Sprintf(message, args...),
+		})
+	}
+
+	if len(d.Name) == 0 {
+		invalid("name", "required", "", "definition name is required")
+	}
+	if d.Version <= UndefinedVersion {
+		invalid("version", "gt", fmt.Sprint(d.Version), "definition version must be greater than %d", UndefinedVersion)
+	}
+
+	states := make(map[State]struct{}, len(d.States))
+	for i, state := range d.States {
+		if _, exists := states[state]; exists || state == NilState {
+			invalid(fmt.Sprintf("states[%d]", i), "unique", state.String(), "states must be unique and not empty")
+		}
+		states[state] = struct{}{}
+	}
+
+	exists := func(field string, state State) bool {
+		if _, ok := states[state]; !ok {
+			invalid(field, "StateMustExist", state.String(), "state '%s' must exist", state)
+			return false
+		}
+		return true
+	}
+
+	exists("initial_state", d.InitialState)
+	terminals := make(map[State]struct{}, len(d.TerminalStates))
+	for i, state := range d.TerminalStates {
+		exists(fmt.Sprintf("terminal_states[%d]", i), state)
+		terminals[state] = struct{}{}
+	}
+
+	edges := make(map[State][]State)
+	for i, transition := range d.Transitions {
+		from := exists(fmt.Sprintf("transitions[%d].from", i), transition.From)
+		to := exists(fmt.Sprintf("transitions[%d].to", i), transition.To)
+		if from && to {
+			edges[transition.From] = append(edges[transition.From], transition.To)
+		}
+	}
+
+	for i, transition := range d.ConditionalTransitions {
+		from := exists(fmt.Sprintf("conditional_transitions[%d].from", i), transition.From)
+		to := exists(fmt.Sprintf("conditional_transitions[%d].to", i), transition.To)
+		if from && to {
+			edges[transition.From] = append(edges[transition.From], transition.To)
+		}
+
+		switch transition.If {
+		case ConditionNeverVisitedOneOf, ConditionIfVisitedOneOf, ConditionNeverVisitedAll, ConditionIfVisitedAll:
+		default:
+			invalid(fmt.Sprintf("conditional_transitions[%d].if", i), "InvalidCondition", transition.If, "invalid condition '%s'", transition.If)
+		}
+
+		if len(transition.IfStates) == 0 {
+			invalid(fmt.Sprintf("conditional_transitions[%d].if_states", i), "required", "", "'if_states' cannot be empty")
+		}
+		for j, state := range transition.IfStates {
+			exists(fmt.Sprintf("conditional_transitions[%d].if_states[%d]", i, j), state)
+		}
+	}
+
+	parents := make(map[State]State)
+	compounds := make(map[State]struct{}, len(d.SubStates))
+	for i, subStates := range d.SubStates {
+		field := fmt.Sprintf("sub_states[%d]", i)
+		if !exists(field+".parent", subStates.Parent) {
+			continue
+		}
+		compounds[subStates.Parent] = struct{}{}
+
+		if len(subStates.States) == 0 {
+			invalid(field+".states", "required", "", "'states' cannot be empty")
+		}
+		for j, state := range subStates.States {
+			if !exists(fmt.Sprintf("%s.states[%d]", field, j), state) {
+				continue
+			}
+			if _, nested := parents[state]; nested || state == subStates.Parent {
+				invalid(fmt.Sprintf("%s.states[%d]", field, j), "unique", state.String(), "state '%s' must have a single parent", state)
+				continue
+			}
+			parents[state] = subStates.Parent
+		}
+
+		if subStates.Parallel {
+			if subStates.Initial != NilState {
+				invalid(field+".initial", "excluded_with", subStates.Initial.String(), "parallel state '%s' enters every sub state", subStates.Parent)
+			}
+		} else if !slices.Contains(subStates.States, subStates.Initial) {
+			invalid(field+".initial", "oneof", subStates.Initial.String(), "initial sub state of '%s' must be one of its states", subStates.Parent)
+		}
+	}
+
+	for state := range parents {
+		for ancestor, nested := parents[state]; nested; ancestor, nested = parents[ancestor] {
+			if ancestor == state {
+				invalid("sub_states", "Cycle", state.String(), "state '%s' is nested in itself", state)
+				break
+			}
+		}
+	}
+
+	// entering a sub state enters its parent, entering a parent enters its initial sub state or regions
+	reachable := make(map[State][]State, len(edges))
+	for state, next := range edges {
+		reachable[state] = slices.Copy(next)
+	}
+	for child, parent := range parents {
+		reachable[child] = append(reachable[child], parent)
+	}
+	for _, subStates := range d.SubStates {
+		if subStates.Parallel {
+			reachable[subStates.Parent] = append(reachable[subStates.Parent], subStates.States...)
+		} else if subStates.Initial != NilState {
+			reachable[subStates.Parent] = append(reachable[subStates.Parent], subStates.Initial)
+		}
+	}
+
+	events := make(map[State]map[Event]struct{})
+	for _, transition := range d.Transitions {
+		if events[transition.From] == nil {
+			events[transition.From] = make(map[Event]struct{})
+		}
+		events[transition.From][transition.Event] = struct{}{}
+	}
+	for _, transition := range d.ConditionalTransitions {
+		if events[transition.From] == nil {
+			events[transition.From] = make(map[Event]struct{})
+		}
+		events[transition.From][transition.Event] = struct{}{}
+	}
+
+	timeouts := make(map[State]struct{}, len(d.Timeouts))
+	for i, timeout := range d.Timeouts {
+		field := fmt.Sprintf("timeouts[%d]", i)
+		if !exists(field+".state", timeout.State) {
+			continue
+		}
+		if _, duplicated := timeouts[timeout.State]; duplicated {
+			invalid(field+".state", "unique", timeout.State.String(), "state '%s' has several timeouts", timeout.State)
+		}
+		timeouts[timeout.State] = struct{}{}
+
+		if timeout.After <= 0 {
+			invalid(field+".after", "gt", timeout.After.String(), "timeout of state '%s' must be positive", timeout.State)
+		}
+		if _, ok := events[timeout.State][timeout.Event]; !ok {
+			invalid(field+".event", "InvalidTransition", timeout.Event.String(), "timeout event '%s' is not a transition of '%s'", timeout.Event, timeout.State)
+		}
+	}
+
+	if len(fieldErrors) == 0 {
+		reached := map[State]struct{}{d.InitialState: {}}
+		pending := []State{d.InitialState}
+		for len(pending) > 0 {
+			state := pending[0]
+			pending = pending[1:]
+			for _, next := range reachable[state] {
+				if _, ok := reached[next]; !ok {
+					reached[next] = struct{}{}
+					pending = append(pending, next)
+				}
+			}
+		}
+
+		for _, state := range d.States {
+			if _, ok := reached[state]; !ok {
+				invalid("states", "Unreachable", state.String(), "state '%s' is not reachable from '%s'", state, d.InitialState)
+			}
+
+			// sub states are left through the transitions of their ancestors
+			handled := len(edges[state]) > 0
+			for ancestor, nested := parents[state]; nested && !handled; ancestor, nested = parents[ancestor] {
+				handled = len(edges[ancestor]) > 0
+			}
+
+			_, terminal := terminals[state]
+			_, compound := compounds[state]
+			if !terminal && !compound && !handled {
+				invalid("states", "DeadEnd", state.String(), "state '%s' has no transitions and is not terminal", state)
+			}
+			if terminal && len(edges[state]) > 0 {
+				invalid("terminal_states", "TerminalWithTransitions", state.String(), "terminal state '%s' has transitions", state)
+			}
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return errors.NewValidationError(fmt.Sprintf("invalid state machine definition '%s' v%d", d.Name, d.Version), fieldErrors...).
+			WithErrorCode(pixieErrors.StateMachineInvalidDefinitionErrorCode)
+	}
+
+	return nil
+}
+
+// NewMachineFromDefinition creates a machine with the definition states and transitions, at the initial state
+func NewMachineFromDefinition(
+	ctx context.Context,
+	machineID string,
+	definition Definition,
+	storage StateMachineStorage,
+	withStateMachineEntityStorage ...StateMachineEntityStorage,
+) (*Machine, error) {
+	err := definition.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMachine(ctx, machineID, storage, withStateMachineEntityStorage...)
+	m.mu.Lock()
+	m.applyDefinitionUnlocked(definition)
+	m.mu.Unlock()
+
+	return m, m.SetInitialState(definition.InitialState)
+}
+
+// applyDefinitionUnlocked replaces states, transitions and definition metadata; current and visited states are kept
+func (m *Machine) applyDefinitionUnlocked(definition Definition) {
+	m.states = make(map[State]struct{}, len(definition.States))
+	for _, state := range definition.States {
+		m.states[state] = struct{}{}
+	}
+
+	m.transitions = make(Transitions)
+	for _, transition := range definition.Transitions {
+		if m.transitions[transition.From] == nil {
+			m.transitions[transition.From] = make(map[Event]State)
+		}
+		m.transitions[transition.From][transition.Event] = transition.To
+	}
+
+	m.conditionalsTransitions = make(ConditionalTransitions)
+	for _, transition := range definition.ConditionalTransitions {
+		if m.conditionalsTransitions[transition.From] == nil {
+			m.conditionalsTransitions[transition.From] = make(map[Event][]Condition)
+		}
+
+		toSort := append(m.conditionalsTransitions[transition.From][transition.Event], Condition{
+			To:       transition.To,
+			If:       transition.If,
+			IfStates: transition.IfStates,
+		})
+		sort.SliceStable(toSort, func(i, j int) bool {
+			return len(toSort[i].IfStates) < len(toSort[j].IfStates)
+		})
+		m.conditionalsTransitions[transition.From][transition.Event] = toSort
+	}
+
+	m.timeouts = make(map[State]StateTimeout, len(definition.Timeouts))
+	for _, timeout := range definition.Timeouts {
+		m.timeouts[timeout.State] = StateTimeout{After: timeout.After, Event: timeout.Event}
+	}
+
+	m.parents = make(map[State]State)
+	m.children = make(map[State][]State)
+	m.initials = make(map[State]State)
+	m.parallel = make(map[State]struct{})
+	for _, subStates := range definition.SubStates {
+		for _, state := range subStates.States {
+			_ = m.addSubStateUnlocked(subStates.Parent, state)
+		}
+		if subStates.Parallel {
+			m.parallel[subStates.Parent] = struct{}{}
+		} else {
+			m.initials[subStates.Parent] = subStates.Initial
+		}
+	}
+
+	m.terminalStates = slices.Copy(definition.TerminalStates)
+	m.definitionName = definition.Name
+	m.definitionVersion = definition.Version
+}
+
+// DefinitionRegistry keeps the versions of each definition and the migrations between them
+type DefinitionRegistry struct {
+	mu          sync.RWMutex
+	definitions map[string]map[int]Definition
+	migrations  map[string]map[int]DefinitionMigration
+}
+
+// NewDefinitionRegistry registers the configuration definitions and migrations
+func NewDefinitionRegistry(_ context.Context, config DefinitionsConfiguration) (*DefinitionRegistry, error) {
+	r := &DefinitionRegistry{
+		definitions: make(map[string]map[int]Definition),
+		migrations:  make(map[string]map[int]DefinitionMigration),
+	}
+
+	for _, definition := range config.Definitions {
+		err := r.Register(definition)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, migration := range config.Migrations {
+		err := r.AddMigration(migration)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// LoadDefinitions loads a DefinitionsConfiguration json or toml file through pkg/configuration
+func LoadDefinitions(ctx context.Context, filePath string) (*DefinitionRegistry, error) {
+	var config DefinitionsConfiguration
+	_, err := configuration.StructFromFileWithEnvReplace(filePath, &config, pixiecontext.GetCtxLogger(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDefinitionRegistry(ctx, config)
+}
+
+// Register validates and adds the definition; a registered name and version can't be replaced
+func (r *DefinitionRegistry) Register(definition Definition) error {
+	err := definition.Validate()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.definitions[definition.Name][definition.Version]; ok {
+		return errors.New("definition '%s' v%d already registered", definition.Name, definition.Version).
+			WithErrorCode(pixieErrors.StateMachineInvalidDefinitionErrorCode)
+	}
+
+	if r.definitions[definition.Name] == nil {
+		r.definitions[definition.Name] = make(map[int]Definition)
+	}
+	r.definitions[definition.Name][definition.Version] = definition
+	return nil
+}
+
+// AddMigration adds a migration between registered versions, From may be UndefinedVersion;
+// mapped states must exist in the respective definitions
+func (r *DefinitionRegistry) AddMigration(migration DefinitionMigration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	invalid := func(format string, args ...interface{}) error {
+		return errors.New(format, args...).WithErrorCode(pixieErrors.StateMachineInvalidDefinitionErrorCode)
+	}
+
+	if migration.To <= migration.From {
+		return invalid("migration of '%s' must move to a newer version; from v%d to v%d", migration.Name, migration.From, migration.To)
+	}
+
+	to, ok := r.definitions[migration.Name][migration.To]
+	if !ok {
+		return invalid("migration target '%s' v%d not registered", migration.Name, migration.To)
+	}
+
+	from, ok := r.definitions[migration.Name][migration.From]
+	if !ok && migration.From != UndefinedVersion {
+		return invalid("migration source '%s' v%d not registered", migration.Name, migration.From)
+	}
+
+	for fromState, toState := range migration.StateMapping {
+		if migration.From != UndefinedVersion && !slices.Contains(from.States, fromState) {
+			return invalid("mapped state '%s' does not exist in '%s' v%d", fromState, migration.Name, migration.From)
+		}
+		if !slices.Contains(to.States, toState) {
+			return invalid("mapped state '%s' does not exist in '%s' v%d", toState, migration.Name, migration.To)
+		}
+	}
+
+	if r.migrations[migration.Name] == nil {
+		r.migrations[migration.Name] = make(map[int]DefinitionMigration)
+	}
+	r.migrations[migration.Name][migration.From] = migration
+	return nil
+}
+
+// Get returns the definition version
+func (r *DefinitionRegistry) Get(name string, version int) (Definition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definition, ok := r.definitions[name][version]
+	return definition, ok
+}
+
+// Latest returns the highest version of the definition
+func (r *DefinitionRegistry) Latest(name string) (Definition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var (
+		latest Definition
+		found  bool
+	)
+	for version, definition := range r.definitions[name] {
+		if !found || version > latest.Version {
+			latest = definition
+			found = true
+		}
+	}
+
+	return latest, found
+}
+
+// NewMachine creates a machine from the latest version of the definition
+func (r *DefinitionRegistry) NewMachine(
+	ctx context.Context,
+	name string,
+	machineID string,
+	storage StateMachineStorage,
+	withStateMachineEntityStorage ...StateMachineEntityStorage,
+) (*Machine, error) {
+	definition, ok := r.Latest(name)
+	if !ok {
+		return nil, errors.New("definition '%s' not registered", name).WithErrorCode(errors.NotFoundErrorCode)
+	}
+
+	return NewMachineFromDefinition(ctx, machineID, definition, storage, withStateMachineEntityStorage...)
+}
+
+// Migrate moves a restored machine to the latest version of the definition name following the
+// registered migrations; machines without definition start at UndefinedVersion.
+// Nothing changes if any step is missing or the resulting states don't exist; returns whether the machine changed
+func (r *DefinitionRegistry) Migrate(ctx context.Context, name string, machine *Machine) (bool, error) {
+	latest, ok := r.Latest(name)
+	if !ok {
+		return false, errors.New("definition '%s' not registered", name).WithErrorCode(errors.NotFoundErrorCode)
+	}
+
+	machine.mu.Lock()
+	defer machine.mu.Unlock()
+
+	if len(machine.definitionName) > 0 && machine.definitionName != name {
+		return false, errors.New("machine %s has definition '%s', not '%s'", machine.id, machine.definitionName, name).
+			WithErrorCode(pixieErrors.StateMachineInvalidDefinitionErrorCode)
+	}
+
+	if machine.definitionVersion >= latest.Version {
+		return false, nil
+	}
+
+	var (
+		version       = machine.definitionVersion
+		currentState  = machine.currentState
+		activeStates  = slices.Copy(machine.active)
+		visitedStates = slices.Copy(machine.visitedStates)
+	)
+
+	r.mu.RLock()
+	for version < latest.Version {
+		migration, found := r.migrations[name][version]
+		if !found {
+			r.mu.RUnlock()
+			return false, errors.New("no migration of '%s' from v%d", name, version).
+				WithErrorCode(pixieErrors.StateMachineInvalidDefinitionErrorCode)
+		}
+
+		if mapped, isMapped := migration.StateMapping[currentState]; isMapped {
+			currentState = mapped
+		}
+		for i, active := range activeStates {
+			if mapped, isMapped := migration.StateMapping[active]; isMapped {
+				activeStates[i] = mapped
+			}
+		}
+		for i, visited := range visitedStates {
+			if mapped, isMapped := migration.StateMapping[visited.State]; isMapped {
+				visitedStates[i].State = mapped
+			}
+		}
+
+		version = migration.To
+	}
+	r.mu.RUnlock()
+
+	for _, state := range append([]State{currentState}, activeStates...) {
+		if !slices.Contains(latest.States, state) {
+			return false, errors.New("state '%s' of machine %s does not exist in '%s' v%d", state, machine.id, name, latest.Version).
+				WithErrorCode(pixieErrors.StateMachineInvalidDefinitionErrorCode)
+		}
+	}
+
+	pixiecontext.GetCtxLogger(ctx).
+		With("machine_id", machine.id).
+		With("from_version", machine.definitionVersion).
+		With("to_version", latest.Version).
+		Debug("migrating state machine definition '%s'", name)
+
+	machine.applyDefinitionUnlocked(latest)
+	machine.currentState = currentState
+	machine.active = activeStates
+	machine.visitedStates = visitedStates
+	return true, nil
+}
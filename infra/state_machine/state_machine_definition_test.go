@@ -0,0 +1,197 @@
+package state_machine
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	perrors "github.com/pixie-sh/errors-go"
+	"github.com/pixie-sh/logger-go/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/pkg/configuration"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+)
+
+const orderDefinitionsJSON = `{
+  "definitions": [
+    {
+      "name": "order",
+      "version": 1,
+      "states": ["new", "paid", "shipped"],
+      "initial_state": "new",
+      "terminal_states": ["shipped"],
+      "transitions": [
+        {"from": "new", "event": "pay", "to": "paid"},
+        {"from": "paid", "event": "ship", "to": "shipped"}
+      ]
+    },
+    {
+      "name": "order",
+      "version": 2,
+      "states": ["created", "paid", "packed", "shipped"],
+      "initial_state": "created",
+      "terminal_states": ["shipped"],
+      "transitions": [
+        {"from": "created", "event": "pay", "to": "paid"},
+        {"from": "paid", "event": "pack", "to": "packed"},
+        {"from": "packed", "event": "ship", "to": "shipped"}
+      ]
+    }
+  ],
+  "migrations": [
+    {"name": "order", "from": 1, "to": 2, "state_mapping": {"new": "created"}}
+  ]
+}`
+
+const orderDefinitionTOML = `
+name = "order"
+version = 1
+states = ["new", "paid", "shipped"]
+initial_state = "new"
+terminal_states = ["shipped"]
+
+[[transitions]]
+from = "new"
+event = "pay"
+to = "paid"
+
+[[transitions]]
+from = "paid"
+event = "ship"
+to = "shipped"
+
+[[conditional_transitions]]
+from = "new"
+event = "skip"
+to = "shipped"
+if = "if_visited_one_of"
+if_states = ["paid"]
+`
+
+func TestLoadDefinitions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "machines.json")
+	require.NoError(t, os.WriteFile(path, []byte(orderDefinitionsJSON), 0o600))
+
+	registry, err := LoadDefinitions(context.Background(), path)
+	require.NoError(t, err)
+
+	latest, ok := registry.Latest("order")
+	require.True(t, ok)
+	assert.Equal(t, 2, latest.Version)
+
+	m, err := registry.NewMachine(context.Background(), "order", "order-1", &MockStorage{t: t})
+	require.NoError(t, err)
+	assert.Equal(t, State("created"), m.CurrentState())
+
+	name, version := m.Definition()
+	assert.Equal(t, "order", name)
+	assert.Equal(t, 2, version)
+}
+
+func TestDefinitionFromTOML(t *testing.T) {
+	var definition Definition
+	_, err := configuration.StructFromTOMLBytesWithEnvReplace([]byte(orderDefinitionTOML), &definition, logger.Logger)
+	require.NoError(t, err)
+	require.NoError(t, definition.Validate())
+
+	m, err := NewMachineFromDefinition(context.Background(), "order-1", definition, &MockStorage{t: t})
+	require.NoError(t, err)
+
+	_, err = m.Trigger(context.Background(), "pay")
+	require.NoError(t, err)
+	assert.False(t, m.IsTerminal())
+
+	_, err = m.Trigger(context.Background(), "ship")
+	require.NoError(t, err)
+	assert.True(t, m.IsTerminal())
+}
+
+func TestDefinitionValidate(t *testing.T) {
+	definition := Definition{
+		Name:           "broken",
+		Version:        1,
+		States:         []State{"a", "b", "c", "d"},
+		InitialState:   "a",
+		TerminalStates: []State{"c"},
+		Transitions: []TransitionDefinition{
+			{From: "a", Event: "go", To: "b"},
+			{From: "d", Event: "go", To: "c"},
+		},
+	}
+
+	err := definition.Validate()
+	require.Error(t, err)
+
+	castedErr, ok := perrors.As(err)
+	require.True(t, ok)
+	assert.Equal(t, pixieErrors.StateMachineInvalidDefinitionErrorCode.Value, castedErr.Code.Value)
+
+	rules := map[string]string{}
+	for _, fieldErr := range castedErr.FieldErrors {
+		rules[fieldErr.Param+":"+fieldErr.Rule] = fieldErr.Message
+	}
+	assert.Contains(t, rules, "b:DeadEnd")
+	assert.Contains(t, rules, "c:Unreachable")
+	assert.Contains(t, rules, "d:Unreachable")
+}
+
+func TestDefinitionRegistry_Migrate(t *testing.T) {
+	ctx := context.Background()
+	var config DefinitionsConfiguration
+	_, err := configuration.StructFromJSONBytesWithEnvReplace([]byte(orderDefinitionsJSON), &config, logger.Logger)
+	require.NoError(t, err)
+
+	registry, err := NewDefinitionRegistry(ctx, config)
+	require.NoError(t, err)
+
+	v1, ok := registry.Get("order", 1)
+	require.True(t, ok)
+
+	storage := &MockStorage{t: t}
+	m, err := NewMachineFromDefinition(ctx, "order-1", v1, storage)
+	require.NoError(t, err)
+	require.NoError(t, m.Save())
+
+	restored := NewMachine(ctx, "order-1", storage)
+	require.NoError(t, restored.Restore())
+
+	migrated, err := registry.Migrate(ctx, "order", restored)
+	require.NoError(t, err)
+	assert.True(t, migrated)
+	assert.Equal(t, State("created"), restored.CurrentState())
+
+	_, version := restored.Definition()
+	assert.Equal(t, 2, version)
+
+	_, err = restored.Trigger(ctx, "pay")
+	require.NoError(t, err)
+	_, err = restored.Trigger(ctx, "pack")
+	assert.NoError(t, err)
+
+	migrated, err = registry.Migrate(ctx, "order", restored)
+	assert.NoError(t, err)
+	assert.False(t, migrated)
+}
+
+func TestDefinitionRegistry_MigrateWithoutPath(t *testing.T) {
+	ctx := context.Background()
+	var config DefinitionsConfiguration
+	_, err := configuration.StructFromJSONBytesWithEnvReplace([]byte(orderDefinitionsJSON), &config, logger.Logger)
+	require.NoError(t, err)
+	config.Migrations = nil
+
+	registry, err := NewDefinitionRegistry(ctx, config)
+	require.NoError(t, err)
+
+	v1, _ := registry.Get("order", 1)
+	m, err := NewMachineFromDefinition(ctx, "order-1", v1, &MockStorage{t: t})
+	require.NoError(t, err)
+
+	migrated, err := registry.Migrate(ctx, "order", m)
+	assert.Error(t, err)
+	assert.False(t, migrated)
+	assert.Equal(t, State("new"), m.CurrentState())
+}
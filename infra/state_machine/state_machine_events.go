@@ -0,0 +1,136 @@
+package state_machine
+
+import (
+	"context"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/events"
+	"github.com/pixie-sh/core-go/infra/message_factory"
+	"github.com/pixie-sh/core-go/infra/uidgen"
+	"github.com/pixie-sh/core-go/pkg/types"
+	"github.com/pixie-sh/core-go/pkg/uid"
+)
+
+// TransitionEventsPackName name of the message_factory pack with the state machine events
+const TransitionEventsPackName = "state_machine_events"
+
+// TransitionEvent emitted for each transition of machines with transition events enabled
+type TransitionEvent struct {
+	MachineID      string    `json:"machine_id"`
+	DefinitionName string    `json:"definition_name,omitempty"`
+	From           State     `json:"from"`
+	Event          Event     `json:"event"`
+	To             State     `json:"to"`
+	ActorID        string    `json:"actor_id,omitempty"`
+	At             time.Time `json:"at"`
+}
+
+// TransitionEventsPack message_factory pack consumers register to decode TransitionEvent
+var TransitionEventsPack = message_factory.NewPack(TransitionEventsPackName, message_factory.PackEntry[TransitionEvent]())
+
+// TransitionEventsStorage implemented by storages able to persist the machine and its transition events
+// in the same transaction (eg: outbox table), events are published afterwards by the storage relay
+type TransitionEventsStorage interface {
+	StoreWithEvents(model MachineModel, events ...events.UntypedEventWrapper) error
+}
+
+// WithTransitionEvents enables a TransitionEvent per transition, emitted on Save.
+// If the storage implements TransitionEventsStorage the events are stored with the machine and the
+// producers are ignored; otherwise they are produced after a successful Store, through the producers
+// or the events default producers when none is provided
+func (m *Machine) WithTransitionEvents(producers ...events.Producer) *Machine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.emitTransitions = true
+	m.transitionProducers = producers
+	return m
+}
+
+// PendingTransitionEvents events recorded since the last Save or Restore
+func (m *Machine) PendingTransitionEvents() []TransitionEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := make([]TransitionEvent, len(m.pendingEvents))
+	for i, wrapper := range m.pendingEvents {
+		pending[i] = wrapper.Payload.(TransitionEvent)
+	}
+	return pending
+}
+
+// recordTransitionEventUnlocked records the transition event if enabled
+func (m *Machine) recordTransitionEventUnlocked(record TransitionRecord) {
+	if !m.emitTransitions {
+		return
+	}
+
+	wrapper := events.NewUntypedEventWrapper(
+		uid.NewUUID(),
+		uidgen.SystemUUID,
+		record.At,
+		types.PayloadTypeOf[TransitionEvent]().String(),
+		TransitionEvent{
+			MachineID:      record.MachineID,
+			DefinitionName: record.DefinitionName,
+			From:           record.From,
+			Event:          record.Event,
+			To:             record.To,
+			ActorID:        record.ActorID,
+			At:             record.At,
+		},
+	)
+	m.pendingEvents = append(m.pendingEvents, wrapper)
+}
+
+// storeWithEvents stores the model and emits the pending events, returns whether the model was stored;
+// events are kept pending if the model is not stored
+func (m *Machine) storeWithEvents(model MachineModel, pending []events.UntypedEventWrapper, producers []events.Producer) (bool, error) {
+	if len(pending) == 0 {
+		err := m.storage.Store(model)
+		return err == nil, err
+	}
+
+	requeue := func(err error) (bool, error) {
+		m.mu.Lock()
+		m.pendingEvents = append(pending, m.pendingEvents...)
+		m.mu.Unlock()
+		return false, err
+	}
+
+	if storage, ok := m.storage.(TransitionEventsStorage); ok {
+		err := storage.StoreWithEvents(model, pending...)
+		if err != nil {
+			return requeue(err)
+		}
+		return true, nil
+	}
+
+	err := m.storage.Store(model)
+	if err != nil {
+		return requeue(err)
+	}
+
+	ctx := context.Background()
+	if len(producers) == 0 {
+		return true, events.Emit(ctx, pending...)
+	}
+
+	var errorList []error
+	for _, producer := range producers {
+		err = producer.ProduceBatch(ctx, pending...)
+		if err != nil {
+			errorList = append(errorList, err)
+		}
+	}
+
+	if len(errorList) > 0 {
+		return true, errors.New("machine %s stored but transition events not produced", model.ID).
+			WithErrorCode(errors.ProducerErrorCode).
+			WithNestedError(errorList...)
+	}
+
+	return true, nil
+}
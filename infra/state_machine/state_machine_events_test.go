@@ -0,0 +1,127 @@
+package state_machine
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	perrors "github.com/pixie-sh/errors-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/events"
+	"github.com/pixie-sh/core-go/infra/message_factory"
+	"github.com/pixie-sh/core-go/pkg/types"
+)
+
+type recordingProducer struct {
+	mu       sync.Mutex
+	produced []events.UntypedEventWrapper
+}
+
+func (p *recordingProducer) ID() string { return "recording" }
+
+func (p *recordingProducer) ProduceBatch(_ context.Context, wrappers ...events.UntypedEventWrapper) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.produced = append(p.produced, wrappers...)
+	return nil
+}
+
+func (p *recordingProducer) Produce(ctx context.Context, wrapper events.UntypedEventWrapper) error {
+	return p.ProduceBatch(ctx, wrapper)
+}
+
+type failingStorage struct {
+	*versionedStorage
+	fail bool
+}
+
+func (s *failingStorage) Store(model MachineModel) error {
+	if s.fail {
+		return perrors.New("store failed")
+	}
+	return s.versionedStorage.Store(model)
+}
+
+type outboxStorage struct {
+	*versionedStorage
+	outbox []events.UntypedEventWrapper
+}
+
+func (s *outboxStorage) StoreWithEvents(model MachineModel, evs ...events.UntypedEventWrapper) error {
+	err := s.Store(model)
+	if err != nil {
+		return err
+	}
+	s.outbox = append(s.outbox, evs...)
+	return nil
+}
+
+func TestTransitionEventsPack(t *testing.T) {
+	factory := message_factory.NewFactory()
+	message_factory.RegisterPack(TransitionEventsPack, factory)
+	assert.True(t, factory.Exists(types.PayloadTypeOf[TransitionEvent]()))
+}
+
+func TestTransitionEvents_ProducedOnSave(t *testing.T) {
+	ctx := context.Background()
+	producer := &recordingProducer{}
+	m := newCounterMachine(t, newVersionedStorage()).WithTransitionEvents(producer)
+
+	_, err := m.Trigger(ctx, "next")
+	require.NoError(t, err)
+	_, err = m.Trigger(ctx, "next")
+	require.NoError(t, err)
+
+	pending := m.PendingTransitionEvents()
+	require.Len(t, pending, 2)
+	assert.Equal(t, State("one"), pending[0].From)
+	assert.Equal(t, State("three"), pending[1].To)
+	assert.Empty(t, producer.produced)
+
+	require.NoError(t, m.Save())
+	assert.Empty(t, m.PendingTransitionEvents())
+	require.Len(t, producer.produced, 2)
+	assert.Equal(t, types.PayloadTypeOf[TransitionEvent]().String(), producer.produced[0].PayloadType)
+}
+
+func TestTransitionEvents_KeptPendingOnStoreFailure(t *testing.T) {
+	ctx := context.Background()
+	producer := &recordingProducer{}
+	storage := &failingStorage{versionedStorage: newVersionedStorage()}
+	m := newCounterMachine(t, storage).WithTransitionEvents(producer)
+
+	_, err := m.Trigger(ctx, "next")
+	require.NoError(t, err)
+
+	storage.fail = true
+	assert.Error(t, m.Save())
+	assert.Len(t, m.PendingTransitionEvents(), 1)
+	assert.Empty(t, producer.produced)
+
+	storage.fail = false
+	require.NoError(t, m.Save())
+	assert.Len(t, producer.produced, 1)
+}
+
+func TestTransitionEvents_StoredWithMachine(t *testing.T) {
+	ctx := context.Background()
+	producer := &recordingProducer{}
+	storage := &outboxStorage{versionedStorage: newVersionedStorage()}
+	m := newCounterMachine(t, storage).WithTransitionEvents(producer)
+
+	_, err := m.Trigger(ctx, "next")
+	require.NoError(t, err)
+	require.NoError(t, m.Save())
+
+	assert.Len(t, storage.outbox, 1)
+	assert.Empty(t, producer.produced)
+}
+
+func TestTransitionEvents_DisabledByDefault(t *testing.T) {
+	m := newCounterMachine(t, newVersionedStorage())
+	_, err := m.Trigger(context.Background(), "next")
+	require.NoError(t, err)
+	assert.Empty(t, m.PendingTransitionEvents())
+}
@@ -0,0 +1,278 @@
+package state_machine
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pixie-sh/core-go/pkg/types/maps"
+	"github.com/pixie-sh/core-go/pkg/types/slices"
+)
+
+const (
+	exportCurrentColor = "#f6c744"
+	exportVisitedColor = "#cfe8cf"
+)
+
+var mermaidInvalidIDChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// Model snapshot of the machine as persisted by Save
+func (m *Machine) Model() MachineModel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.modelUnlocked()
+}
+
+// DOT Graphviz representation of the machine, see MachineModel.DOT
+func (m *Machine) DOT() string {
+	return m.Model().DOT()
+}
+
+// Mermaid Mermaid state diagram of the machine, see MachineModel.Mermaid
+func (m *Machine) Mermaid() string {
+	return m.Model().Mermaid()
+}
+
+type exportEdge struct {
+	from        State
+	to          State
+	label       string
+	conditional bool
+}
+
+type exportView struct {
+	model    MachineModel
+	states   []State
+	children map[State][]State
+	active   map[State]struct{}
+	visited  map[State]struct{}
+	edges    []exportEdge
+}
+
+func newExportView(model MachineModel) exportView {
+	v := exportView{
+		model:    model,
+		states:   slices.Copy(model.States),
+		children: make(map[State][]State),
+		active:   make(map[State]struct{}),
+		visited:  make(map[State]struct{}),
+	}
+	sort.SliceStable(v.states, func(i, j int) bool { return v.states[i] < v.states[j] })
+
+	for _, child := range maps.MapKeys(model.Parents) {
+		parent := model.Parents[child]
+		v.children[parent] = append(v.children[parent], child)
+	}
+	for parent := range v.children {
+		sort.SliceStable(v.children[parent], func(i, j int) bool { return v.children[parent][i] < v.children[parent][j] })
+	}
+
+	active := model.ActiveStates
+	if len(active) == 0 && model.CurrentState != NilState {
+		active = []State{model.CurrentState}
+	}
+	for _, state := range active {
+		v.active[state] = struct{}{}
+	}
+	for _, visited := range model.VisitedStates {
+		v.visited[visited.State] = struct{}{}
+	}
+
+	for _, from := range v.states {
+		conditionals := model.ConditionalTransitions[from]
+		for _, event := range sortedEvents(conditionals) {
+			for _, condition := range conditionals[event] {
+				ifStates := slices.Map(condition.IfStates, func(state State) string { return state.String() })
+				v.edges = append(v.edges, exportEdge{
+					from:        from,
+					to:          condition.To,
+					label:       fmt.Sprintf("%s [%s: %s]", event, condition.If, strings.Join(ifStates, ", ")),
+					conditional: true,
+				})
+			}
+		}
+
+		transitions := model.Transitions[from]
+		for _, event := range sortedEvents(transitions) {
+			v.edges = append(v.edges, exportEdge{from: from, to: transitions[event], label: event.String()})
+		}
+	}
+
+	return v
+}
+
+func sortedEvents[V any](byEvent map[Event]V) []Event {
+	events := maps.MapKeys(byEvent)
+	sort.SliceStable(events, func(i, j int) bool { return events[i] < events[j] })
+	return events
+}
+
+// DOT Graphviz representation; conditional transitions are dashed and labeled with their condition,
+// the current states are highlighted, visited states filled and terminal states double bordered.
+// Sub states are drawn in clusters of their parent, dashed for parallel states
+func (m MachineModel) DOT() string {
+	v := newExportView(m)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %q {\n", m.ID)
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=rounded];\n")
+
+	var writeState func(state State, indent string)
+	writeState = func(state State, indent string) {
+		children := v.children[state]
+		if len(children) == 0 {
+			fmt.Fprintf(&b, "%s%q%s;\n", indent, state, v.dotAttributes(state))
+			return
+		}
+
+		style := "rounded"
+		if slices.Contains(m.ParallelStates, state) {
+			style = "rounded,dashed"
+		}
+		fmt.Fprintf(&b, "%ssubgraph %q {\n", indent, "cluster_"+state.String())
+		fmt.Fprintf(&b, "%s  label=%q;\n", indent, state)
+		fmt.Fprintf(&b, "%s  style=%q;\n", indent, style)
+		fmt.Fprintf(&b, "%s  %q%s;\n", indent, state, v.dotAttributes(state))
+		for _, child := range children {
+			writeState(child, indent+"  ")
+		}
+		fmt.Fprintf(&b, "%s}\n", indent)
+	}
+
+	for _, state := range v.states {
+		if _, nested := m.Parents[state]; !nested {
+			writeState(state, "  ")
+		}
+	}
+
+	for _, edge := range v.edges {
+		style := ""
+		if edge.conditional {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "  %q -> %q [label=%q%s];\n", edge.from, edge.to, edge.label, style)
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (v exportView) dotAttributes(state State) string {
+	var attributes []string
+	if _, active := v.active[state]; active {
+		attributes = append(attributes, `style="rounded,filled,bold"`, fmt.Sprintf("fillcolor=%q", exportCurrentColor))
+	} else if _, visited := v.visited[state]; visited {
+		attributes = append(attributes, `style="rounded,filled"`, fmt.Sprintf("fillcolor=%q", exportVisitedColor))
+	}
+	if slices.Contains(v.model.TerminalStates, state) {
+		attributes = append(attributes, "peripheries=2")
+	}
+	if len(attributes) == 0 {
+		return ""
+	}
+
+	return " [" + strings.Join(attributes, ", ") + "]"
+}
+
+// Mermaid stateDiagram-v2 representation; conditional transitions are labeled with their condition,
+// current and visited states are styled with the current and visited classes and terminal states
+// transition to the end state. Sub states are nested in their parent, regions of parallel states split by --
+func (m MachineModel) Mermaid() string {
+	v := newExportView(m)
+	ids := v.mermaidIDs()
+	mermaidID := func(state State) string {
+		if id, ok := ids[state]; ok {
+			return id
+		}
+		return mermaidInvalidIDChars.ReplaceAllString(state.String(), "_")
+	}
+
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+
+	for _, state := range v.states {
+		if id := mermaidID(state); id != state.String() {
+			fmt.Fprintf(&b, "    state %q as %s\n", state, id)
+		}
+	}
+
+	var writeState func(state State, indent string)
+	writeState = func(state State, indent string) {
+		children := v.children[state]
+		if len(children) == 0 {
+			fmt.Fprintf(&b, "%s%s\n", indent, mermaidID(state))
+			return
+		}
+
+		fmt.Fprintf(&b, "%sstate %s {\n", indent, mermaidID(state))
+		parallel := slices.Contains(m.ParallelStates, state)
+		for i, child := range children {
+			if parallel && i > 0 {
+				fmt.Fprintf(&b, "%s    --\n", indent)
+			}
+			writeState(child, indent+"    ")
+		}
+		fmt.Fprintf(&b, "%s}\n", indent)
+	}
+
+	for _, state := range v.states {
+		if _, nested := m.Parents[state]; !nested && len(v.children[state]) > 0 {
+			writeState(state, "    ")
+		}
+	}
+
+	for _, edge := range v.edges {
+		fmt.Fprintf(&b, "    %s --> %s: %s\n", mermaidID(edge.from), mermaidID(edge.to), edge.label)
+	}
+	for _, state := range v.states {
+		if slices.Contains(m.TerminalStates, state) {
+			fmt.Fprintf(&b, "    %s --> [*]\n", mermaidID(state))
+		}
+	}
+
+	fmt.Fprintf(&b, "    classDef current fill:%s,font-weight:bold\n", exportCurrentColor)
+	fmt.Fprintf(&b, "    classDef visited fill:%s\n", exportVisitedColor)
+	for _, state := range v.states {
+		if _, active := v.active[state]; active {
+			fmt.Fprintf(&b, "    class %s current\n", mermaidID(state))
+		} else if _, visited := v.visited[state]; visited {
+			fmt.Fprintf(&b, "    class %s visited\n", mermaidID(state))
+		}
+	}
+
+	return b.String()
+}
+
+// mermaidIDs state ids with the invalid chars replaced; states already valid keep their name and
+// replacements colliding with another id get a _<n> suffix, e.g. a-b is a_b_2 next to a_b
+func (v exportView) mermaidIDs() map[State]string {
+	ids := make(map[State]string, len(v.states))
+	taken := make(map[string]struct{}, len(v.states))
+	for _, state := range v.states {
+		if !mermaidInvalidIDChars.MatchString(state.String()) {
+			ids[state] = state.String()
+			taken[state.String()] = struct{}{}
+		}
+	}
+
+	for _, state := range v.states {
+		if _, ok := ids[state]; ok {
+			continue
+		}
+
+		base := mermaidInvalidIDChars.ReplaceAllString(state.String(), "_")
+		id := base
+		for n := 2; ; n++ {
+			if _, collides := taken[id]; !collides {
+				break
+			}
+			id = fmt.Sprintf("%s_%d", base, n)
+		}
+		ids[state] = id
+		taken[id] = struct{}{}
+	}
+
+	return ids
+}
@@ -0,0 +1,83 @@
+package state_machine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newExportMachine(t *testing.T) *Machine {
+	ctx := context.Background()
+	m := NewMachine(ctx, "order-1", newVersionedStorage())
+	for _, state := range []State{"new", "paid", "shipped", "gift-wrapped"} {
+		m.AddState(state)
+	}
+	require.NoError(t, m.SetInitialState("new"))
+	require.NoError(t, m.AddTransition("new", "pay", "paid"))
+	require.NoError(t, m.AddTransition("paid", "ship", "shipped"))
+	require.NoError(t, m.AddTransition("paid", "wrap", "gift-wrapped"))
+	require.NoError(t, m.AddConditionalTransition("gift-wrapped", "ship", "shipped", ConditionIfVisitedOneOf, "paid"))
+
+	_, err := m.Trigger(ctx, "pay")
+	require.NoError(t, err)
+	return m
+}
+
+func TestMachine_DOT(t *testing.T) {
+	dot := newExportMachine(t).DOT()
+
+	assert.Contains(t, dot, `digraph "order-1" {`)
+	assert.Contains(t, dot, `"new" -> "paid" [label="pay"];`)
+	assert.Contains(t, dot, `"gift-wrapped" -> "shipped" [label="ship [if_visited_one_of: paid]", style=dashed];`)
+	assert.Contains(t, dot, `"paid" [style="rounded,filled,bold", fillcolor="#f6c744"];`)
+	assert.Contains(t, dot, `"new" [style="rounded,filled", fillcolor="#cfe8cf"];`)
+}
+
+func TestMachine_Mermaid(t *testing.T) {
+	mermaid := newExportMachine(t).Mermaid()
+
+	assert.Contains(t, mermaid, "stateDiagram-v2\n")
+	assert.Contains(t, mermaid, `state "gift-wrapped" as gift_wrapped`)
+	assert.Contains(t, mermaid, "new --> paid: pay\n")
+	assert.Contains(t, mermaid, "gift_wrapped --> shipped: ship [if_visited_one_of: paid]\n")
+	assert.Contains(t, mermaid, "class paid current\n")
+	assert.Contains(t, mermaid, "class new visited\n")
+}
+
+func TestMachine_ExportHierarchy(t *testing.T) {
+	m := newOnboardingMachine(t, newVersionedStorage())
+	_, err := m.Trigger(context.Background(), "start")
+	require.NoError(t, err)
+
+	mermaid := m.Mermaid()
+	assert.Contains(t, mermaid, "    state setup {\n        state kyc {\n")
+	assert.Contains(t, mermaid, "        --\n        state payment {\n")
+	assert.Contains(t, mermaid, "active --> [*]\n")
+	assert.Contains(t, mermaid, "class kyc_pending current\n")
+	assert.Contains(t, mermaid, "class payment_pending current\n")
+
+	dot := m.DOT()
+	assert.Contains(t, dot, `subgraph "cluster_setup" {`)
+	assert.Contains(t, dot, `style="rounded,dashed";`)
+	assert.Contains(t, dot, `"active" [peripheries=2];`)
+}
+
+func TestMachine_MermaidDistinctIDs(t *testing.T) {
+	ctx := context.Background()
+	m := NewMachine(ctx, "order-2", newVersionedStorage())
+	for _, state := range []State{"a-b", "a_b", "a b"} {
+		m.AddState(state)
+	}
+	require.NoError(t, m.SetInitialState("a-b"))
+	require.NoError(t, m.AddTransition("a-b", "next", "a_b"))
+	require.NoError(t, m.AddTransition("a_b", "next", "a b"))
+
+	mermaid := m.Mermaid()
+	assert.Contains(t, mermaid, `state "a b" as a_b_2`)
+	assert.Contains(t, mermaid, `state "a-b" as a_b_3`)
+	assert.Contains(t, mermaid, "a_b_3 --> a_b: next\n")
+	assert.Contains(t, mermaid, "a_b --> a_b_2: next\n")
+	assert.Contains(t, mermaid, "class a_b_3 current\n")
+}
@@ -0,0 +1,439 @@
+package state_machine
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	"github.com/pixie-sh/core-go/pkg/types/maps"
+	"github.com/pixie-sh/core-go/pkg/types/slices"
+)
+
+// AddSubState nests child in parent; events the active sub states don't handle are handled by
+// their ancestors. The hierarchy must be declared before SetInitialState
+func (m *Machine) AddSubState(parent State, child State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.addSubStateUnlocked(parent, child)
+}
+
+func (m *Machine) addSubStateUnlocked(parent State, child State) error {
+	if _, exists := m.states[parent]; !exists {
+		return errors.New("parent state '%s' does not exist", parent).WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+	if _, exists := m.states[child]; !exists {
+		return errors.New("sub state '%s' does not exist", child).WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+
+	current, nested := m.parents[child]
+	if nested && current == parent {
+		return nil
+	}
+	if nested {
+		return errors.New("state '%s' is already a sub state of '%s'", child, current).WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+
+	for ancestor := parent; ancestor != NilState; ancestor = m.parents[ancestor] {
+		if ancestor == child {
+			return errors.New("state '%s' can't be a sub state of its descendant '%s'", child, parent).WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+		}
+	}
+
+	m.parents[child] = parent
+	m.children[parent] = append(m.children[parent], child)
+	sort.SliceStable(m.children[parent], func(i, j int) bool {
+		return m.children[parent][i] < m.children[parent][j]
+	})
+	return nil
+}
+
+// SetInitialSubState sub state entered when parent is entered; required for non parallel parents
+func (m *Machine) SetInitialSubState(parent State, child State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if current, nested := m.parents[child]; !nested || current != parent {
+		return errors.New("state '%s' is not a sub state of '%s'", child, parent).WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+
+	m.initials[parent] = child
+	return nil
+}
+
+// SetParallel makes the sub states of state orthogonal regions, entered and active together;
+// an event is handled by every active region handling it
+func (m *Machine) SetParallel(state State) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.states[state]; !exists {
+		return errors.New("state '%s' does not exist", state).WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+
+	m.parallel[state] = struct{}{}
+	return nil
+}
+
+// ActiveStates active leaf states, one per active region; CurrentState is the first of them
+func (m *Machine) ActiveStates() []State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Copy(m.activeUnlocked())
+}
+
+// IsActive whether state is active, itself or through one of its sub states
+func (m *Machine) IsActive(state State) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.isActiveUnlocked(state)
+}
+
+func (m *Machine) isHierarchicalUnlocked() bool {
+	return len(m.parents) > 0
+}
+
+func (m *Machine) activeUnlocked() []State {
+	if len(m.active) == 0 {
+		return []State{m.currentState}
+	}
+	return m.active
+}
+
+// isDescendantUnlocked whether state is nested, at any depth, in ancestor; every state descends from NilState
+func (m *Machine) isDescendantUnlocked(state State, ancestor State) bool {
+	for parent, nested := m.parents[state]; nested; parent, nested = m.parents[parent] {
+		if parent == ancestor {
+			return true
+		}
+	}
+	return ancestor == NilState
+}
+
+func (m *Machine) isActiveUnlocked(state State) bool {
+	for _, leaf := range m.activeUnlocked() {
+		if leaf == state || (state != NilState && m.isDescendantUnlocked(leaf, state)) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeInRegionsUnlocked states active in hierarchical machines count as visited, so conditions
+// can depend on the progress of other regions
+func (m *Machine) activeInRegionsUnlocked(state State) bool {
+	return m.isHierarchicalUnlocked() && m.isActiveUnlocked(state)
+}
+
+func (m *Machine) depthUnlocked(state State) int {
+	depth := 0
+	for parent, nested := m.parents[state]; nested; parent, nested = m.parents[parent] {
+		depth++
+	}
+	return depth
+}
+
+// enterUnlocked enters state and its initial sub states, or every region if parallel
+func (m *Machine) enterUnlocked(state State, entered *[]State, leaves *[]State) error {
+	*entered = append(*entered, state)
+
+	children := m.children[state]
+	if len(children) == 0 {
+		*leaves = append(*leaves, state)
+		return nil
+	}
+
+	if _, parallel := m.parallel[state]; parallel {
+		for _, child := range children {
+			err := m.enterUnlocked(child, entered, leaves)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	initial, ok := m.initials[state]
+	if !ok {
+		return errors.New("state '%s' has sub states and no initial sub state", state).WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+
+	return m.enterUnlocked(initial, entered, leaves)
+}
+
+// enterFromUnlocked enters target and its ancestors below domain; other regions of the parallel
+// ancestors are entered through their initial sub states
+func (m *Machine) enterFromUnlocked(domain State, target State, entered *[]State, leaves *[]State) error {
+	path := []State{target}
+	for parent, nested := m.parents[target]; nested && parent != domain; parent, nested = m.parents[parent] {
+		path = append([]State{parent}, path...)
+	}
+
+	for i, state := range path[:len(path)-1] {
+		*entered = append(*entered, state)
+		if _, parallel := m.parallel[state]; !parallel {
+			continue
+		}
+
+		for _, child := range m.children[state] {
+			if child == path[i+1] {
+				continue
+			}
+
+			err := m.enterUnlocked(child, entered, leaves)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return m.enterUnlocked(target, entered, leaves)
+}
+
+// setInitialHierarchicalUnlocked enters state from the root
+func (m *Machine) setInitialHierarchicalUnlocked(state State) error {
+	var entered, leaves []State
+	err := m.enterFromUnlocked(NilState, state, &entered, &leaves)
+	if err != nil {
+		return err
+	}
+
+	m.active = leaves
+	m.currentState = leaves[0]
+	m.activeAt = make(map[State]time.Time, len(leaves))
+	for _, leaf := range leaves {
+		m.activeAt[leaf] = m.currentStateAt
+	}
+	return nil
+}
+
+// transitionDomainUnlocked deepest proper ancestor of source containing target; states below it are exited
+func (m *Machine) transitionDomainUnlocked(source State, target State) State {
+	for parent, nested := m.parents[source]; nested; parent, nested = m.parents[parent] {
+		if m.isDescendantUnlocked(target, parent) {
+			return parent
+		}
+	}
+	return NilState
+}
+
+// triggerHierarchicalUnlocked each active leaf looks for a transition handling event, from itself up
+// to its ancestors; the transitions found are performed in the regions order and rolled back together
+func (m *Machine) triggerHierarchicalUnlocked(ctx context.Context, event Event, payload any) (State, error) {
+	var (
+		transitions []TransitionContext
+		sources     = make(map[State]struct{})
+		firstErr    error
+	)
+
+	for _, leaf := range slices.Copy(m.activeUnlocked()) {
+		for source, ok := leaf, true; ok; source, ok = m.parents[source] {
+			if _, handled := sources[source]; handled {
+				break
+			}
+
+			to, err := m.resolveTransitionUnlocked(ctx, source, event, payload)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+
+			sources[source] = struct{}{}
+			transitions = append(transitions, m.transitionContextUnlocked(source, event, to, payload))
+			break
+		}
+	}
+
+	if len(transitions) == 0 {
+		return NilState, firstErr
+	}
+
+	var (
+		previousActive   = slices.Copy(m.active)
+		previousActiveAt = copyStateTimes(m.activeAt)
+		previousState    = m.currentState
+		previousStateAt  = m.currentStateAt
+		previousVisited  = slices.Copy(m.visitedStates)
+		now              = time.Now().UTC()
+		performed        []TransitionContext
+	)
+
+	for _, transition := range transitions {
+		// left by a previous transition of the same event
+		if !m.isActiveUnlocked(transition.From) {
+			continue
+		}
+
+		stage, err := m.hierarchicalTransitionUnlocked(ctx, transition, now)
+		if err != nil {
+			m.active = previousActive
+			m.activeAt = previousActiveAt
+			m.currentState = previousState
+			m.currentStateAt = previousStateAt
+			m.visitedStates = previousVisited
+
+			castedErr, ok := errors.As(err)
+			if ok {
+				return NilState, castedErr
+			}
+
+			return NilState, errors.NewWithError(err, "%s action failed on transition from '%s' with '%s'", stage, transition.From, transition.Event).
+				WithErrorCode(pixieErrors.StateMachineActionFailedErrorCode)
+		}
+
+		performed = append(performed, transition)
+	}
+
+	for _, transition := range performed {
+		m.recordTransitionUnlocked(ctx, transition, now)
+	}
+
+	return m.currentState, nil
+}
+
+// hierarchicalTransitionUnlocked exits the active states below the transition domain, deepest first,
+// and enters the target; returns the failing stage
+func (m *Machine) hierarchicalTransitionUnlocked(ctx context.Context, transition TransitionContext, now time.Time) (string, error) {
+	domain := m.transitionDomainUnlocked(transition.From, transition.To)
+
+	var (
+		exited    []State
+		exitedSet = make(map[State]struct{})
+		remaining []State
+		position  = -1
+	)
+
+	for _, leaf := range m.activeUnlocked() {
+		if !m.isDescendantUnlocked(leaf, domain) {
+			remaining = append(remaining, leaf)
+			continue
+		}
+
+		if position < 0 {
+			position = len(remaining)
+		}
+
+		for state, ok := leaf, true; ok && state != domain; state, ok = m.parents[state] {
+			if _, done := exitedSet[state]; !done {
+				exitedSet[state] = struct{}{}
+				exited = append(exited, state)
+			}
+		}
+	}
+
+	sort.SliceStable(exited, func(i, j int) bool {
+		return m.depthUnlocked(exited[i]) > m.depthUnlocked(exited[j])
+	})
+
+	for _, state := range exited {
+		for _, action := range m.hooks.onExit[state] {
+			if err := action(ctx, transition); err != nil {
+				return "exit", err
+			}
+		}
+	}
+
+	for _, action := range m.hooks.onTransition[transitionKey{from: transition.From, event: transition.Event}] {
+		if err := action(ctx, transition); err != nil {
+			return "transition", err
+		}
+	}
+
+	var entered, leaves []State
+	err := m.enterFromUnlocked(domain, transition.To, &entered, &leaves)
+	if err != nil {
+		return "enter", err
+	}
+
+	for _, state := range exited {
+		m.visitedStates = append(m.visitedStates, VisitedState{State: state, At: now})
+	}
+
+	if position < 0 {
+		position = len(remaining)
+	}
+	activeAt := make(map[State]time.Time, len(remaining)+len(leaves))
+	for _, leaf := range remaining {
+		activeAt[leaf] = m.activeStateAtUnlocked(leaf)
+	}
+	for _, leaf := range leaves {
+		activeAt[leaf] = now
+	}
+
+	m.active = append(append(slices.Copy(remaining[:position]), leaves...), remaining[position:]...)
+	m.activeAt = activeAt
+	m.currentState = m.active[0]
+	m.currentStateAt = now
+
+	for _, state := range entered {
+		for _, action := range m.hooks.onEnter[state] {
+			if err := action(ctx, transition); err != nil {
+				return "enter", err
+			}
+		}
+	}
+
+	return "", nil
+}
+
+func (m *Machine) parallelStatesUnlocked() []State {
+	states := maps.MapKeys(m.parallel)
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i] < states[j]
+	})
+	return states
+}
+
+// restoreHierarchyUnlocked rebuilds the hierarchy from the persisted model
+func (m *Machine) restoreHierarchyUnlocked(model MachineModel) {
+	m.parents = make(map[State]State, len(model.Parents))
+	m.children = make(map[State][]State)
+	for _, child := range maps.MapKeys(model.Parents) {
+		parent := model.Parents[child]
+		m.parents[child] = parent
+		m.children[parent] = append(m.children[parent], child)
+	}
+	for parent := range m.children {
+		sort.SliceStable(m.children[parent], func(i, j int) bool {
+			return m.children[parent][i] < m.children[parent][j]
+		})
+	}
+
+	m.initials = make(map[State]State, len(model.InitialSubStates))
+	for parent, child := range model.InitialSubStates {
+		m.initials[parent] = child
+	}
+
+	m.parallel = make(map[State]struct{}, len(model.ParallelStates))
+	for _, state := range model.ParallelStates {
+		m.parallel[state] = struct{}{}
+	}
+
+	m.active = slices.Copy(model.ActiveStates)
+	m.activeAt = copyStateTimes(model.ActiveStatesAt)
+}
+
+// activeStateAtUnlocked entry time of the active leaf; machines persisted before the leaves entry
+// times were kept, and flat machines, use the current state time
+func (m *Machine) activeStateAtUnlocked(leaf State) time.Time {
+	if at, ok := m.activeAt[leaf]; ok {
+		return at
+	}
+	return m.currentStateAt
+}
+
+func copyStateTimes(times map[State]time.Time) map[State]time.Time {
+	if times == nil {
+		return nil
+	}
+
+	copied := make(map[State]time.Time, len(times))
+	for state, at := range times {
+		copied[state] = at
+	}
+	return copied
+}
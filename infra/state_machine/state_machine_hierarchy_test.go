@@ -0,0 +1,191 @@
+package state_machine
+
+import (
+	"context"
+	"testing"
+
+	perrors "github.com/pixie-sh/errors-go"
+	"github.com/pixie-sh/logger-go/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/pkg/configuration"
+)
+
+const onboardingDefinitionJSON = `{
+  "name": "onboarding",
+  "version": 1,
+  "states": ["signup", "setup", "kyc", "kyc_pending", "kyc_done", "payment", "payment_pending", "payment_done", "active", "cancelled"],
+  "initial_state": "signup",
+  "terminal_states": ["active", "cancelled"],
+  "sub_states": [
+    {"parent": "setup", "states": ["kyc", "payment"], "parallel": true},
+    {"parent": "kyc", "states": ["kyc_pending", "kyc_done"], "initial": "kyc_pending"},
+    {"parent": "payment", "states": ["payment_pending", "payment_done"], "initial": "payment_pending"}
+  ],
+  "transitions": [
+    {"from": "signup", "event": "start", "to": "setup"},
+    {"from": "kyc_pending", "event": "verify", "to": "kyc_done"},
+    {"from": "payment_pending", "event": "add_card", "to": "payment_done"},
+    {"from": "setup", "event": "cancel", "to": "cancelled"}
+  ],
+  "conditional_transitions": [
+    {"from": "setup", "event": "complete", "to": "active", "if": "if_visited_all", "if_states": ["kyc_done", "payment_done"]}
+  ]
+}`
+
+func newOnboardingMachine(t *testing.T, storage StateMachineStorage) *Machine {
+	var definition Definition
+	_, err := configuration.StructFromJSONBytesWithEnvReplace([]byte(onboardingDefinitionJSON), &definition, logger.Logger)
+	require.NoError(t, err)
+
+	m, err := NewMachineFromDefinition(context.Background(), "onboarding-1", definition, storage)
+	require.NoError(t, err)
+	return m
+}
+
+func TestHierarchy_ParentHandlesEvents(t *testing.T) {
+	ctx := context.Background()
+	m := NewMachine(ctx, "checkout", newVersionedStorage())
+	for _, state := range []State{"checkout", "address", "review", "abandoned"} {
+		m.AddState(state)
+	}
+	require.NoError(t, m.AddSubState("checkout", "address"))
+	require.NoError(t, m.AddSubState("checkout", "review"))
+	require.NoError(t, m.SetInitialSubState("checkout", "address"))
+	require.NoError(t, m.AddTransition("address", "next", "review"))
+	require.NoError(t, m.AddTransition("checkout", "abandon", "abandoned"))
+	require.NoError(t, m.SetInitialState("checkout"))
+
+	assert.Equal(t, State("address"), m.CurrentState())
+	assert.True(t, m.IsActive("checkout"))
+
+	_, err := m.Trigger(ctx, "next")
+	require.NoError(t, err)
+	assert.Equal(t, State("review"), m.CurrentState())
+
+	state, err := m.Trigger(ctx, "abandon")
+	require.NoError(t, err)
+	assert.Equal(t, State("abandoned"), state)
+	assert.False(t, m.IsActive("checkout"))
+	assert.NoError(t, m.VisitedAll("address", "review", "checkout"))
+
+	_, err = m.Trigger(ctx, "next")
+	_, invalid := perrors.Has(err, perrors.StateMachineInvalidTransitionErrorCode)
+	assert.True(t, invalid)
+}
+
+func TestHierarchy_InvalidHierarchy(t *testing.T) {
+	m := NewMachine(context.Background(), "invalid", newVersionedStorage())
+	m.AddState("a")
+	m.AddState("b")
+	m.AddState("c")
+	require.NoError(t, m.AddSubState("a", "b"))
+	assert.Error(t, m.AddSubState("b", "a"))
+	assert.Error(t, m.AddSubState("c", "b"))
+	assert.Error(t, m.SetInitialSubState("a", "c"))
+	assert.Error(t, m.SetInitialState("a"))
+}
+
+func TestHierarchy_ParallelRegions(t *testing.T) {
+	ctx := context.Background()
+	m := newOnboardingMachine(t, newVersionedStorage())
+
+	_, err := m.Trigger(ctx, "start")
+	require.NoError(t, err)
+	assert.Equal(t, []State{"kyc_pending", "payment_pending"}, m.ActiveStates())
+
+	_, err = m.Trigger(ctx, "complete")
+	assert.Error(t, err)
+
+	_, err = m.Trigger(ctx, "verify")
+	require.NoError(t, err)
+	_, err = m.Trigger(ctx, "add_card")
+	require.NoError(t, err)
+	assert.Equal(t, []State{"kyc_done", "payment_done"}, m.ActiveStates())
+	assert.False(t, m.IsTerminal())
+
+	state, err := m.Trigger(ctx, "complete")
+	require.NoError(t, err)
+	assert.Equal(t, State("active"), state)
+	assert.Equal(t, []State{"active"}, m.ActiveStates())
+	assert.True(t, m.IsTerminal())
+}
+
+func TestHierarchy_ActionsOrderAndRollback(t *testing.T) {
+	ctx := context.Background()
+	m := newOnboardingMachine(t, newVersionedStorage())
+
+	var calls []string
+	record := func(prefix string, state State) {
+		require.NoError(t, m.OnExit(state, func(ctx context.Context, transition TransitionContext) error {
+			calls = append(calls, "exit:"+state.String())
+			return nil
+		}))
+		require.NoError(t, m.OnEnter(state, func(ctx context.Context, transition TransitionContext) error {
+			calls = append(calls, prefix+state.String())
+			return nil
+		}))
+	}
+	for _, state := range []State{"setup", "kyc", "kyc_pending", "payment", "payment_pending", "cancelled"} {
+		record("enter:", state)
+	}
+
+	_, err := m.Trigger(ctx, "start")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"enter:setup", "enter:kyc", "enter:kyc_pending", "enter:payment", "enter:payment_pending"}, calls)
+
+	calls = nil
+	_, err = m.Trigger(ctx, "cancel")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"exit:kyc_pending", "exit:payment_pending", "exit:kyc", "exit:payment", "exit:setup", "enter:cancelled"}, calls)
+
+	failing := newOnboardingMachine(t, newVersionedStorage())
+	_, err = failing.Trigger(ctx, "start")
+	require.NoError(t, err)
+	require.NoError(t, failing.OnEnter("payment_done", func(ctx context.Context, transition TransitionContext) error {
+		return perrors.New("card rejected")
+	}))
+
+	_, err = failing.Trigger(ctx, "add_card")
+	assert.Error(t, err)
+	assert.Equal(t, []State{"kyc_pending", "payment_pending"}, failing.ActiveStates())
+	assert.Len(t, failing.Visited(), 1)
+}
+
+func TestHierarchy_SaveRestoreActiveConfiguration(t *testing.T) {
+	ctx := context.Background()
+	storage := newVersionedStorage()
+	m := newOnboardingMachine(t, storage)
+
+	_, err := m.Trigger(ctx, "start")
+	require.NoError(t, err)
+	_, err = m.Trigger(ctx, "verify")
+	require.NoError(t, err)
+	require.NoError(t, m.Save())
+
+	persisted, err := storage.Get("onboarding-1")
+	require.NoError(t, err)
+	assert.Equal(t, []State{"kyc_done", "payment_pending"}, persisted.ActiveStates)
+	assert.Equal(t, []State{"setup"}, persisted.ParallelStates)
+
+	restored := NewMachine(ctx, "onboarding-1", storage)
+	require.NoError(t, restored.Restore())
+	assert.Equal(t, []State{"kyc_done", "payment_pending"}, restored.ActiveStates())
+
+	_, err = restored.Trigger(ctx, "add_card")
+	require.NoError(t, err)
+	_, err = restored.Trigger(ctx, "complete")
+	require.NoError(t, err)
+	assert.Equal(t, State("active"), restored.CurrentState())
+}
+
+func TestDefinitionValidate_SubStates(t *testing.T) {
+	var definition Definition
+	_, err := configuration.StructFromJSONBytesWithEnvReplace([]byte(onboardingDefinitionJSON), &definition, logger.Logger)
+	require.NoError(t, err)
+	require.NoError(t, definition.Validate())
+
+	definition.SubStates[1].Initial = "payment_done"
+	assert.Error(t, definition.Validate())
+}
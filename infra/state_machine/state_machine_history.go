@@ -0,0 +1,64 @@
+package state_machine
+
+import (
+	"context"
+	"time"
+
+	"github.com/pixie-sh/logger-go/logger"
+
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	"github.com/pixie-sh/core-go/pkg/uid"
+)
+
+const LocalsTransitionMetadata = "state_machine_transition_metadata"
+
+// TransitionRecord audit record of a transition; ActorID and TraceID are taken from the trigger context
+type TransitionRecord struct {
+	ID             string         `json:"id"`
+	MachineID      string         `json:"machine_id"`
+	DefinitionName string         `json:"definition_name,omitempty"`
+	From           State          `json:"from"`
+	Event          Event          `json:"event"`
+	To             State          `json:"to"`
+	ActorID        string         `json:"actor_id,omitempty"`
+	TraceID        string         `json:"trace_id,omitempty"`
+	Metadata       map[string]any `json:"metadata,omitempty"`
+	At             time.Time      `json:"at"`
+}
+
+// WithTransitionMetadata metadata recorded with the transitions triggered with the returned context
+func WithTransitionMetadata(ctx context.Context, metadata map[string]any) context.Context {
+	return context.WithValue(ctx, LocalsTransitionMetadata, metadata)
+}
+
+// PendingHistory transitions recorded since the last Save or Restore
+func (m *Machine) PendingHistory() []TransitionRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := make([]TransitionRecord, len(m.pendingHistory))
+	copy(pending, m.pendingHistory)
+	return pending
+}
+
+// recordTransitionUnlocked records the transition in the history and as event if enabled
+func (m *Machine) recordTransitionUnlocked(ctx context.Context, transition TransitionContext, at time.Time) {
+	traceID, _ := ctx.Value(logger.TraceID).(string)
+	metadata, _ := ctx.Value(LocalsTransitionMetadata).(map[string]any)
+
+	record := TransitionRecord{
+		ID:             uid.NewUUID(),
+		MachineID:      m.id,
+		DefinitionName: m.definitionName,
+		From:           transition.From,
+		Event:          transition.Event,
+		To:             transition.To,
+		ActorID:        pixiecontext.GetCtxActorID(ctx),
+		TraceID:        traceID,
+		Metadata:       metadata,
+		At:             at,
+	}
+
+	m.pendingHistory = append(m.pendingHistory, record)
+	m.recordTransitionEventUnlocked(record)
+}
@@ -0,0 +1,76 @@
+package state_machine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pixie-sh/logger-go/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+)
+
+// historyStorage keeps the history of the stored models
+type historyStorage struct {
+	*failingStorage
+	history []TransitionRecord
+}
+
+func (s *historyStorage) Store(model MachineModel) error {
+	err := s.failingStorage.Store(model)
+	if err != nil {
+		return err
+	}
+
+	s.history = append(s.history, model.History...)
+	return nil
+}
+
+func TestTransitionHistory_RecordsActorFromContext(t *testing.T) {
+	ctx := pixiecontext.SetCtxActorID(context.Background(), "user-1")
+	ctx = context.WithValue(ctx, logger.TraceID, "trace-1")
+	ctx = WithTransitionMetadata(ctx, map[string]any{"reason": "checkout"})
+
+	storage := &historyStorage{failingStorage: &failingStorage{versionedStorage: newVersionedStorage()}}
+	m := newCounterMachine(t, storage)
+
+	_, err := m.Trigger(ctx, "next")
+	require.NoError(t, err)
+
+	pending := m.PendingHistory()
+	require.Len(t, pending, 1)
+	assert.Equal(t, "counter", pending[0].MachineID)
+	assert.Equal(t, State("one"), pending[0].From)
+	assert.Equal(t, Event("next"), pending[0].Event)
+	assert.Equal(t, State("two"), pending[0].To)
+	assert.Equal(t, "user-1", pending[0].ActorID)
+	assert.Equal(t, "trace-1", pending[0].TraceID)
+	assert.Equal(t, "checkout", pending[0].Metadata["reason"])
+	assert.NotEmpty(t, pending[0].ID)
+
+	require.NoError(t, m.Save())
+	assert.Empty(t, m.PendingHistory())
+	assert.Equal(t, pending, storage.history)
+}
+
+func TestTransitionHistory_KeptPendingOnStoreFailure(t *testing.T) {
+	storage := &historyStorage{failingStorage: &failingStorage{versionedStorage: newVersionedStorage()}}
+	m := newCounterMachine(t, storage)
+
+	_, err := m.Trigger(context.Background(), "next")
+	require.NoError(t, err)
+
+	storage.fail = true
+	assert.Error(t, m.Save())
+	assert.Len(t, m.PendingHistory(), 1)
+
+	storage.fail = false
+	_, err = m.Trigger(context.Background(), "next")
+	require.NoError(t, err)
+	require.NoError(t, m.Save())
+
+	require.Len(t, storage.history, 2)
+	assert.Equal(t, State("two"), storage.history[0].To)
+	assert.Equal(t, State("three"), storage.history[1].To)
+}
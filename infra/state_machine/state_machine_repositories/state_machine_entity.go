@@ -1,6 +1,10 @@
 package state_machine_repositories
 
-import "github.com/pixie-sh/core-go/pkg/models/database_models"
+import (
+	"time"
+
+	"github.com/pixie-sh/core-go/pkg/models/database_models"
+)
 
 type StateMachine struct {
 	database_models.SoftDeletable
@@ -8,8 +12,60 @@ type StateMachine struct {
 	ID        string `gorm:"type:uuid;primaryKey"`
 	MachineID string `gorm:"type:text;uniqueIndex"`
 	Blob      database_models.JSONB
+	Version   int64 `gorm:"not null;default:0"`
+
+	// promoted from Blob on save
+	CurrentState   string     `gorm:"type:text;not null;default:'';index:idx_state_machines_current_state_at"`
+	CurrentStateAt *time.Time `gorm:"index:idx_state_machines_current_state_at"`
+	DefinitionName string     `gorm:"type:text;not null;default:'';index"`
 } //@name StateMachine
 
 func (StateMachine) TableName() string {
 	return "state_machines"
 }
+
+// StateMachineOutbox transition events stored with the machine, published by RelayOutbox
+type StateMachineOutbox struct {
+	ID          string `gorm:"type:uuid;primaryKey"`
+	MachineID   string `gorm:"type:text;index"`
+	PayloadType string `gorm:"type:text"`
+	Blob        database_models.JSONB
+	CreatedAt   time.Time  `gorm:"not null"`
+	PublishedAt *time.Time `gorm:"index"`
+} //@name StateMachineOutbox
+
+func (StateMachineOutbox) TableName() string {
+	return "state_machine_outbox"
+}
+
+// StateMachineTimeout due timeout of a machine active state
+type StateMachineTimeout struct {
+	MachineID   string     `gorm:"type:text;primaryKey"`
+	State       string     `gorm:"type:text;primaryKey"`
+	Event       string     `gorm:"type:text"`
+	StateAt     time.Time  `gorm:"not null"`
+	DueAt       time.Time  `gorm:"not null;index"`
+	LockedUntil *time.Time `gorm:""`
+} //@name StateMachineTimeout
+
+func (StateMachineTimeout) TableName() string {
+	return "state_machine_timeouts"
+}
+
+// StateMachineTransition append-only transition history
+type StateMachineTransition struct {
+	ID             string `gorm:"type:uuid;primaryKey"`
+	MachineID      string `gorm:"type:text;index"`
+	DefinitionName string `gorm:"type:text"`
+	FromState      string `gorm:"type:text"`
+	Event          string `gorm:"type:text"`
+	ToState        string `gorm:"type:text;index"`
+	ActorID        string `gorm:"type:text"`
+	TraceID        string `gorm:"type:text"`
+	Metadata       database_models.JSONB
+	At             time.Time `gorm:"not null;index"`
+} //@name StateMachineTransition
+
+func (StateMachineTransition) TableName() string {
+	return "state_machine_transitions"
+}
@@ -0,0 +1,75 @@
+package state_machine_repositories
+
+import (
+	"time"
+
+	"github.com/pixie-sh/core-go/infra/state_machine"
+	"github.com/pixie-sh/core-go/pkg/models/database_models"
+)
+
+// appendHistory inserts the model transitions since its last store
+func (r StateMachineRepository) appendHistory(m state_machine.MachineModel) error {
+	if len(m.History) == 0 {
+		return nil
+	}
+
+	rows := make([]StateMachineTransition, len(m.History))
+	for i, record := range m.History {
+		metadata := database_models.JSONB{}
+		for key, value := range record.Metadata {
+			metadata[key] = value
+		}
+
+		rows[i] = StateMachineTransition{
+			ID:             record.ID,
+			MachineID:      record.MachineID,
+			DefinitionName: record.DefinitionName,
+			FromState:      record.From.String(),
+			Event:          record.Event.String(),
+			ToState:        record.To.String(),
+			ActorID:        record.ActorID,
+			TraceID:        record.TraceID,
+			Metadata:       metadata,
+			At:             record.At,
+		}
+	}
+
+	return r.DB.Create(&rows).Error
+}
+
+// GetHistoryByMachineID transitions of the machine, oldest first
+func (r StateMachineRepository) GetHistoryByMachineID(machineID string) (history []StateMachineTransition, err error) {
+	return history, r.DB.Model(&StateMachineTransition{}).
+		Where("machine_id = ?", machineID).
+		Order("at, id").
+		Find(&history).
+		Error
+}
+
+// GetTransitionsToState transitions entering state within [from, to), oldest first
+func (r StateMachineRepository) GetTransitionsToState(state state_machine.State, from time.Time, to time.Time) (history []StateMachineTransition, err error) {
+	return history, r.DB.Model(&StateMachineTransition{}).
+		Where("to_state = ? AND at >= ? AND at < ?", state.String(), from, to).
+		Order("at, id").
+		Find(&history).
+		Error
+}
+
+// GetMachineIDsThatEnteredState IDs of the machines that entered state within [from, to)
+func (r StateMachineRepository) GetMachineIDsThatEnteredState(state state_machine.State, from time.Time, to time.Time) (machineIDs []string, err error) {
+	return machineIDs, r.DB.Model(&StateMachineTransition{}).
+		Distinct("machine_id").
+		Where("to_state = ? AND at >= ? AND at < ?", state.String(), from, to).
+		Order("machine_id").
+		Pluck("machine_id", &machineIDs).
+		Error
+}
+
+// GetHistoryByActorID transitions triggered by the actor within [from, to), oldest first
+func (r StateMachineRepository) GetHistoryByActorID(actorID string, from time.Time, to time.Time) (history []StateMachineTransition, err error) {
+	return history, r.DB.Model(&StateMachineTransition{}).
+		Where("actor_id = ? AND at >= ? AND at < ?", actorID, from, to).
+		Order("at, id").
+		Find(&history).
+		Error
+}
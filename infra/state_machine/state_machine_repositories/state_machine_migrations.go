@@ -84,3 +84,151 @@ var AdaptMachineStateBlob1725028128419 = database.Migration{
 		return nil
 	},
 }
+
+var AddStateMachinesVersion1792447201113 = database.Migration{
+	ID: "1792447201113_AddStateMachinesVersion",
+	Migrate: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            ALTER TABLE state_machines ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
+        `).Error
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            ALTER TABLE state_machines DROP COLUMN IF EXISTS version;
+        `).Error
+	},
+}
+
+var CreateStateMachineOutboxTable1792533601000 = database.Migration{
+	ID: "1792533601000_CreateStateMachineOutboxTable",
+	Migrate: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            CREATE TABLE IF NOT EXISTS state_machine_outbox (
+                id UUID PRIMARY KEY,
+                machine_id VARCHAR(255) NOT NULL,
+                payload_type VARCHAR(255) NOT NULL,
+                blob JSONB NOT NULL,
+                created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                published_at TIMESTAMP WITH TIME ZONE
+            );
+            CREATE INDEX IF NOT EXISTS idx_state_machine_outbox_machine_id ON state_machine_outbox(machine_id);
+            CREATE INDEX IF NOT EXISTS idx_state_machine_outbox_pending ON state_machine_outbox(created_at) WHERE published_at IS NULL;
+        `).Error
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            DROP TABLE IF EXISTS state_machine_outbox;
+        `).Error
+	},
+}
+
+var CreateStateMachineTimeoutsTable1792620001000 = database.Migration{
+	ID: "1792620001000_CreateStateMachineTimeoutsTable",
+	Migrate: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            CREATE TABLE IF NOT EXISTS state_machine_timeouts (
+                machine_id VARCHAR(255) PRIMARY KEY,
+                state VARCHAR(255) NOT NULL,
+                event VARCHAR(255) NOT NULL,
+                state_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                due_at TIMESTAMP WITH TIME ZONE NOT NULL,
+                locked_until TIMESTAMP WITH TIME ZONE
+            );
+            CREATE INDEX IF NOT EXISTS idx_state_machine_timeouts_due_at ON state_machine_timeouts(due_at);
+        `).Error
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            DROP TABLE IF EXISTS state_machine_timeouts;
+        `).Error
+	},
+}
+
+var CreateStateMachineTransitionsTable1792706401000 = database.Migration{
+	ID: "1792706401000_CreateStateMachineTransitionsTable",
+	Migrate: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            CREATE TABLE IF NOT EXISTS state_machine_transitions (
+                id UUID PRIMARY KEY,
+                machine_id VARCHAR(255) NOT NULL,
+                definition_name VARCHAR(255) NOT NULL DEFAULT '',
+                from_state VARCHAR(255) NOT NULL,
+                event VARCHAR(255) NOT NULL,
+                to_state VARCHAR(255) NOT NULL,
+                actor_id VARCHAR(255) NOT NULL DEFAULT '',
+                trace_id VARCHAR(255) NOT NULL DEFAULT '',
+                metadata JSONB NOT NULL DEFAULT '{}',
+                at TIMESTAMP WITH TIME ZONE NOT NULL
+            );
+            CREATE INDEX IF NOT EXISTS idx_state_machine_transitions_machine_id_at ON state_machine_transitions(machine_id, at);
+            CREATE INDEX IF NOT EXISTS idx_state_machine_transitions_to_state_at ON state_machine_transitions(to_state, at);
+            CREATE INDEX IF NOT EXISTS idx_state_machine_transitions_actor_id ON state_machine_transitions(actor_id);
+        `).Error
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            DROP TABLE IF EXISTS state_machine_transitions;
+        `).Error
+	},
+}
+
+var AddStateMachinesStateColumns1792792801000 = database.Migration{
+	ID: "1792792801000_AddStateMachinesStateColumns",
+	Migrate: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            ALTER TABLE state_machines
+                ADD COLUMN IF NOT EXISTS current_state VARCHAR(255) NOT NULL DEFAULT '',
+                ADD COLUMN IF NOT EXISTS current_state_at TIMESTAMP WITH TIME ZONE,
+                ADD COLUMN IF NOT EXISTS definition_name VARCHAR(255) NOT NULL DEFAULT '';
+
+            -- current_state_at is {"RFC3339": ...} in the serialized models and a plain timestamp
+            -- string in the blobs converted by AdaptMachineStateBlob
+            UPDATE state_machines SET
+                current_state = COALESCE(blob->>'current_state', ''),
+                current_state_at = CASE
+                    WHEN at_text ~ '^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}' THEN at_text::TIMESTAMP WITH TIME ZONE
+                END,
+                definition_name = COALESCE(blob->>'definition_name', '')
+            FROM (
+                SELECT id AS at_id, COALESCE(
+                    CASE WHEN jsonb_typeof(blob->'current_state_at') = 'object' THEN blob->'current_state_at'->>'RFC3339' END,
+                    CASE WHEN jsonb_typeof(blob->'current_state_at') = 'string' THEN blob->>'current_state_at' END
+                ) AS at_text
+                FROM state_machines
+            ) state_at
+            WHERE state_machines.id = state_at.at_id;
+
+            CREATE INDEX IF NOT EXISTS idx_state_machines_current_state_at ON state_machines(current_state, current_state_at);
+            CREATE INDEX IF NOT EXISTS idx_state_machines_definition_name ON state_machines(definition_name);
+        `).Error
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            DROP INDEX IF EXISTS idx_state_machines_definition_name;
+            DROP INDEX IF EXISTS idx_state_machines_current_state_at;
+            ALTER TABLE state_machines
+                DROP COLUMN IF EXISTS current_state,
+                DROP COLUMN IF EXISTS current_state_at,
+                DROP COLUMN IF EXISTS definition_name;
+        `).Error
+	},
+}
+
+// AddStateMachineTimeoutsStateKey one due timeout per active state, parallel regions have several
+var AddStateMachineTimeoutsStateKey1792879201000 = database.Migration{
+	ID: "1792879201000_AddStateMachineTimeoutsStateKey",
+	Migrate: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            ALTER TABLE state_machine_timeouts DROP CONSTRAINT IF EXISTS state_machine_timeouts_pkey;
+            ALTER TABLE state_machine_timeouts ADD PRIMARY KEY (machine_id, state);
+        `).Error
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return tx.Exec(`
+            DELETE FROM state_machine_timeouts t USING state_machine_timeouts other
+                WHERE t.machine_id = other.machine_id AND t.due_at > other.due_at;
+            ALTER TABLE state_machine_timeouts DROP CONSTRAINT IF EXISTS state_machine_timeouts_pkey;
+            ALTER TABLE state_machine_timeouts ADD PRIMARY KEY (machine_id);
+        `).Error
+	},
+}
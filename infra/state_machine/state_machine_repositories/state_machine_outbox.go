@@ -0,0 +1,103 @@
+package state_machine_repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/pixie-sh/database-helpers-go/database"
+	"gorm.io/gorm/clause"
+
+	"github.com/pixie-sh/core-go/infra/events"
+	"github.com/pixie-sh/core-go/infra/message_wrapper"
+	"github.com/pixie-sh/core-go/infra/state_machine"
+	mapper "github.com/pixie-sh/core-go/pkg/models/serializer"
+)
+
+const defaultOutboxRelayBatch = 100
+
+// StoreWithEvents implements state_machine.TransitionEventsStorage; the machine and its events
+// are stored in the same transaction
+func (r StateMachineRepository) StoreWithEvents(m state_machine.MachineModel, evs ...events.UntypedEventWrapper) error {
+	return r.Transaction(func(tx *database.DB) error {
+		txRepo := r.WithTx(tx)
+		err := txRepo.store(m)
+		if err != nil {
+			return err
+		}
+
+		if len(evs) == 0 {
+			return nil
+		}
+
+		rows := make([]StateMachineOutbox, len(evs))
+		for i, ev := range evs {
+			blob, err := mapper.ToJSONB(ev.UntypedMessage)
+			if err != nil {
+				return err
+			}
+
+			rows[i] = StateMachineOutbox{
+				ID:          ev.ID,
+				MachineID:   m.ID,
+				PayloadType: ev.PayloadType,
+				Blob:        blob,
+				CreatedAt:   ev.Timestamp,
+			}
+		}
+
+		return txRepo.DB.Create(&rows).Error
+	})
+}
+
+// RelayOutbox produces up to limit pending outbox events, oldest first, marking them as published.
+// Rows are locked with SKIP LOCKED so relays can run concurrently on several replicas;
+// returns the number of published events
+func (r StateMachineRepository) RelayOutbox(ctx context.Context, producer events.Producer, limit int) (int, error) {
+	if limit <= 0 {
+		limit = defaultOutboxRelayBatch
+	}
+
+	published := 0
+	err := r.Transaction(func(tx *database.DB) error {
+		var rows []StateMachineOutbox
+		err := tx.Model(&StateMachineOutbox{}).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("published_at IS NULL").
+			Order("created_at").
+			Limit(limit).
+			Find(&rows).Error
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+
+		wrappers := make([]events.UntypedEventWrapper, len(rows))
+		ids := make([]string, len(rows))
+		for i, row := range rows {
+			var msg message_wrapper.UntypedMessage
+			err = mapper.ToStruct(row.Blob, &msg)
+			if err != nil {
+				return err
+			}
+
+			wrappers[i] = events.NewUntypedEventWrapper(msg.ID, msg.FromSenderID, msg.Timestamp, msg.PayloadType, msg.Payload)
+			ids[i] = row.ID
+		}
+
+		err = producer.ProduceBatch(ctx, wrappers...)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&StateMachineOutbox{}).
+			Where("id IN ?", ids).
+			Update("published_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		published = len(rows)
+		return nil
+	})
+
+	return published, err
+}
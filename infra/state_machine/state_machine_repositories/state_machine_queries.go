@@ -0,0 +1,133 @@
+package state_machine_repositories
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	"github.com/pixie-sh/core-go/infra/state_machine"
+	"github.com/pixie-sh/core-go/pkg/models/database_models"
+	mapper "github.com/pixie-sh/core-go/pkg/models/serializer"
+)
+
+// StateMachineFilter filters machines by their promoted columns; zero fields don't filter
+type StateMachineFilter struct {
+	DefinitionName string
+	States         []state_machine.State
+	InStateBefore  *time.Time // entered the current state before; machines in state for longer than an age
+	InStateAfter   *time.Time // entered the current state at or after
+}
+
+// promotedColumns blob fields kept in indexed columns; the current state of hierarchical machines is
+// their first active leaf
+type promotedColumns struct {
+	CurrentState   string     `json:"current_state"`
+	CurrentStateAt *time.Time `json:"current_state_at"`
+	DefinitionName string     `json:"definition_name"`
+}
+
+func promotedColumnsOf(blob database_models.JSONB) (columns promotedColumns, err error) {
+	if len(blob) == 0 {
+		return columns, nil
+	}
+	return columns, mapper.ToStruct(map[string]interface{}(blob), &columns)
+}
+
+func (r StateMachineRepository) filtered(filter StateMachineFilter) *gorm.DB {
+	query := r.DB.Model(&StateMachine{})
+	if len(filter.DefinitionName) > 0 {
+		query = query.Where("definition_name = ?", filter.DefinitionName)
+	}
+	if len(filter.States) > 0 {
+		states := make([]string, len(filter.States))
+		for i, state := range filter.States {
+			states[i] = state.String()
+		}
+		query = query.Where("current_state IN ?", states)
+	}
+	if filter.InStateBefore != nil {
+		query = query.Where("current_state_at < ?", *filter.InStateBefore)
+	}
+	if filter.InStateAfter != nil {
+		query = query.Where("current_state_at >= ?", *filter.InStateAfter)
+	}
+	return query
+}
+
+// GetByFilter up to limit machines matching the filter with machine ID after afterMachineID, by machine ID
+func (r StateMachineRepository) GetByFilter(filter StateMachineFilter, afterMachineID string, limit int) (machines []StateMachine, err error) {
+	return machines, r.filtered(filter).
+		Where("machine_id > ?", afterMachineID).
+		Order("machine_id").
+		Limit(limit).
+		Find(&machines).
+		Error
+}
+
+// GetMachineIDsByFilter as GetByFilter, only the machine IDs
+func (r StateMachineRepository) GetMachineIDsByFilter(filter StateMachineFilter, afterMachineID string, limit int) (machineIDs []string, err error) {
+	return machineIDs, r.filtered(filter).
+		Where("machine_id > ?", afterMachineID).
+		Order("machine_id").
+		Limit(limit).
+		Pluck("machine_id", &machineIDs).
+		Error
+}
+
+// CountByFilter number of machines matching the filter
+func (r StateMachineRepository) CountByFilter(filter StateMachineFilter) (count int64, err error) {
+	return count, r.filtered(filter).Count(&count).Error
+}
+
+// CountByCurrentState number of machines of the definition per current state
+func (r StateMachineRepository) CountByCurrentState(definitionName string) (map[state_machine.State]int64, error) {
+	var rows []struct {
+		CurrentState string
+		Count        int64
+	}
+
+	err := r.filtered(StateMachineFilter{DefinitionName: definitionName}).
+		Select("current_state, COUNT(*) AS count").
+		Group("current_state").
+		Scan(&rows).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[state_machine.State]int64, len(rows))
+	for _, row := range rows {
+		counts[state_machine.State(row.CurrentState)] = row.Count
+	}
+	return counts, nil
+}
+
+// BulkTrigger triggers event on the machines matching the filter, see state_machine.BulkTrigger.
+// Machines are fetched by machine ID cursor, so machines leaving the filter don't shift the batches.
+// Without provider, machines are restored from the repository, without guards nor actions
+func (r StateMachineRepository) BulkTrigger(
+	ctx context.Context,
+	filter StateMachineFilter,
+	event state_machine.Event,
+	payload any,
+	config state_machine.BulkTriggerConfiguration,
+	provider state_machine.MachineProvider,
+	withLocker ...cache.SharedLocker,
+) (state_machine.BulkTriggerResult, error) {
+	if provider == nil {
+		provider = state_machine.RestoreMachineProvider(r)
+	}
+
+	var after string
+	fetch := func(_ context.Context, _ uint64, batchSize uint64) ([]string, error) {
+		machineIDs, err := r.GetMachineIDsByFilter(filter, after, int(batchSize))
+		if len(machineIDs) > 0 {
+			after = machineIDs[len(machineIDs)-1]
+		}
+		return machineIDs, err
+	}
+
+	return state_machine.BulkTrigger(ctx, fetch, provider, event, payload, config, withLocker...)
+}
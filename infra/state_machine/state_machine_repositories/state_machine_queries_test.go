@@ -0,0 +1,34 @@
+package state_machine_repositories
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/state_machine"
+	mapper "github.com/pixie-sh/core-go/pkg/models/serializer"
+)
+
+func TestPromotedColumnsOf(t *testing.T) {
+	at := time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)
+	blob, err := mapper.ToJSONB(state_machine.MachineModel{
+		ID:             "payment-1",
+		CurrentState:   "pending",
+		CurrentStateAt: &at,
+		DefinitionName: "payment",
+	})
+	require.NoError(t, err)
+
+	columns, err := promotedColumnsOf(blob)
+	require.NoError(t, err)
+	assert.Equal(t, "pending", columns.CurrentState)
+	assert.Equal(t, "payment", columns.DefinitionName)
+	require.NotNil(t, columns.CurrentStateAt)
+	assert.True(t, at.Equal(*columns.CurrentStateAt))
+
+	columns, err = promotedColumnsOf(nil)
+	require.NoError(t, err)
+	assert.Nil(t, columns.CurrentStateAt)
+}
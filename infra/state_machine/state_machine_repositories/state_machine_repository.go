@@ -5,9 +5,12 @@ import (
 	"time"
 
 	"github.com/pixie-sh/database-helpers-go/database"
+	"github.com/pixie-sh/errors-go"
+	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
 	"github.com/pixie-sh/core-go/infra/state_machine"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
 	"github.com/pixie-sh/core-go/pkg/models/database_models"
 	mapper "github.com/pixie-sh/core-go/pkg/models/serializer"
 )
@@ -27,35 +30,139 @@ func (r StateMachineRepository) GetByMachineID(machineID string) (sm StateMachin
 		Error
 }
 
+// SaveByMachineID upserts without version check, the persisted version is still incremented
 func (r StateMachineRepository) SaveByMachineID(guid string, machineID string, blob database_models.JSONB) (StateMachine, error) {
+	columns, err := promotedColumnsOf(blob)
+	if err != nil {
+		return StateMachine{}, err
+	}
+
 	now := time.Now()
 	data := StateMachine{
-		ID:        guid,
-		MachineID: machineID,
-		Blob:      blob,
+		ID:             guid,
+		MachineID:      machineID,
+		Blob:           blob,
+		CurrentState:   columns.CurrentState,
+		CurrentStateAt: columns.CurrentStateAt,
+		DefinitionName: columns.DefinitionName,
 		SoftDeletable: database_models.SoftDeletable{
 			CreatedAt: &now,
 			UpdatedAt: &now,
 		},
 	}
 
-	err := r.DB.Clauses(clause.OnConflict{
-		Columns:   []clause.Column{{Name: "machine_id"}},
-		DoUpdates: clause.AssignmentColumns([]string{"id", "blob", "updated_at"}),
+	err = r.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "machine_id"}},
+		DoUpdates: append(
+			clause.AssignmentColumns([]string{"id", "blob", "current_state", "current_state_at", "definition_name", "updated_at"}),
+			clause.Assignment{Column: clause.Column{Name: "version"}, Value: gorm.Expr("state_machines.version + 1")},
+		),
 	}).Create(&data).Error
 
 	return data, err
 }
 
-// Store implements state_machine.StateMachineStorage
+// SaveByMachineIDWithVersion compare-and-swap save; only succeeds if the persisted version is version,
+// persisting version+1. Machines not yet persisted are inserted with version 0.
+func (r StateMachineRepository) SaveByMachineIDWithVersion(guid string, machineID string, blob database_models.JSONB, version int64) (StateMachine, error) {
+	columns, err := promotedColumnsOf(blob)
+	if err != nil {
+		return StateMachine{}, err
+	}
+
+	now := time.Now()
+	data := StateMachine{
+		ID:             guid,
+		MachineID:      machineID,
+		Blob:           blob,
+		Version:        version + 1,
+		CurrentState:   columns.CurrentState,
+		CurrentStateAt: columns.CurrentStateAt,
+		DefinitionName: columns.DefinitionName,
+		SoftDeletable: database_models.SoftDeletable{
+			CreatedAt: &now,
+			UpdatedAt: &now,
+		},
+	}
+
+	result := r.DB.Model(&StateMachine{}).
+		Where("machine_id = ? AND version = ?", machineID, version).
+		Updates(map[string]interface{}{
+			"id":               guid,
+			"blob":             blob,
+			"version":          version + 1,
+			"current_state":    columns.CurrentState,
+			"current_state_at": columns.CurrentStateAt,
+			"definition_name":  columns.DefinitionName,
+			"updated_at":       now,
+		})
+	if result.Error != nil {
+		return data, result.Error
+	}
+	if result.RowsAffected == 1 {
+		return data, nil
+	}
+
+	if version == 0 {
+		result = r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&data)
+		if result.Error != nil {
+			return data, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return data, nil
+		}
+	}
+
+	return data, errors.New("state machine %s was changed, version %d is outdated", machineID, version).
+		WithErrorCode(pixieErrors.StateMachineVersionConflictErrorCode)
+}
+
+// Store implements state_machine.StateMachineStorage; the due timeouts of the active states and the
+// transition history are stored in the same transaction
 func (r StateMachineRepository) Store(m state_machine.MachineModel) error {
+	return r.Transaction(func(tx *database.DB) error {
+		return r.WithTx(tx).store(m)
+	})
+}
+
+func (r StateMachineRepository) store(m state_machine.MachineModel) error {
+	version := m.Version
+	m.Version++
+
+	blob, err := mapper.ToJSONB(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.SaveByMachineIDWithVersion(m.Guid, m.ID, blob, version)
+	if err != nil {
+		return err
+	}
+
+	err = r.syncTimeouts(m)
+	if err != nil {
+		return err
+	}
+
+	return r.appendHistory(m)
+}
+
+// Archive implements state_machine.MachineArchive; upserts the machine and its due timeouts without version check
+func (r StateMachineRepository) Archive(m state_machine.MachineModel) error {
 	blob, err := mapper.ToJSONB(m)
 	if err != nil {
 		return err
 	}
 
-	_, err = r.SaveByMachineID(m.Guid, m.ID, blob)
-	return err
+	return r.Transaction(func(tx *database.DB) error {
+		repository := r.WithTx(tx)
+		_, err := repository.SaveByMachineID(m.Guid, m.ID, blob)
+		if err != nil {
+			return err
+		}
+
+		return repository.syncTimeouts(m)
+	})
 }
 
 // Get implements state_machine.StateMachineStorage
@@ -71,6 +178,7 @@ func (r StateMachineRepository) Get(machineID string) (state_machine.MachineMode
 		return machineModel, err
 	}
 
+	machineModel.Version = machineEntity.Version
 	return machineModel, nil
 }
 
@@ -0,0 +1,95 @@
+package state_machine_repositories
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pixie-sh/core-go/infra/state_machine"
+)
+
+// ClaimDueTimeouts implements state_machine.TimeoutStorage; claimed rows are leased with
+// SKIP LOCKED so concurrent replicas claim different timeouts
+func (r StateMachineRepository) ClaimDueTimeouts(_ context.Context, now time.Time, lease time.Duration, limit int) ([]state_machine.DueTimeout, error) {
+	var rows []StateMachineTimeout
+	err := r.DB.Raw(`
+            UPDATE state_machine_timeouts SET locked_until = ?
+            WHERE (machine_id, state) IN (
+                SELECT machine_id, state FROM state_machine_timeouts
+                WHERE due_at <= ? AND (locked_until IS NULL OR locked_until < ?)
+                ORDER BY due_at
+                LIMIT ?
+                FOR UPDATE SKIP LOCKED
+            )
+            RETURNING machine_id, state, event, state_at, due_at, locked_until
+        `, now.Add(lease), now, now, limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	due := make([]state_machine.DueTimeout, len(rows))
+	for i, row := range rows {
+		due[i] = state_machine.DueTimeout{
+			MachineID: row.MachineID,
+			State:     state_machine.State(row.State),
+			Event:     state_machine.Event(row.Event),
+			StateAt:   row.StateAt,
+			DueAt:     row.DueAt,
+		}
+	}
+
+	return due, nil
+}
+
+// DeleteTimeout implements state_machine.TimeoutStorage; only deletes the timeout if it wasn't rescheduled
+func (r StateMachineRepository) DeleteTimeout(_ context.Context, timeout state_machine.DueTimeout) error {
+	return r.DB.
+		Where("machine_id = ? AND state = ? AND state_at = ?", timeout.MachineID, timeout.State.String(), timeout.StateAt.Truncate(time.Second)).
+		Delete(&StateMachineTimeout{}).Error
+}
+
+// syncTimeouts keeps the due timeouts of the model active states
+func (r StateMachineRepository) syncTimeouts(m state_machine.MachineModel) error {
+	timeouts := m.DueTimeouts()
+
+	states := make([]string, len(timeouts))
+	for i, timeout := range timeouts {
+		states[i] = timeout.State.String()
+	}
+
+	stale := r.DB.Where("machine_id = ?", m.ID)
+	if len(states) > 0 {
+		stale = stale.Where("state NOT IN ?", states)
+	}
+	err := stale.Delete(&StateMachineTimeout{}).Error
+	if err != nil {
+		return err
+	}
+
+	// claims are kept while the state wasn't reentered, so storing a machine doesn't free a claimed timeout
+	for _, timeout := range timeouts {
+		err = r.DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "machine_id"}, {Name: "state"}},
+			DoUpdates: append(
+				clause.AssignmentColumns([]string{"event", "state_at", "due_at"}),
+				clause.Assignment{Column: clause.Column{Name: "locked_until"}, Value: gorm.Expr(
+					"CASE WHEN state_machine_timeouts.state_at <> EXCLUDED.state_at THEN NULL ELSE state_machine_timeouts.locked_until END",
+				)},
+			),
+		}).Create(&StateMachineTimeout{
+			MachineID: timeout.MachineID,
+			State:     timeout.State.String(),
+			Event:     timeout.Event.String(),
+			StateAt:   timeout.StateAt.Truncate(time.Second),
+			DueAt:     timeout.DueAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
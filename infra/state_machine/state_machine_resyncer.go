@@ -8,6 +8,8 @@ import (
 
 // ResyncTransitionsAndStates DO NOT USE unless you know what is going on
 // this will screw up any provided stateMachine to the transitions and states you are setting it to
+//
+// Deprecated: register versioned definitions and use DefinitionRegistry.Migrate
 func ResyncTransitionsAndStates(ctx context.Context, stateMachine *Machine, transitions []Transition, states []State) {
 	log := pixieCtx.GetCtxLogger(ctx)
 	if len(transitions) == 0 || len(states) == 0 {
@@ -0,0 +1,303 @@
+package state_machine
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	"github.com/pixie-sh/core-go/infra/cron"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+const (
+	defaultTimeoutSchedule  = "@every 1m"
+	defaultTimeoutBatchSize = 100
+	defaultTimeoutLease     = time.Minute
+)
+
+// StateTimeout event fired once the machine stays in the state for After
+type StateTimeout struct {
+	After coretime.Duration `json:"after" toml:"after"`
+	Event Event             `json:"event" toml:"event"`
+}
+
+// DueTimeout timeout of the state entered at StateAt, due at DueAt
+type DueTimeout struct {
+	MachineID string    `json:"machine_id"`
+	State     State     `json:"state"`
+	Event     Event     `json:"event"`
+	StateAt   time.Time `json:"state_at"`
+	DueAt     time.Time `json:"due_at"`
+}
+
+// Matches whether both refer to the same stay in the same state; times are compared
+// with second precision, the precision of the times of serialized models
+func (d DueTimeout) Matches(other DueTimeout) bool {
+	return d.MachineID == other.MachineID &&
+		d.State == other.State &&
+		d.Event == other.Event &&
+		d.StateAt.Truncate(time.Second).Equal(other.StateAt.Truncate(time.Second))
+}
+
+// TimeoutStorage persisted due timeouts. Implementations keep, on each StateMachineStorage.Store,
+// the due timeouts of the stored active states (MachineModel.DueTimeouts) and remove the others.
+// ClaimDueTimeouts returns up to limit timeouts due at now, hiding them from other claims during lease,
+// so replicas sharing the storage don't fire the same timeout concurrently
+type TimeoutStorage interface {
+	ClaimDueTimeouts(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]DueTimeout, error)
+	DeleteTimeout(ctx context.Context, timeout DueTimeout) error
+}
+
+// DueTimeout timeout of the current state, if it has one
+func (m MachineModel) DueTimeout() (DueTimeout, bool) {
+	if m.CurrentStateAt == nil {
+		return DueTimeout{}, false
+	}
+
+	return dueTimeout(m.ID, m.Timeouts, m.CurrentState, *m.CurrentStateAt)
+}
+
+// DueTimeouts timeouts of the active states, one per active region of parallel states
+func (m MachineModel) DueTimeouts() []DueTimeout {
+	if m.CurrentStateAt == nil {
+		return nil
+	}
+
+	active := m.ActiveStates
+	if len(active) == 0 {
+		active = []State{m.CurrentState}
+	}
+
+	var due []DueTimeout
+	for _, state := range active {
+		stateAt, ok := m.ActiveStatesAt[state]
+		if !ok {
+			stateAt = *m.CurrentStateAt
+		}
+
+		if timeout, ok := dueTimeout(m.ID, m.Timeouts, state, stateAt); ok {
+			due = append(due, timeout)
+		}
+	}
+	return due
+}
+
+// AddTimeout fires event once the machine stays in state for after; the event must be a
+// transition of the state. Timeouts are fired by a TimeoutScheduler on the persisted machine
+func (m *Machine) AddTimeout(state State, after time.Duration, event Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.states[state]; !exists {
+		return errors.New("state '%s' does not exist", state).WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+	if after <= 0 {
+		return errors.New("timeout of state '%s' must be positive", state).WithErrorCode(errors.StateMachineInvalidStateErrorCode)
+	}
+
+	_, regular := m.transitions[state][event]
+	_, conditional := m.conditionalsTransitions[state][event]
+	if !regular && !conditional {
+		return errors.New("timeout event '%s' is not a transition of '%s'", event, state).
+			WithErrorCode(errors.StateMachineInvalidTransitionErrorCode)
+	}
+
+	m.timeouts[state] = StateTimeout{After: coretime.Duration(after), Event: event}
+	return nil
+}
+
+// DueTimeout timeout of the current state, if it has one
+func (m *Machine) DueTimeout() (DueTimeout, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return dueTimeout(m.id, m.timeouts, m.currentState, m.currentStateAt)
+}
+
+// DueTimeouts timeouts of the active states, one per active region of parallel states
+func (m *Machine) DueTimeouts() []DueTimeout {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var due []DueTimeout
+	for _, state := range m.activeUnlocked() {
+		if timeout, ok := dueTimeout(m.id, m.timeouts, state, m.activeStateAtUnlocked(state)); ok {
+			due = append(due, timeout)
+		}
+	}
+	return due
+}
+
+func dueTimeout(machineID string, timeouts map[State]StateTimeout, state State, stateAt time.Time) (DueTimeout, bool) {
+	timeout, ok := timeouts[state]
+	if !ok {
+		return DueTimeout{}, false
+	}
+
+	return DueTimeout{
+		MachineID: machineID,
+		State:     state,
+		Event:     timeout.Event,
+		StateAt:   stateAt,
+		DueAt:     stateAt.Add(timeout.After.Duration()),
+	}, true
+}
+
+// MachineProvider returns the machine, restored, with its guards and actions
+type MachineProvider func(ctx context.Context, machineID string) (*Machine, error)
+
+// RestoreMachineProvider provides machines restored from storage, without guards nor actions
+func RestoreMachineProvider(storage StateMachineStorage) MachineProvider {
+	return func(ctx context.Context, machineID string) (*Machine, error) {
+		m := NewMachine(ctx, machineID, storage)
+		return m, m.Restore()
+	}
+}
+
+type TimeoutSchedulerConfiguration struct {
+	Schedule       string                      `json:"schedule"`   // cron schedule of FireDue; @every 1m if not set
+	BatchSize      int                         `json:"batch_size"` // timeouts claimed per FireDue; 100 if not set
+	Lease          coretime.Duration           `json:"lease"`      // claimed timeouts are retried after the lease if not fired; 1m if not set
+	TriggerAndSave TriggerAndSaveConfiguration `json:"trigger_and_save"`
+}
+
+// TimeoutScheduler fires the due timeouts of persisted machines
+type TimeoutScheduler struct {
+	config   TimeoutSchedulerConfiguration
+	timeouts TimeoutStorage
+	provider MachineProvider
+	locker   cache.SharedLocker
+}
+
+// NewTimeoutScheduler scheduler of the timeouts; with a locker, timeouts are fired while holding the machine lock
+func NewTimeoutScheduler(
+	_ context.Context,
+	config TimeoutSchedulerConfiguration,
+	timeouts TimeoutStorage,
+	provider MachineProvider,
+	withLocker ...cache.SharedLocker,
+) *TimeoutScheduler {
+	if len(config.Schedule) == 0 {
+		config.Schedule = defaultTimeoutSchedule
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultTimeoutBatchSize
+	}
+	if config.Lease <= 0 {
+		config.Lease = coretime.Duration(defaultTimeoutLease)
+	}
+
+	s := &TimeoutScheduler{
+		config:   config,
+		timeouts: timeouts,
+		provider: provider,
+	}
+	if len(withLocker) > 0 {
+		s.locker = withLocker[0]
+	}
+
+	return s
+}
+
+// Register adds FireDue to the cron manager with the configured schedule
+func (s *TimeoutScheduler) Register(ctx context.Context, manager *cron.Manager) (int, error) {
+	job, err := cron.NewJob("state_machine_timeouts", "fires due state machine timeouts", func() {
+		_, err := s.FireDue(ctx)
+		if err != nil {
+			pixiecontext.GetCtxLogger(ctx).With("error", err).Error("error firing state machine timeouts")
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return manager.AddJob(s.config.Schedule, job)
+}
+
+// FireDue claims and fires the due timeouts, returns the number of fired timeouts.
+// A timeout is only fired if the machine is still in the state it was scheduled for; the
+// DueTimeout is the trigger payload. Timeouts failing to fire are retried after the lease,
+// unless the failure is permanent (see isPermanentTimeoutError), then they're discarded
+func (s *TimeoutScheduler) FireDue(ctx context.Context) (int, error) {
+	log := pixiecontext.GetCtxLogger(ctx)
+
+	due, err := s.timeouts.ClaimDueTimeouts(ctx, time.Now().UTC(), s.config.Lease.Duration(), s.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	var lockers []cache.SharedLocker
+	if s.locker != nil {
+		lockers = append(lockers, s.locker)
+	}
+
+	fired := 0
+	for _, timeout := range due {
+		timeoutLog := log.With("machine_id", timeout.MachineID).With("state", timeout.State)
+
+		machine, err := s.provider(ctx, timeout.MachineID)
+		if err != nil {
+			if !isPermanentTimeoutError(err) {
+				timeoutLog.With("error", err).Error("error providing state machine for timeout")
+				continue
+			}
+
+			timeoutLog.With("error", err).Warn("state machine for timeout not provided, timeout discarded")
+			s.deleteTimeout(ctx, timeout)
+			continue
+		}
+
+		_, triggered, err := triggerAndSave(ctx, machine, timeout.Event, timeout, s.config.TriggerAndSave, func(m *Machine) bool {
+			for _, current := range m.DueTimeouts() {
+				if current.Matches(timeout) {
+					return true
+				}
+			}
+			return false
+		}, lockers...)
+
+		switch {
+		case err != nil && !isPermanentTimeoutError(err):
+			timeoutLog.With("error", err).Error("error firing state machine timeout")
+			continue
+		case err != nil:
+			timeoutLog.With("error", err).Warn("state machine timeout rejected, timeout discarded")
+		case triggered:
+			fired++
+		default:
+			timeoutLog.Debug("state machine left the state, timeout discarded")
+		}
+
+		s.deleteTimeout(ctx, timeout)
+	}
+
+	return fired, nil
+}
+
+func (s *TimeoutScheduler) deleteTimeout(ctx context.Context, timeout DueTimeout) {
+	err := s.timeouts.DeleteTimeout(ctx, timeout)
+	if err != nil {
+		pixiecontext.GetCtxLogger(ctx).
+			With("error", err).
+			With("machine_id", timeout.MachineID).
+			With("state", timeout.State).
+			Warn("error deleting state machine timeout")
+	}
+}
+
+// isPermanentTimeoutError client errors, e.g. guard rejections, invalid transitions or machines not
+// found, fail again on retry; conflicts and throttling, like server errors, may succeed later
+func isPermanentTimeoutError(err error) bool {
+	e, ok := errors.As(err)
+	if !ok {
+		return false
+	}
+
+	status := e.Code.HTTPError
+	return status >= http.StatusBadRequest && status < http.StatusInternalServerError &&
+		status != http.StatusConflict && status != http.StatusTooManyRequests
+}
@@ -0,0 +1,288 @@
+package state_machine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+// timeoutsStorage in memory StateMachineStorage keeping the due timeouts on Store, by machine and state
+type timeoutsStorage struct {
+	*versionedStorage
+
+	mu       sync.Mutex
+	timeouts map[string]DueTimeout
+	leases   map[string]time.Time
+}
+
+func newTimeoutsStorage() *timeoutsStorage {
+	return &timeoutsStorage{
+		versionedStorage: newVersionedStorage(),
+		timeouts:         make(map[string]DueTimeout),
+		leases:           make(map[string]time.Time),
+	}
+}
+
+func (s *timeoutsStorage) Store(model MachineModel) error {
+	err := s.versionedStorage.Store(model)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, timeout := range s.timeouts {
+		if timeout.MachineID == model.ID {
+			delete(s.timeouts, key)
+			delete(s.leases, key)
+		}
+	}
+	for _, timeout := range model.DueTimeouts() {
+		s.timeouts[timeoutKey(timeout)] = timeout
+	}
+	return nil
+}
+
+func timeoutKey(timeout DueTimeout) string {
+	return timeout.MachineID + "/" + timeout.State.String()
+}
+
+func (s *timeoutsStorage) ClaimDueTimeouts(_ context.Context, now time.Time, lease time.Duration, limit int) ([]DueTimeout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []DueTimeout
+	for id, timeout := range s.timeouts {
+		if len(due) == limit {
+			break
+		}
+		if timeout.DueAt.After(now) || s.leases[id].After(now) {
+			continue
+		}
+		s.leases[id] = now.Add(lease)
+		due = append(due, timeout)
+	}
+	return due, nil
+}
+
+func (s *timeoutsStorage) DeleteTimeout(_ context.Context, timeout DueTimeout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.timeouts[timeoutKey(timeout)]; ok && current.Matches(timeout) {
+		delete(s.timeouts, timeoutKey(timeout))
+	}
+	return nil
+}
+
+func newPaymentMachine(t *testing.T, storage StateMachineStorage, after time.Duration) *Machine {
+	m := NewMachine(context.Background(), "payment", storage)
+	m.AddState("pending")
+	m.AddState("paid")
+	m.AddState("expired")
+	require.NoError(t, m.SetInitialState("pending"))
+	require.NoError(t, m.AddTransition("pending", "pay", "paid"))
+	require.NoError(t, m.AddTransition("pending", "expire", "expired"))
+	require.NoError(t, m.AddTimeout("pending", after, "expire"))
+	return m
+}
+
+func TestAddTimeout_Invalid(t *testing.T) {
+	m := newPaymentMachine(t, newVersionedStorage(), time.Hour)
+	assert.Error(t, m.AddTimeout("unknown", time.Hour, "expire"))
+	assert.Error(t, m.AddTimeout("pending", 0, "expire"))
+	assert.Error(t, m.AddTimeout("paid", time.Hour, "expire"))
+}
+
+func TestTimeoutScheduler_FireDue(t *testing.T) {
+	ctx := context.Background()
+	storage := newTimeoutsStorage()
+	require.NoError(t, newPaymentMachine(t, storage, time.Millisecond).Save())
+
+	scheduler := NewTimeoutScheduler(ctx, TimeoutSchedulerConfiguration{}, storage, RestoreMachineProvider(storage))
+	time.Sleep(5 * time.Millisecond)
+
+	fired, err := scheduler.FireDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fired)
+
+	persisted, err := storage.Get("payment")
+	require.NoError(t, err)
+	assert.Equal(t, State("expired"), persisted.CurrentState)
+	assert.Empty(t, storage.timeouts)
+
+	fired, err = scheduler.FireDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, fired)
+}
+
+func TestTimeoutScheduler_NotDue(t *testing.T) {
+	ctx := context.Background()
+	storage := newTimeoutsStorage()
+	require.NoError(t, newPaymentMachine(t, storage, time.Hour).Save())
+
+	scheduler := NewTimeoutScheduler(ctx, TimeoutSchedulerConfiguration{}, storage, RestoreMachineProvider(storage))
+	fired, err := scheduler.FireDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, fired)
+	assert.Len(t, storage.timeouts, 1)
+}
+
+func TestTimeoutScheduler_DiscardsStaleTimeouts(t *testing.T) {
+	ctx := context.Background()
+	storage := newTimeoutsStorage()
+	m := newPaymentMachine(t, storage, time.Millisecond)
+	require.NoError(t, m.Save())
+
+	stale, ok := m.DueTimeout()
+	require.True(t, ok)
+
+	_, err := m.Trigger(ctx, "pay")
+	require.NoError(t, err)
+	require.NoError(t, m.Save())
+
+	// a replica claimed the timeout before the machine left the state
+	storage.timeouts[timeoutKey(stale)] = stale
+	time.Sleep(5 * time.Millisecond)
+
+	scheduler := NewTimeoutScheduler(ctx, TimeoutSchedulerConfiguration{}, storage, RestoreMachineProvider(storage))
+	fired, err := scheduler.FireDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, fired)
+	assert.Empty(t, storage.timeouts)
+	assert.Equal(t, State("paid"), m.CurrentState())
+}
+
+func TestTimeoutScheduler_LeasedTimeoutsAreNotClaimedAgain(t *testing.T) {
+	ctx := context.Background()
+	storage := newTimeoutsStorage()
+	require.NoError(t, newPaymentMachine(t, storage, time.Millisecond).Save())
+	time.Sleep(5 * time.Millisecond)
+
+	claimed, err := storage.ClaimDueTimeouts(ctx, time.Now(), time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+
+	scheduler := NewTimeoutScheduler(ctx, TimeoutSchedulerConfiguration{}, storage, RestoreMachineProvider(storage))
+	fired, err := scheduler.FireDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, fired)
+}
+
+func TestDefinition_Timeouts(t *testing.T) {
+	definition := Definition{
+		Name:           "payment",
+		Version:        1,
+		States:         []State{"pending", "paid", "expired"},
+		InitialState:   "pending",
+		TerminalStates: []State{"paid", "expired"},
+		Transitions: []TransitionDefinition{
+			{From: "pending", Event: "pay", To: "paid"},
+			{From: "pending", Event: "expire", To: "expired"},
+		},
+		Timeouts: []TimeoutDefinition{{State: "pending", After: coretime.Duration(48 * time.Hour), Event: "expire"}},
+	}
+
+	m, err := NewMachineFromDefinition(context.Background(), "payment", definition, newVersionedStorage())
+	require.NoError(t, err)
+
+	timeout, ok := m.DueTimeout()
+	require.True(t, ok)
+	assert.Equal(t, Event("expire"), timeout.Event)
+	assert.Equal(t, 48*time.Hour, timeout.DueAt.Sub(timeout.StateAt))
+
+	definition.Timeouts = append(definition.Timeouts, TimeoutDefinition{State: "paid", After: 1, Event: "expire"})
+	assert.Error(t, definition.Validate())
+}
+
+func TestDueTimeout_MatchesRestoredModel(t *testing.T) {
+	stateAt := time.Date(2026, 3, 1, 10, 30, 0, 123456789, time.UTC)
+	timeout := DueTimeout{MachineID: "payment", State: "pending", Event: "expire", StateAt: stateAt}
+
+	restored := timeout
+	restored.StateAt = stateAt.Truncate(time.Second)
+	assert.True(t, timeout.Matches(restored))
+
+	restored.StateAt = stateAt.Add(time.Second)
+	assert.False(t, timeout.Matches(restored))
+}
+
+func TestTimeoutScheduler_PermanentFailuresAreDiscarded(t *testing.T) {
+	ctx := context.Background()
+	storage := newTimeoutsStorage()
+	require.NoError(t, newPaymentMachine(t, storage, time.Millisecond).Save())
+	time.Sleep(5 * time.Millisecond)
+
+	provider := func(rejected bool) MachineProvider {
+		return func(ctx context.Context, machineID string) (*Machine, error) {
+			m := newPaymentMachine(t, storage, time.Millisecond)
+			if rejected {
+				require.NoError(t, m.AddGuard("pending", "expire", "expired", func(context.Context, TransitionContext) error {
+					return errors.New("payment in progress")
+				}))
+			} else {
+				require.NoError(t, m.OnEnter("expired", func(context.Context, TransitionContext) error {
+					return errors.New("notification service unavailable")
+				}))
+			}
+			return m, m.Restore()
+		}
+	}
+
+	// failing actions may succeed later, the timeout is retried after the lease
+	scheduler := NewTimeoutScheduler(ctx, TimeoutSchedulerConfiguration{Lease: coretime.Duration(time.Millisecond)}, storage, provider(false))
+	fired, err := scheduler.FireDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, fired)
+	assert.Len(t, storage.timeouts, 1)
+	time.Sleep(5 * time.Millisecond)
+
+	// rejected by a guard, it would be rejected on every claim
+	scheduler = NewTimeoutScheduler(ctx, TimeoutSchedulerConfiguration{}, storage, provider(true))
+	fired, err = scheduler.FireDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, fired)
+	assert.Empty(t, storage.timeouts)
+
+	persisted, err := storage.Get("payment")
+	require.NoError(t, err)
+	assert.Equal(t, State("pending"), persisted.CurrentState)
+}
+
+func TestTimeoutScheduler_ParallelRegions(t *testing.T) {
+	ctx := context.Background()
+	storage := newTimeoutsStorage()
+	m := newOnboardingMachine(t, storage)
+	require.NoError(t, m.AddTimeout("kyc_pending", time.Hour, "verify"))
+	require.NoError(t, m.AddTimeout("payment_pending", time.Millisecond, "add_card"))
+
+	_, err := m.Trigger(ctx, "start")
+	require.NoError(t, err)
+	require.NoError(t, m.Save())
+	assert.Len(t, m.DueTimeouts(), 2)
+	assert.Len(t, storage.timeouts, 2)
+	time.Sleep(5 * time.Millisecond)
+
+	// the payment region leaf isn't the current state
+	scheduler := NewTimeoutScheduler(ctx, TimeoutSchedulerConfiguration{}, storage, RestoreMachineProvider(storage))
+	fired, err := scheduler.FireDue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, fired)
+
+	persisted, err := storage.Get("onboarding-1")
+	require.NoError(t, err)
+	assert.Equal(t, []State{"kyc_pending", "payment_done"}, persisted.ActiveStates)
+
+	// the kyc region stays in its state since it was entered
+	due := persisted.DueTimeouts()
+	require.Len(t, due, 1)
+	assert.Equal(t, State("kyc_pending"), due[0].State)
+	assert.True(t, due[0].StateAt.Before(*persisted.CurrentStateAt))
+}
@@ -0,0 +1,87 @@
+package http_middlewares
+
+import (
+	"fmt"
+
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/rate_limiter"
+	"github.com/pixie-sh/core-go/pkg/comm/http"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+)
+
+const rateLimitKeyPrefix = "rate_limit"
+
+// RateLimitKeyFunc key the request is limited by; requests with an empty key are not limited
+type RateLimitKeyFunc func(ctx http.ServerCtx) string
+
+// RateLimitByIP limits by client IP
+func RateLimitByIP() RateLimitKeyFunc {
+	return func(ctx http.ServerCtx) string {
+		return ctx.IP()
+	}
+}
+
+// RateLimitByHeader limits by the value of header, e.g. an API key
+func RateLimitByHeader(header string) RateLimitKeyFunc {
+	return func(ctx http.ServerCtx) string {
+		return ctx.Get(header)
+	}
+}
+
+// RateLimitByLocals limits by the value in Locals under key, e.g. the authenticated user set by an auth middleware
+func RateLimitByLocals(key string) RateLimitKeyFunc {
+	return func(ctx http.ServerCtx) string {
+		value := ctx.Locals(key)
+		if value == nil {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+}
+
+// RateLimit limits the requests of the route it is mounted on, per key; withLimit overrides the limiter
+// limit for the route. Sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers and
+// responds 429 with Retry-After when exceeded. Limiter errors don't block requests.
+// Mount it per route, mounted with Use the route is the group's so all its routes share one bucket;
+// see RateLimitScope for that
+func RateLimit(limiter rate_limiter.ILimiter, keyFunc RateLimitKeyFunc, withLimit ...rate_limiter.Limit) http.ServerHandler {
+	return rateLimit(limiter, keyFunc, func(ctx http.ServerCtx) string {
+		route := ctx.Route()
+		return route.Method + ":" + route.Path
+	}, withLimit...)
+}
+
+// RateLimitScope as RateLimit with one bucket per key shared by the routes it is mounted on under scope,
+// e.g. mounted with Use on a group
+func RateLimitScope(scope string, limiter rate_limiter.ILimiter, keyFunc RateLimitKeyFunc, withLimit ...rate_limiter.Limit) http.ServerHandler {
+	return rateLimit(limiter, keyFunc, func(http.ServerCtx) string {
+		return scope
+	}, withLimit...)
+}
+
+func rateLimit(limiter rate_limiter.ILimiter, keyFunc RateLimitKeyFunc, scopeFunc func(ctx http.ServerCtx) string, withLimit ...rate_limiter.Limit) http.ServerHandler {
+	return func(ctx http.ServerCtx) error {
+		key := keyFunc(ctx)
+		if len(key) == 0 {
+			return ctx.Next()
+		}
+
+		result, err := limiter.Allow(ctx.UserContext(), fmt.Sprintf("%s:%s:%s", rateLimitKeyPrefix, scopeFunc(ctx), key), withLimit...)
+		if err != nil {
+			http.GetCtxLogger(ctx).With("error", err).Warn("error rate limiting request")
+			return ctx.Next()
+		}
+
+		for header, value := range result.Headers() {
+			ctx.Set(header, value)
+		}
+
+		if !result.Allowed {
+			return http.APIError(ctx, errors.New("rate limit exceeded, retry in %s", result.RetryAfter).
+				WithErrorCode(pixieErrors.RateLimitExceededErrorCode))
+		}
+
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,108 @@
+package http_middlewares
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/rate_limiter"
+	"github.com/pixie-sh/core-go/pkg/comm/http"
+)
+
+// countingLimiter allows limit requests per key
+type countingLimiter struct {
+	limit int
+	keys  map[string]int
+}
+
+func (l *countingLimiter) Allow(_ context.Context, key string, withLimit ...rate_limiter.Limit) (rate_limiter.Result, error) {
+	limit := l.limit
+	if len(withLimit) > 0 {
+		limit = withLimit[0].Limit
+	}
+
+	l.keys[key]++
+	remaining := limit - l.keys[key]
+	return rate_limiter.Result{
+		Allowed:    remaining >= 0,
+		Limit:      limit,
+		Remaining:  max(remaining, 0),
+		ResetAfter: time.Minute,
+		RetryAfter: 1500 * time.Millisecond,
+	}, nil
+}
+
+func TestRateLimit(t *testing.T) {
+	limiter := &countingLimiter{limit: 1, keys: make(map[string]int)}
+	app := fiber.New(fiber.Config(http.DefaultServerConfiguration))
+	ok := func(ctx http.ServerCtx) error { return http.APIResponse(ctx, "ok") }
+	app.Get("/items", RateLimit(limiter, RateLimitByHeader("X-API-Key")), ok)
+	app.Get("/exports", RateLimit(limiter, RateLimitByHeader("X-API-Key"), rate_limiter.Limit{Limit: 2, Window: time.Hour}), ok)
+
+	get := func(path string, apiKey string) (int, map[string]string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if len(apiKey) > 0 {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode, map[string]string{
+			rate_limiter.HeaderRateLimitLimit:     resp.Header.Get(rate_limiter.HeaderRateLimitLimit),
+			rate_limiter.HeaderRateLimitRemaining: resp.Header.Get(rate_limiter.HeaderRateLimitRemaining),
+			rate_limiter.HeaderRateLimitReset:     resp.Header.Get(rate_limiter.HeaderRateLimitReset),
+			rate_limiter.HeaderRetryAfter:         resp.Header.Get(rate_limiter.HeaderRetryAfter),
+		}
+	}
+
+	status, headers := get("/items", "key-1")
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "1", headers[rate_limiter.HeaderRateLimitLimit])
+	assert.Equal(t, "0", headers[rate_limiter.HeaderRateLimitRemaining])
+	assert.Equal(t, "60", headers[rate_limiter.HeaderRateLimitReset])
+	assert.Empty(t, headers[rate_limiter.HeaderRetryAfter])
+
+	status, headers = get("/items", "key-1")
+	assert.Equal(t, 429, status)
+	assert.Equal(t, "2", headers[rate_limiter.HeaderRetryAfter])
+
+	status, _ = get("/items", "key-2")
+	assert.Equal(t, 200, status)
+
+	status, _ = get("/items", "")
+	assert.Equal(t, 200, status)
+
+	for i := 0; i < 2; i++ {
+		status, headers = get("/exports", "key-1")
+		assert.Equal(t, 200, status)
+		assert.Equal(t, "2", headers[rate_limiter.HeaderRateLimitLimit])
+	}
+	status, _ = get("/exports", "key-1")
+	assert.Equal(t, 429, status)
+}
+
+func TestRateLimitScope(t *testing.T) {
+	limiter := &countingLimiter{limit: 2, keys: make(map[string]int)}
+	app := fiber.New(fiber.Config(http.DefaultServerConfiguration))
+	ok := func(ctx http.ServerCtx) error { return http.APIResponse(ctx, "ok") }
+	api := app.Group("/api", RateLimitScope("api", limiter, RateLimitByHeader("X-API-Key")))
+	api.Get("/items", ok)
+	api.Get("/exports", ok)
+
+	get := func(path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-API-Key", "key-1")
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, 200, get("/api/items"))
+	assert.Equal(t, 200, get("/api/exports"))
+	assert.Equal(t, 429, get("/api/items"))
+	assert.Contains(t, limiter.keys, "rate_limit:api:key-1")
+}
@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	goHttp "net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	pixieEnv "github.com/pixie-sh/core-go/pkg/env"
@@ -19,6 +20,7 @@ import (
 	"github.com/pixie-sh/logger-go/env"
 	"github.com/pixie-sh/logger-go/logger"
 
+	"github.com/pixie-sh/core-go/infra/rate_limiter"
 	"github.com/pixie-sh/core-go/pkg/comm/http"
 	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
 	"github.com/pixie-sh/core-go/pkg/types"
@@ -42,6 +44,7 @@ type Client struct {
 	headerKey string
 	apiKeys   map[string]string
 	client    *goHttp.Client
+	semaphore rate_limiter.ISemaphore
 }
 
 // NewClient receives the header key to be used for ms authorization,
@@ -68,6 +71,7 @@ func NewClient(_ context.Context, cfg *ClientConfiguration) Client {
 		headerKey: cfg.HeaderAPIKey,
 		apiKeys:   cfg.APIKeys,
 		client:    client,
+		semaphore: cfg.Semaphore,
 	}
 }
 
@@ -132,11 +136,31 @@ func (c *Client) Do(ctx context.Context, method http.Method, fullURL string, bod
 		With("fourHopsCaller", caller.NewCaller(caller.FourHopsCallerDepth)).
 		Debug("executing request to %s", fullURL)
 
+	lease, err := c.acquire(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	release := func() {}
+	if lease != nil {
+		leaseCtx, stop := lease.KeepAlive(ctx)
+		req = req.WithContext(leaseCtx)
+		release = func() {
+			stop()
+			c.release(ctx, lease)
+		}
+	}
+
 	res, err := c.client.Do(req)
 	if err != nil {
+		release()
 		return nil, res, errors.NewWithError(err, "error performing rest request").WithErrorCode(errors.ErrorPerformingRequestErrorCode)
 	}
 
+	if lease != nil {
+		res.Body = &leaseBody{ReadCloser: res.Body, release: release}
+	}
+
 	if res.StatusCode < goHttp.StatusOK || res.StatusCode >= goHttp.StatusBadRequest {
 		ec := errors.ErrorPerformingRequestErrorCode
 
@@ -147,6 +171,38 @@ func (c *Client) Do(ctx context.Context, method http.Method, fullURL string, bod
 	return res.Body, res, nil
 }
 
+// acquire a lease of the request host when the client has a semaphore
+func (c *Client) acquire(ctx context.Context, req *goHttp.Request) (*rate_limiter.Lease, error) {
+	if c.semaphore == nil {
+		return nil, nil
+	}
+
+	return c.semaphore.Acquire(ctx, "rest:"+req.URL.Host)
+}
+
+func (c *Client) release(ctx context.Context, lease *rate_limiter.Lease) {
+	if lease == nil {
+		return
+	}
+
+	if err := lease.Release(context.WithoutCancel(ctx)); err != nil {
+		pixiecontext.GetCtxLogger(ctx).With("error", err).Warn("error releasing rest request lease")
+	}
+}
+
+// leaseBody keeps the request lease alive until the response body is closed, then releases it
+type leaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *leaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
 func printCurlFormat(req *goHttp.Request) ([]byte, error) {
 	var curlCmd bytes.Buffer
 
@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"context"
+	goHttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/pixie-sh/errors-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/cache"
+	"github.com/pixie-sh/core-go/infra/rate_limiter"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+func TestClient_Semaphore(t *testing.T) {
+	server := httptest.NewServer(goHttp.HandlerFunc(func(w goHttp.ResponseWriter, _ *goHttp.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	redisCache, err := cache.NewRedisCache(context.Background(), cache.RedisCacheConfiguration{Address: mr.Addr()})
+	require.NoError(t, err)
+	semaphore, err := rate_limiter.NewSemaphore(context.Background(), rate_limiter.SemaphoreConfiguration{
+		Limit:   1,
+		MaxWait: coretime.Duration(20 * time.Millisecond),
+	}, redisCache)
+	require.NoError(t, err)
+
+	client := NewClient(context.Background(), &ClientConfiguration{Timeout: 1000, Semaphore: semaphore})
+	ctx := context.Background()
+
+	body, _, err := client.Do(ctx, goHttp.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	// the host slot is held until the body is closed
+	_, _, err = client.Do(ctx, goHttp.MethodGet, server.URL, nil)
+	_, limited := errors.Has(err, pixieErrors.RateLimitExceededErrorCode)
+	assert.True(t, limited)
+
+	require.NoError(t, body.Close())
+	require.NoError(t, body.Close())
+	assert.NoError(t, client.DoJSON(ctx, goHttp.MethodGet, server.URL, nil, nil))
+
+	host, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	members, err := mr.ZMembers("rest:" + host.Host)
+	if err == nil {
+		assert.Empty(t, members)
+	}
+}
+
+func TestClient_SemaphoreKeepsLeaseWhileBodyOpen(t *testing.T) {
+	server := httptest.NewServer(goHttp.HandlerFunc(func(w goHttp.ResponseWriter, _ *goHttp.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	redisCache, err := cache.NewRedisCache(context.Background(), cache.RedisCacheConfiguration{Address: mr.Addr()})
+	require.NoError(t, err)
+	semaphore, err := rate_limiter.NewSemaphore(context.Background(), rate_limiter.SemaphoreConfiguration{
+		Limit:    1,
+		LeaseTTL: coretime.Duration(60 * time.Millisecond),
+		MaxWait:  coretime.Duration(20 * time.Millisecond),
+	}, redisCache)
+	require.NoError(t, err)
+
+	client := NewClient(context.Background(), &ClientConfiguration{Timeout: 1000, Semaphore: semaphore})
+	ctx := context.Background()
+
+	body, _, err := client.Do(ctx, goHttp.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	// the lease outlives its ttl while the body is open
+	time.Sleep(200 * time.Millisecond)
+	_, _, err = client.Do(ctx, goHttp.MethodGet, server.URL, nil)
+	_, limited := errors.Has(err, pixieErrors.RateLimitExceededErrorCode)
+	assert.True(t, limited)
+
+	require.NoError(t, body.Close())
+	assert.NoError(t, client.DoJSON(ctx, goHttp.MethodGet, server.URL, nil, nil))
+}
@@ -1,6 +1,10 @@
 package rest
 
-import goHttp "net/http"
+import (
+	goHttp "net/http"
+
+	"github.com/pixie-sh/core-go/infra/rate_limiter"
+)
 
 // ClientConfiguration rest client config
 type ClientConfiguration struct {
@@ -8,4 +12,8 @@ type ClientConfiguration struct {
 	APIKeys      map[string]string `json:"api_keys"`
 	Timeout      int               `json:"timeout"`
 	GoClient     *goHttp.Client    `json:"-"`
+
+	// Semaphore caps the in-flight requests per host across replicas, key rest:<host>; the lease is
+	// refreshed until the response body is closed, the request is canceled if it is lost
+	Semaphore rate_limiter.ISemaphore `json:"-"`
 }
@@ -0,0 +1,22 @@
+package context
+
+import (
+	"context"
+)
+
+const LocalsActorID = "actor_id"
+
+// SetCtxActorID sets the ID of the user or system acting on behalf of the request
+func SetCtxActorID(ctx context.Context, actorID string) context.Context {
+	return context.WithValue(ctx, LocalsActorID, actorID)
+}
+
+// GetCtxActorID returns the actor ID or empty if not set
+func GetCtxActorID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	actorID, _ := ctx.Value(LocalsActorID).(string)
+	return actorID
+}
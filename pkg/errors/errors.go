@@ -19,4 +19,10 @@ var (
 	TagsInvalidFormatErrorCode                   = errors.NewErrorCode("TagsInvalidFormatErrorCode", BaseErrorCodeValue+errors.HTTPInvalidData)
 	TagsInvalidScopeErrorCode                    = errors.NewErrorCode("TagsInvalidScopeErrorCode", BaseErrorCodeValue+errors.HTTPInvalidData)
 	TagsDependencyErrorCode                      = errors.NewErrorCode("TagsDependencyErrorCode", BaseErrorCodeValue+errors.HTTPServerError)
+	PubSubInvalidTopicPatternErrorCode           = errors.NewErrorCode("PubSubInvalidTopicPatternErrorCode", BaseErrorCodeValue+errors.HTTPInvalidData)
+	StateMachineActionFailedErrorCode            = errors.NewErrorCode("StateMachineActionFailedErrorCode", BaseErrorCodeValue+errors.HTTPServerError)
+	StateMachineInvalidDefinitionErrorCode       = errors.NewErrorCode("StateMachineInvalidDefinitionErrorCode", BaseErrorCodeValue+errors.HTTPInvalidData)
+	StateMachineVersionConflictErrorCode         = errors.NewErrorCode("StateMachineVersionConflictErrorCode", BaseErrorCodeValue+errors.HTTPConflict)
+	RateLimitExceededErrorCode                   = errors.NewErrorCode("RateLimitExceededErrorCode", BaseErrorCodeValue+errors.HTTPThrottling)
+	LockLostErrorCode                            = errors.NewErrorCode("LockLostErrorCode", BaseErrorCodeValue+errors.HTTPConflict)
 )
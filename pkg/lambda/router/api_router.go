@@ -104,6 +104,7 @@ func (r *APIRouter) Handle(ctx context.Context, method http.Method, path string,
 	apiCtx.SetUserContext(ctx)
 	apiCtx.SetLocals(map[string]any{})
 
+	headers := map[string]string{}
 	for _, g := range r.gates {
 		resp, err := g(apiCtx)
 		if err != nil {
@@ -113,6 +114,7 @@ func (r *APIRouter) Handle(ctx context.Context, method http.Method, path string,
 		if resp.StatusCode >= 400 {
 			return resp, nil
 		}
+		mergeHeaders(headers, resp.Headers)
 	}
 
 	key := APIRouteKey{Method: method, Path: path}
@@ -122,6 +124,9 @@ func (r *APIRouter) Handle(ctx context.Context, method http.Method, path string,
 
 			switch i {
 			case len(handler) - 1: //last iteration return either result
+				if err == nil {
+					resp.Headers = mergeHeaders(resp.Headers, headers)
+				}
 				return resp, err
 			default:
 				if err != nil {
@@ -131,6 +136,7 @@ func (r *APIRouter) Handle(ctx context.Context, method http.Method, path string,
 				if resp.StatusCode >= 400 {
 					return resp, nil
 				}
+				mergeHeaders(headers, resp.Headers)
 			}
 		}
 	}
@@ -143,6 +149,7 @@ func (r *APIRouter) HandleV2(ctx context.Context, method string, path string, re
 	apiCtx.SetUserContext(ctx)
 	apiCtx.SetLocals(map[string]any{})
 
+	headers := map[string]string{}
 	for _, g := range r.gates {
 		resp, err := g(apiCtx)
 		if err != nil {
@@ -152,6 +159,7 @@ func (r *APIRouter) HandleV2(ctx context.Context, method string, path string, re
 		if resp.StatusCode >= 400 {
 			return resp, nil
 		}
+		mergeHeaders(headers, resp.Headers)
 	}
 
 	key := APIRouteKey{Method: method, Path: path}
@@ -161,6 +169,9 @@ func (r *APIRouter) HandleV2(ctx context.Context, method string, path string, re
 
 			switch i {
 			case len(handler) - 1: //last iteration return either result
+				if err == nil {
+					resp.Headers = mergeHeaders(resp.Headers, headers)
+				}
 				return resp, err
 			default:
 				if err != nil {
@@ -170,9 +181,27 @@ func (r *APIRouter) HandleV2(ctx context.Context, method string, path string, re
 				if resp.StatusCode >= 400 {
 					return resp, nil
 				}
+				mergeHeaders(headers, resp.Headers)
 			}
 		}
 	}
 
 	return lambda_api.APIErrorResponse(errors.New("route not found %s %s", method, path).WithErrorCode(errors.NotFoundErrorCode))
 }
+
+// mergeHeaders adds to headers the ones it doesn't have, e.g. the headers of passing gates to the handler response
+func mergeHeaders(headers map[string]string, from map[string]string) map[string]string {
+	if len(from) == 0 {
+		return headers
+	}
+	if headers == nil {
+		headers = make(map[string]string, len(from))
+	}
+
+	for header, value := range from {
+		if _, ok := headers[header]; !ok {
+			headers[header] = value
+		}
+	}
+	return headers
+}
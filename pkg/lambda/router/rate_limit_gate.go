@@ -0,0 +1,103 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/pixie-sh/errors-go"
+
+	"github.com/pixie-sh/core-go/infra/rate_limiter"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+	"github.com/pixie-sh/core-go/pkg/lambda/lambda_api"
+)
+
+const rateLimitKeyPrefix = "rate_limit"
+
+// APIRateLimitKeyFunc key the request is limited by; requests with an empty key are not limited
+type APIRateLimitKeyFunc func(ctx *pixiecontext.LambdaAPIContext) string
+
+// APIRateLimitByIP limits by the source IP
+func APIRateLimitByIP() APIRateLimitKeyFunc {
+	return func(ctx *pixiecontext.LambdaAPIContext) string {
+		if ctx.RequestV2 != nil {
+			return ctx.RequestV2.RequestContext.HTTP.SourceIP
+		}
+		if ctx.Request != nil {
+			return ctx.Request.RequestContext.Identity.SourceIP
+		}
+		return ""
+	}
+}
+
+// APIRateLimitByHeader limits by the value of header, e.g. an API key
+func APIRateLimitByHeader(header string) APIRateLimitKeyFunc {
+	return func(ctx *pixiecontext.LambdaAPIContext) string {
+		var headers map[string]string
+		if ctx.RequestV2 != nil {
+			headers = ctx.RequestV2.Headers
+		} else if ctx.Request != nil {
+			headers = ctx.Request.Headers
+		}
+
+		value, ok := headers[header]
+		if !ok {
+			value = headers[strings.ToLower(header)]
+		}
+		return value
+	}
+}
+
+// APIRateLimitByLocals limits by the value in Locals under key, e.g. the authenticated user set by an auth gate
+func APIRateLimitByLocals(key string) APIRateLimitKeyFunc {
+	return func(ctx *pixiecontext.LambdaAPIContext) string {
+		value := ctx.GetLocal(key)
+		if value == nil {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+}
+
+// RateLimitGate limits the requests of the routes it gates, per route and key; see http_middlewares.RateLimit.
+// Denied requests get a 429 with the RateLimit and Retry-After headers; the RateLimit headers of allowed
+// requests are merged by the router into the route handler response
+func RateLimitGate(limiter rate_limiter.ILimiter, keyFunc APIRateLimitKeyFunc, withLimit ...rate_limiter.Limit) APIHandler {
+	return func(ctx *pixiecontext.LambdaAPIContext) (events.APIGatewayProxyResponse, error) {
+		key := keyFunc(ctx)
+		if len(key) == 0 {
+			return events.APIGatewayProxyResponse{}, nil
+		}
+
+		result, err := limiter.Allow(ctx, fmt.Sprintf("%s:%s:%s", rateLimitKeyPrefix, apiRoute(ctx), key), withLimit...)
+		if err != nil {
+			pixiecontext.GetCtxLogger(ctx).With("error", err).Warn("error rate limiting request")
+			return events.APIGatewayProxyResponse{}, nil
+		}
+
+		if result.Allowed {
+			return events.APIGatewayProxyResponse{Headers: result.Headers()}, nil
+		}
+
+		resp, err := lambda_api.APIErrorResponse(errors.New("rate limit exceeded, retry in %s", result.RetryAfter).
+			WithErrorCode(pixieErrors.RateLimitExceededErrorCode))
+		for header, value := range result.Headers() {
+			resp.Headers[header] = value
+		}
+		return resp, err
+	}
+}
+
+func apiRoute(ctx *pixiecontext.LambdaAPIContext) string {
+	if ctx.RequestV2 != nil {
+		if len(ctx.RequestV2.RouteKey) > 0 {
+			return ctx.RequestV2.RouteKey
+		}
+		return ctx.RequestV2.RequestContext.HTTP.Method + " " + ctx.RequestV2.RawPath
+	}
+	if ctx.Request != nil {
+		return ctx.Request.HTTPMethod + " " + ctx.Request.Resource
+	}
+	return ""
+}
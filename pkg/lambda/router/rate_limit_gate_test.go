@@ -0,0 +1,58 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pixie-sh/core-go/infra/rate_limiter"
+	pixiecontext "github.com/pixie-sh/core-go/pkg/context"
+	"github.com/pixie-sh/core-go/pkg/lambda/lambda_api"
+)
+
+type allowOnceLimiter struct {
+	keys map[string]struct{}
+}
+
+func (l *allowOnceLimiter) Allow(_ context.Context, key string, _ ...rate_limiter.Limit) (rate_limiter.Result, error) {
+	_, seen := l.keys[key]
+	l.keys[key] = struct{}{}
+	return rate_limiter.Result{Allowed: !seen, Limit: 1, ResetAfter: time.Minute, RetryAfter: time.Minute}, nil
+}
+
+func TestRateLimitGate(t *testing.T) {
+	ctx := context.Background()
+	router := NewAPIRouter(ctx, "")
+	router.Group("/items", RateLimitGate(&allowOnceLimiter{keys: make(map[string]struct{})}, APIRateLimitByIP())).
+		RegisterHandler(ctx, "GET", "", func(*pixiecontext.LambdaAPIContext) (events.APIGatewayProxyResponse, error) {
+			return lambda_api.APIResponse("ok")
+		})
+
+	request := func(ip string) events.APIGatewayV2HTTPRequest {
+		request := events.APIGatewayV2HTTPRequest{RouteKey: "GET /items"}
+		request.RequestContext.HTTP.SourceIP = ip
+		return request
+	}
+
+	resp, err := router.HandleV2(ctx, "GET", "/items", request("10.0.0.1"))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "1", resp.Headers[rate_limiter.HeaderRateLimitLimit])
+	assert.Equal(t, "60", resp.Headers[rate_limiter.HeaderRateLimitReset])
+	assert.NotContains(t, resp.Headers, rate_limiter.HeaderRetryAfter)
+	assert.Equal(t, "Application/json", resp.Headers["Content-PayloadType"])
+
+	resp, err = router.HandleV2(ctx, "GET", "/items", request("10.0.0.1"))
+	require.NoError(t, err)
+	assert.Equal(t, 429, resp.StatusCode)
+	assert.Equal(t, "60", resp.Headers[rate_limiter.HeaderRetryAfter])
+	assert.Equal(t, "1", resp.Headers[rate_limiter.HeaderRateLimitLimit])
+
+	resp, err = router.HandleV2(ctx, "GET", "/items", request("10.0.0.2"))
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+}
@@ -3,3 +3,4 @@ package models
 const HeaderConnectionID = "connection_id"
 const HeaderPublisherID = "publisher_id"
 const LocalsRequestLogger = "request_logger"
+const HeaderHistoryCursor = "history_cursor"
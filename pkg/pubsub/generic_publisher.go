@@ -31,28 +31,37 @@ func (s *GenericPublisher[T]) CountSubscriptions() int {
 	return len(s.subscriptions)
 }
 
+// NotifySubscriptions publishes msg to all subscribers sequentially;
+// a full subscriber queue is handled by the subscriber policy (see SubscriberPolicy),
+// subscribers evicted meanwhile are unsubscribed
 func (s *GenericPublisher[T]) NotifySubscriptions(msg T) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for _, sub := range s.subscriptions {
-		if !utils.Nil(sub) {
-			sub.Publish(msg)
-		}
-	}
+	s.NotifySubscriptionsWithFrom("", msg)
 }
 
+// NotifySubscriptionsWithFrom same as NotifySubscriptions, skipping fromID subscriber
 func (s *GenericPublisher[T]) NotifySubscriptionsWithFrom(fromID string, msg T) {
+	var evicted []string
+
 	s.mu.RLock()
-	defer s.mu.RUnlock()
 	for id, sub := range s.subscriptions {
-		if id == fromID {
+		if len(fromID) > 0 && id == fromID {
 			continue
 		}
 
-		if !utils.Nil(sub) {
-			sub.Publish(msg)
+		if utils.Nil(sub) {
+			continue
+		}
+
+		sub.Publish(msg)
+		if evictable, ok := sub.(EvictableSubscriber); ok && evictable.Evicted() {
+			evicted = append(evicted, id)
 		}
 	}
+	s.mu.RUnlock()
+
+	for _, id := range evicted {
+		s.Unsubscribe(id)
+	}
 }
 
 func (s *GenericPublisher[T]) Unsubscribe(id string) {
@@ -2,6 +2,8 @@ package pubsub
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pixie-sh/core-go/pkg/types/channels"
@@ -15,10 +17,18 @@ type OnProcessSubscriber[T any] struct {
 	id          string
 	queue       chan T
 	processFunc func(T)
+	policy      SubscriberPolicy
+	metrics     subscriberMetrics
+	cancel      context.CancelFunc
+
+	mu      sync.Mutex
+	dropped atomic.Uint64
+	evicted atomic.Bool
 }
 
 // NewOnProcessSubscriber creates a new OnProcessSubscriber
-func NewOnProcessSubscriber[T any](ctx context.Context, id string, processFunc func(T), queueSize int) *OnProcessSubscriber[T] {
+// without policy, DefaultSubscriberPolicy is used
+func NewOnProcessSubscriber[T any](ctx context.Context, id string, processFunc func(T), queueSize int, withPolicy ...SubscriberPolicy) *OnProcessSubscriber[T] {
 	var f = processFunc
 	if types.Nil(processFunc) {
 		f = func(t T) {
@@ -26,8 +36,31 @@ func NewOnProcessSubscriber[T any](ctx context.Context, id string, processFunc f
 		}
 	}
 
-	onProcessSubscriber := &OnProcessSubscriber[T]{id: id, queue: make(chan T, queueSize), processFunc: f}
-	go channels.ConsumeChannel[T](ctx, onProcessSubscriber.queue, onProcessSubscriber.processFunc, true)
+	policy := DefaultSubscriberPolicy()
+	if len(withPolicy) > 0 {
+		policy = withPolicy[0].withDefaults()
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	onProcessSubscriber := &OnProcessSubscriber[T]{
+		id:      id,
+		queue:   make(chan T, queueSize),
+		policy:  policy,
+		metrics: getSubscriberMetrics(),
+		cancel:  cancel,
+	}
+
+	lag := onProcessSubscriber.metrics.lag.WithLabelValues(policy.MetricsName)
+	onProcessSubscriber.processFunc = func(t T) {
+		if onProcessSubscriber.evicted.Load() {
+			return
+		}
+
+		lag.Dec()
+		f(t)
+	}
+
+	go channels.ConsumeChannel[T](subCtx, onProcessSubscriber.queue, onProcessSubscriber.processFunc, false)
 	return onProcessSubscriber
 }
 
@@ -36,7 +69,93 @@ func (s *OnProcessSubscriber[T]) ID() string {
 	return s.id
 }
 
-// Publish method implementation, not blocking
+// Publish method implementation; when the queue is full the SubscriberPolicy is applied
+// OverflowBlock is the only policy that may block the caller, up to BlockTimeout
 func (s *OnProcessSubscriber[T]) Publish(msg T) {
-	channels.PublishToChannelWithTimeout[T](s.queue, msg, 5*time.Second)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.evicted.Load() {
+		s.drop()
+		return
+	}
+
+	select {
+	case s.queue <- msg:
+		s.queued()
+		return
+	default:
+	}
+
+	switch s.policy.Overflow {
+	case OverflowDropNewest:
+		s.drop()
+	case OverflowDropOldest:
+		select {
+		case <-s.queue:
+			s.metrics.lag.WithLabelValues(s.policy.MetricsName).Dec()
+			s.drop()
+		default:
+		}
+
+		select {
+		case s.queue <- msg:
+			s.queued()
+		default:
+			s.drop()
+		}
+	case OverflowDisconnect:
+		s.drop()
+		s.evict()
+	default:
+		timer := time.NewTimer(s.policy.BlockTimeout.Duration())
+		defer timer.Stop()
+
+		select {
+		case s.queue <- msg:
+			s.queued()
+		case <-timer.C:
+			logger.Logger.Error("timeout reach publishing to subscriber %s", s.id)
+			s.drop()
+		}
+	}
+}
+
+// Evicted implements EvictableSubscriber
+func (s *OnProcessSubscriber[T]) Evicted() bool {
+	return s.evicted.Load()
+}
+
+// Stats returns the current queue lag and drop counts
+func (s *OnProcessSubscriber[T]) Stats() SubscriberStats {
+	return SubscriberStats{
+		Lag:     len(s.queue),
+		Dropped: s.dropped.Load(),
+		Evicted: s.evicted.Load(),
+	}
+}
+
+func (s *OnProcessSubscriber[T]) queued() {
+	s.metrics.lag.WithLabelValues(s.policy.MetricsName).Inc()
+}
+
+func (s *OnProcessSubscriber[T]) drop() {
+	s.dropped.Add(1)
+	s.metrics.dropped.WithLabelValues(s.policy.MetricsName, string(s.policy.Overflow)).Inc()
+}
+
+// evict stops processing and notifies the OnEvicted callback; caller must hold s.mu
+func (s *OnProcessSubscriber[T]) evict() {
+	if !s.evicted.CompareAndSwap(false, true) {
+		return
+	}
+
+	s.cancel()
+	s.metrics.lag.WithLabelValues(s.policy.MetricsName).Sub(float64(len(s.queue)))
+	s.metrics.evicted.WithLabelValues(s.policy.MetricsName).Inc()
+	logger.Logger.With("subscriber_id", s.id).Warn("subscriber %s evicted, queue full", s.id)
+
+	if s.policy.OnEvicted != nil {
+		go s.policy.OnEvicted(s.id, s.Stats())
+	}
 }
@@ -0,0 +1,122 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+// blockedSubscriber returns a subscriber whose first processed message blocks until release is closed
+func blockedSubscriber(t *testing.T, policy SubscriberPolicy) (*OnProcessSubscriber[int], chan struct{}, func() []int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var (
+		mu        sync.Mutex
+		processed []int
+	)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	first := true
+
+	sub := NewOnProcessSubscriber[int](ctx, "sub", func(i int) {
+		if first {
+			first = false
+			close(started)
+			<-release
+		}
+
+		mu.Lock()
+		processed = append(processed, i)
+		mu.Unlock()
+	}, 2, policy)
+
+	sub.Publish(0)
+	<-started
+	return sub, release, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int{}, processed...)
+	}
+}
+
+func TestOnProcessSubscriber_DropNewest(t *testing.T) {
+	sub, release, processed := blockedSubscriber(t, SubscriberPolicy{Overflow: OverflowDropNewest})
+
+	for i := 1; i <= 4; i++ {
+		sub.Publish(i)
+	}
+
+	stats := sub.Stats()
+	assert.Equal(t, 2, stats.Lag)
+	assert.Equal(t, uint64(2), stats.Dropped)
+
+	close(release)
+	assert.Eventually(t, func() bool { return len(processed()) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{0, 1, 2}, processed())
+}
+
+func TestOnProcessSubscriber_DropOldest(t *testing.T) {
+	sub, release, processed := blockedSubscriber(t, SubscriberPolicy{Overflow: OverflowDropOldest})
+
+	for i := 1; i <= 4; i++ {
+		sub.Publish(i)
+	}
+
+	assert.Equal(t, uint64(2), sub.Stats().Dropped)
+
+	close(release)
+	assert.Eventually(t, func() bool { return len(processed()) == 3 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []int{0, 3, 4}, processed())
+}
+
+func TestOnProcessSubscriber_BlockTimeout(t *testing.T) {
+	policy := SubscriberPolicy{Overflow: OverflowBlock, BlockTimeout: coretime.Duration(20 * time.Millisecond)}
+	sub, release, _ := blockedSubscriber(t, policy)
+
+	sub.Publish(1)
+	sub.Publish(2)
+
+	start := time.Now()
+	sub.Publish(3)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	assert.Equal(t, uint64(1), sub.Stats().Dropped)
+	close(release)
+}
+
+func TestOnProcessSubscriber_DisconnectEvictsFromPublisher(t *testing.T) {
+	evicted := make(chan SubscriberStats, 1)
+	policy := SubscriberPolicy{
+		Overflow: OverflowDisconnect,
+		OnEvicted: func(subscriberID string, stats SubscriberStats) {
+			evicted <- stats
+		},
+	}
+
+	sub, release, _ := blockedSubscriber(t, policy)
+	defer close(release)
+
+	publisher := NewGenericPublisher[int](context.Background())
+	publisher.Subscribe(sub)
+
+	publisher.NotifySubscriptions(1)
+	publisher.NotifySubscriptions(2)
+	assert.Equal(t, 1, publisher.CountSubscriptions())
+
+	publisher.NotifySubscriptions(3)
+	assert.True(t, sub.Evicted())
+	assert.Equal(t, 0, publisher.CountSubscriptions())
+
+	select {
+	case stats := <-evicted:
+		assert.True(t, stats.Evicted)
+		assert.Equal(t, uint64(1), stats.Dropped)
+	case <-time.After(time.Second):
+		t.Fatal("OnEvicted not called")
+	}
+}
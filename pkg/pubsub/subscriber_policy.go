@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pixie-sh/core-go/pkg/metrics"
+	coretime "github.com/pixie-sh/core-go/pkg/time"
+)
+
+// OverflowPolicy what a subscriber does when its queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock waits up to BlockTimeout for queue space, the message is dropped after it
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowDropNewest drops the message being published
+	OverflowDropNewest OverflowPolicy = "drop_newest"
+	// OverflowDropOldest drops the oldest queued message to make room for the new one
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDisconnect evicts the subscriber; publishers unsubscribe it on the next notification
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+const defaultBlockTimeout = 5 * time.Second
+
+// SubscriberPolicy slow consumer policy of OnProcessSubscriber
+type SubscriberPolicy struct {
+	Overflow     OverflowPolicy    `json:"overflow"`
+	BlockTimeout coretime.Duration `json:"block_timeout"` // OverflowBlock only; defaults to 5s
+
+	// MetricsName groups subscribers on the exposed metrics, avoid per connection names; defaults to "default"
+	MetricsName string `json:"metrics_name"`
+
+	// OnEvicted called once when the subscriber is evicted by OverflowDisconnect
+	OnEvicted func(subscriberID string, stats SubscriberStats) `json:"-"`
+}
+
+// DefaultSubscriberPolicy blocks up to 5 seconds before dropping the message
+func DefaultSubscriberPolicy() SubscriberPolicy {
+	return SubscriberPolicy{
+		Overflow:     OverflowBlock,
+		BlockTimeout: coretime.Duration(defaultBlockTimeout),
+		MetricsName:  "default",
+	}
+}
+
+func (p SubscriberPolicy) withDefaults() SubscriberPolicy {
+	if len(p.Overflow) == 0 {
+		p.Overflow = OverflowBlock
+	}
+
+	if p.BlockTimeout <= 0 {
+		p.BlockTimeout = coretime.Duration(defaultBlockTimeout)
+	}
+
+	if len(p.MetricsName) == 0 {
+		p.MetricsName = "default"
+	}
+
+	return p
+}
+
+// SubscriberStats snapshot of a subscriber queue
+type SubscriberStats struct {
+	Lag     int    `json:"lag"`     // messages queued and not yet processed
+	Dropped uint64 `json:"dropped"` // messages dropped by the overflow policy
+	Evicted bool   `json:"evicted"`
+}
+
+// EvictableSubscriber implemented by subscribers that can be evicted;
+// GenericPublisher unsubscribes evicted subscribers
+type EvictableSubscriber interface {
+	Evicted() bool
+}
+
+type subscriberMetrics struct {
+	lag     *prometheus.GaugeVec
+	dropped *prometheus.CounterVec
+	evicted *prometheus.CounterVec
+}
+
+var (
+	subscriberMetricsOnce     sync.Once
+	subscriberMetricsInstance subscriberMetrics
+)
+
+// getSubscriberMetrics registers the subscriber metrics on metrics.GlobalRegistry once
+func getSubscriberMetrics() subscriberMetrics {
+	subscriberMetricsOnce.Do(func() {
+		subscriberMetricsInstance = subscriberMetrics{
+			lag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Namespace: "pubsub",
+				Name:      "subscriber_lag",
+				Help:      "messages queued on subscribers and not yet processed",
+			}, []string{"subscriber"}),
+			dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: "pubsub",
+				Name:      "subscriber_dropped_total",
+				Help:      "messages dropped by subscribers overflow policy",
+			}, []string{"subscriber", "policy"}),
+			evicted: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: "pubsub",
+				Name:      "subscriber_evicted_total",
+				Help:      "subscribers evicted by the disconnect overflow policy",
+			}, []string{"subscriber"}),
+		}
+
+		metrics.GlobalRegistry.MustRegister(
+			subscriberMetricsInstance.lag,
+			subscriberMetricsInstance.dropped,
+			subscriberMetricsInstance.evicted,
+		)
+	})
+
+	return subscriberMetricsInstance
+}
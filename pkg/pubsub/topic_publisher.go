@@ -0,0 +1,264 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/pixie-sh/errors-go"
+	"github.com/pixie-sh/errors-go/utils"
+
+	pixieErrors "github.com/pixie-sh/core-go/pkg/errors"
+)
+
+const (
+	// TopicSeparator splits topics and patterns in levels
+	TopicSeparator = "/"
+	// TopicSingleLevelWildcard matches exactly one level; eg: rooms/*/messages
+	TopicSingleLevelWildcard = "*"
+	// TopicMultiLevelWildcard matches the parent level and everything below it, must be the last level;
+	// prefix subscriptions are expressed with it; eg: rooms/#
+	TopicMultiLevelWildcard = "#"
+)
+
+// Filter predicate evaluated before publishing to a topic subscriber
+type Filter[T any] func(msg T) bool
+
+// OfType filter that only accepts messages whose dynamic type is P
+func OfType[T any, P any]() Filter[T] {
+	return func(msg T) bool {
+		_, ok := any(msg).(P)
+		return ok
+	}
+}
+
+type topicSubscription[T any] struct {
+	subscriber Subscriber[T]
+	filters    []Filter[T]
+}
+
+func (s topicSubscription[T]) accepts(msg T) bool {
+	for _, filter := range s.filters {
+		if !filter(msg) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type topicNode[T any] struct {
+	children map[string]*topicNode[T]
+	exact    map[string]topicSubscription[T] // subscriptions ending on this level
+	multi    map[string]topicSubscription[T] // subscriptions with TopicMultiLevelWildcard after this level
+}
+
+func newTopicNode[T any]() *topicNode[T] {
+	return &topicNode[T]{
+		children: make(map[string]*topicNode[T]),
+		exact:    make(map[string]topicSubscription[T]),
+		multi:    make(map[string]topicSubscription[T]),
+	}
+}
+
+func (n *topicNode[T]) empty() bool {
+	return len(n.children) == 0 && len(n.exact) == 0 && len(n.multi) == 0
+}
+
+// TopicPublisher delivers messages to subscribers whose patterns match the published topic
+// patterns are kept on a trie by level, so publishing cost depends on the topic depth
+// and not on the number of subscriptions
+type TopicPublisher[T any] struct {
+	mu       sync.RWMutex
+	root     *topicNode[T]
+	patterns map[string]map[string]struct{} // subscriber ID to its patterns
+}
+
+// NewTopicPublisher creates a new TopicPublisher
+func NewTopicPublisher[T any](_ context.Context) *TopicPublisher[T] {
+	return &TopicPublisher[T]{
+		root:     newTopicNode[T](),
+		patterns: make(map[string]map[string]struct{}),
+	}
+}
+
+// Subscribe registers the subscriber on the pattern; subscribing again the same pattern replaces the filters
+// messages are only delivered when all filters accept them
+func (p *TopicPublisher[T]) Subscribe(pattern string, subscriber Subscriber[T], filters ...Filter[T]) error {
+	if utils.Nil(subscriber) {
+		return errors.New("nil subscriber for pattern '%s'", pattern).WithErrorCode(pixieErrors.PubSubInvalidTopicPatternErrorCode)
+	}
+
+	levels, err := parseTopicPattern(pattern)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	subscription := topicSubscription[T]{subscriber: subscriber, filters: filters}
+	node := p.root
+	for i, level := range levels {
+		if level == TopicMultiLevelWildcard && i == len(levels)-1 {
+			node.multi[subscriber.ID()] = subscription
+			p.track(subscriber.ID(), pattern)
+			return nil
+		}
+
+		child, ok := node.children[level]
+		if !ok {
+			child = newTopicNode[T]()
+			node.children[level] = child
+		}
+		node = child
+	}
+
+	node.exact[subscriber.ID()] = subscription
+	p.track(subscriber.ID(), pattern)
+	return nil
+}
+
+// Unsubscribe removes all subscriptions of the subscriber ID
+func (p *TopicPublisher[T]) Unsubscribe(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for pattern := range p.patterns[id] {
+		p.remove(pattern, id)
+	}
+	delete(p.patterns, id)
+}
+
+// UnsubscribePattern removes the subscriber ID from a single pattern
+func (p *TopicPublisher[T]) UnsubscribePattern(pattern string, id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.remove(pattern, id)
+	delete(p.patterns[id], pattern)
+	if len(p.patterns[id]) == 0 {
+		delete(p.patterns, id)
+	}
+}
+
+// CountSubscriptions returns the number of distinct subscribers
+func (p *TopicPublisher[T]) CountSubscriptions() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.patterns)
+}
+
+// Publish delivers msg to each matching subscriber once, even if several of its patterns match;
+// returns the number of subscribers the message was delivered to
+// evicted subscribers (see EvictableSubscriber) are unsubscribed
+func (p *TopicPublisher[T]) Publish(topic string, msg T) int {
+	p.mu.RLock()
+	matched := make(map[string]topicSubscription[T])
+	p.match(p.root, strings.Split(topic, TopicSeparator), msg, matched)
+	p.mu.RUnlock()
+
+	var evicted []string
+	for id, subscription := range matched {
+		subscription.subscriber.Publish(msg)
+		if evictable, ok := subscription.subscriber.(EvictableSubscriber); ok && evictable.Evicted() {
+			evicted = append(evicted, id)
+		}
+	}
+
+	for _, id := range evicted {
+		p.Unsubscribe(id)
+	}
+
+	return len(matched)
+}
+
+func (p *TopicPublisher[T]) match(node *topicNode[T], levels []string, msg T, matched map[string]topicSubscription[T]) {
+	collect := func(subscriptions map[string]topicSubscription[T]) {
+		for id, subscription := range subscriptions {
+			if _, ok := matched[id]; ok {
+				continue
+			}
+
+			if subscription.accepts(msg) {
+				matched[id] = subscription
+			}
+		}
+	}
+
+	collect(node.multi)
+	if len(levels) == 0 {
+		collect(node.exact)
+		return
+	}
+
+	if child, ok := node.children[levels[0]]; ok {
+		p.match(child, levels[1:], msg, matched)
+	}
+
+	if child, ok := node.children[TopicSingleLevelWildcard]; ok {
+		p.match(child, levels[1:], msg, matched)
+	}
+}
+
+// track caller must hold the lock
+func (p *TopicPublisher[T]) track(id string, pattern string) {
+	if _, ok := p.patterns[id]; !ok {
+		p.patterns[id] = make(map[string]struct{})
+	}
+	p.patterns[id][pattern] = struct{}{}
+}
+
+// remove deletes the subscription and prunes empty nodes; caller must hold the lock
+func (p *TopicPublisher[T]) remove(pattern string, id string) {
+	levels := strings.Split(pattern, TopicSeparator)
+	path := []*topicNode[T]{p.root}
+
+	node := p.root
+	for i, level := range levels {
+		if level == TopicMultiLevelWildcard && i == len(levels)-1 {
+			delete(node.multi, id)
+			break
+		}
+
+		child, ok := node.children[level]
+		if !ok {
+			return
+		}
+
+		node = child
+		path = append(path, node)
+		if i == len(levels)-1 {
+			delete(node.exact, id)
+		}
+	}
+
+	for i := len(path) - 1; i > 0; i-- {
+		if !path[i].empty() {
+			break
+		}
+		delete(path[i-1].children, levels[i-1])
+	}
+}
+
+func parseTopicPattern(pattern string) ([]string, error) {
+	if len(pattern) == 0 {
+		return nil, errors.New("empty topic pattern").WithErrorCode(pixieErrors.PubSubInvalidTopicPatternErrorCode)
+	}
+
+	levels := strings.Split(pattern, TopicSeparator)
+	for i, level := range levels {
+		if level == TopicMultiLevelWildcard && i != len(levels)-1 {
+			return nil, errors.New("'%s' must be the last level of pattern '%s'", TopicMultiLevelWildcard, pattern).
+				WithErrorCode(pixieErrors.PubSubInvalidTopicPatternErrorCode)
+		}
+
+		if level != TopicMultiLevelWildcard && level != TopicSingleLevelWildcard &&
+			(strings.Contains(level, TopicMultiLevelWildcard) || strings.Contains(level, TopicSingleLevelWildcard)) {
+			return nil, errors.New("wildcards must take a whole level of pattern '%s'", pattern).
+				WithErrorCode(pixieErrors.PubSubInvalidTopicPatternErrorCode)
+		}
+	}
+
+	return levels, nil
+}
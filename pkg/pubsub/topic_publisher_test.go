@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSubscriber[T any] struct {
+	id string
+
+	mu       sync.Mutex
+	received []T
+}
+
+func (s *recordingSubscriber[T]) ID() string {
+	return s.id
+}
+
+func (s *recordingSubscriber[T]) Publish(msg T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.received = append(s.received, msg)
+}
+
+func (s *recordingSubscriber[T]) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.received)
+}
+
+func TestTopicPublisher_Patterns(t *testing.T) {
+	publisher := NewTopicPublisher[string](context.Background())
+
+	exact := &recordingSubscriber[string]{id: "exact"}
+	single := &recordingSubscriber[string]{id: "single"}
+	multi := &recordingSubscriber[string]{id: "multi"}
+	all := &recordingSubscriber[string]{id: "all"}
+
+	require.NoError(t, publisher.Subscribe("rooms/1/messages", exact))
+	require.NoError(t, publisher.Subscribe("rooms/*/messages", single))
+	require.NoError(t, publisher.Subscribe("rooms/#", multi))
+	require.NoError(t, publisher.Subscribe("#", all))
+
+	assert.Equal(t, 4, publisher.Publish("rooms/1/messages", "a"))
+	assert.Equal(t, 3, publisher.Publish("rooms/2/messages", "b"))
+	assert.Equal(t, 2, publisher.Publish("rooms", "c"))
+	assert.Equal(t, 2, publisher.Publish("rooms/2/members/3", "d"))
+	assert.Equal(t, 1, publisher.Publish("parties/1", "e"))
+
+	assert.Equal(t, 1, exact.count())
+	assert.Equal(t, 2, single.count())
+	assert.Equal(t, 4, multi.count())
+	assert.Equal(t, 5, all.count())
+}
+
+func TestTopicPublisher_DeliversOncePerSubscriber(t *testing.T) {
+	publisher := NewTopicPublisher[string](context.Background())
+	sub := &recordingSubscriber[string]{id: "sub"}
+
+	require.NoError(t, publisher.Subscribe("rooms/1", sub))
+	require.NoError(t, publisher.Subscribe("rooms/*", sub))
+	require.NoError(t, publisher.Subscribe("rooms/#", sub))
+
+	assert.Equal(t, 1, publisher.Publish("rooms/1", "a"))
+	assert.Equal(t, 1, sub.count())
+	assert.Equal(t, 1, publisher.CountSubscriptions())
+}
+
+func TestTopicPublisher_Filters(t *testing.T) {
+	type created struct{}
+	type deleted struct{}
+
+	publisher := NewTopicPublisher[any](context.Background())
+	sub := &recordingSubscriber[any]{id: "sub"}
+
+	require.NoError(t, publisher.Subscribe("entities/#", sub, OfType[any, created]()))
+
+	assert.Equal(t, 1, publisher.Publish("entities/1", created{}))
+	assert.Equal(t, 0, publisher.Publish("entities/1", deleted{}))
+	assert.Equal(t, 1, sub.count())
+}
+
+func TestTopicPublisher_Unsubscribe(t *testing.T) {
+	publisher := NewTopicPublisher[string](context.Background())
+	sub := &recordingSubscriber[string]{id: "sub"}
+
+	require.NoError(t, publisher.Subscribe("rooms/1", sub))
+	require.NoError(t, publisher.Subscribe("rooms/#", sub))
+
+	publisher.UnsubscribePattern("rooms/#", "sub")
+	assert.Equal(t, 0, publisher.Publish("rooms/2", "a"))
+	assert.Equal(t, 1, publisher.Publish("rooms/1", "a"))
+
+	publisher.Unsubscribe("sub")
+	assert.Equal(t, 0, publisher.Publish("rooms/1", "a"))
+	assert.Equal(t, 0, publisher.CountSubscriptions())
+	assert.True(t, publisher.root.empty())
+}
+
+func TestTopicPublisher_InvalidPatterns(t *testing.T) {
+	publisher := NewTopicPublisher[string](context.Background())
+	sub := &recordingSubscriber[string]{id: "sub"}
+
+	assert.Error(t, publisher.Subscribe("", sub))
+	assert.Error(t, publisher.Subscribe("rooms/#/messages", sub))
+	assert.Error(t, publisher.Subscribe("rooms/1*", sub))
+	assert.Error(t, publisher.Subscribe("rooms/1", nil))
+}
@@ -1,6 +1,7 @@
 package time
 
 import (
+	"strconv"
 	gotime "time"
 
 	"github.com/pixie-sh/errors-go"
@@ -33,7 +34,7 @@ func NewDuration[vv float64 | string](v vv) (Duration, error) {
 }
 
 func (d Duration) MarshalJSON() ([]byte, error) {
-	return serializer.Serialize(gotime.Duration(d).String())
+	return []byte(strconv.Quote(gotime.Duration(d).String())), nil
 }
 
 func (d *Duration) UnmarshalJSON(b []byte) error {
@@ -93,3 +94,19 @@ func (d Duration) Minutes() float64 {
 func (d Duration) Seconds() float64 {
 	return d.Duration().Seconds()
 }
+
+// MarshalText text representation, used by text based formats like TOML
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(gotime.Duration(d).String()), nil
+}
+
+// UnmarshalText parses a go duration string, used by text based formats like TOML
+func (d *Duration) UnmarshalText(b []byte) error {
+	duration, err := gotime.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+	return nil
+}
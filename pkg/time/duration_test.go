@@ -1,6 +1,7 @@
 package time
 
 import (
+	"encoding/json"
 	"testing"
 	gotime "time"
 )
@@ -95,3 +96,36 @@ func TestDurationParseInvalid(t *testing.T) {
 		}
 	}
 }
+
+func TestDurationText(t *testing.T) {
+	var d Duration
+	if err := d.UnmarshalText([]byte("48h")); err != nil {
+		t.Fatalf("Duration.UnmarshalText() error = %v", err)
+	}
+	if d != Duration(48*gotime.Hour) {
+		t.Errorf("Duration.UnmarshalText() = %v, want %v", d, 48*gotime.Hour)
+	}
+
+	text, err := d.MarshalText()
+	if err != nil || string(text) != "48h0m0s" {
+		t.Errorf("Duration.MarshalText() = %s, %v", text, err)
+	}
+
+	if err := d.UnmarshalText([]byte("invalid")); err == nil {
+		t.Error("Duration.UnmarshalText() expected error")
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	blob, err := json.Marshal(struct {
+		After Duration `json:"after"`
+	}{Duration(90 * gotime.Minute)})
+	if err != nil || string(blob) != `{"after":"1h30m0s"}` {
+		t.Fatalf("Duration.MarshalJSON() = %s, %v", blob, err)
+	}
+
+	var d Duration
+	if err := json.Unmarshal([]byte(`"1h30m0s"`), &d); err != nil || d != Duration(90*gotime.Minute) {
+		t.Errorf("Duration.UnmarshalJSON() = %v, %v", d, err)
+	}
+}